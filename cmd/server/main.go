@@ -7,7 +7,9 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 
 	"pom/internal/api"
+	"pom/internal/api/handlers"
 	models "pom/internal/db"
+	"pom/internal/notesync"
 )
 
 // Update the main function to initialize admin user
@@ -25,6 +27,10 @@ func main() {
 	models.InitDB()
 	// Initialize admin user
 	models.InitializeAdminUser()
+	// Pick up the timers that were running before a restart
+	handlers.RestoreTimers()
+	// Start syncing notes with a Markdown folder if configured
+	notesync.StartFromEnv()
 	// Set up routes
 	api.SetupRoutes(e)
 
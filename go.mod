@@ -3,15 +3,18 @@ module pom
 go 1.24.2
 
 require (
-	github.com/golang-jwt/jwt/v4 v4.5.2 // indirect
-	github.com/labstack/echo/v4 v4.13.3 // indirect
+	github.com/golang-jwt/jwt/v4 v4.5.2
+	github.com/labstack/echo/v4 v4.13.3
+	github.com/mattn/go-sqlite3 v1.14.28
+	golang.org/x/crypto v0.31.0
+)
+
+require (
 	github.com/labstack/gommon v0.4.2 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
-	github.com/mattn/go-sqlite3 v1.14.28 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
-	golang.org/x/crypto v0.31.0 // indirect
 	golang.org/x/net v0.33.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
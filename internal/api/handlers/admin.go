@@ -26,3 +26,84 @@ func CheckDatabaseIntegrityHandler(c echo.Context) error {
 		return c.HTML(http.StatusOK, `<div class="alert-error">Database integrity check failed. Please backup your data and consider rebuilding the database.</div>`)
 	}
 }
+
+// Orphaned session bulk request structure, all selects every orphaned session
+type OrphanedSessionsRequest struct {
+	SessionIDs []int `json:"session_ids"`
+	All        bool  `json:"all"`
+	UserID     int   `json:"user_id"` // Only for assigning
+}
+
+// Helper function to get the sessions a bulk request is about
+func orphanedSessionIDs(req *OrphanedSessionsRequest) ([]int, error) {
+	if req.All {
+		return models.GetOrphanedSessionIDs()
+	}
+	return req.SessionIDs, nil
+}
+
+// List the sessions without an owner with a preview of their notes
+func GetOrphanedSessionsHandler(c echo.Context) error {
+	sessions, err := models.GetOrphanedSessions()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, sessions)
+}
+
+// Give orphaned sessions to a user
+func AssignOrphanedSessionsHandler(c echo.Context) error {
+	req := new(OrphanedSessionsRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+	if !req.All && len(req.SessionIDs) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "session_ids or all is required"})
+	}
+
+	if _, err := models.GetUserByID(req.UserID); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+
+	ids, err := orphanedSessionIDs(req)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	assigned, err := models.AssignOrphanedSessions(ids, req.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":  "Sessions assigned successfully",
+		"assigned": assigned,
+	})
+}
+
+// Delete orphaned sessions with their pomodoros, breaks and notes
+func DeleteOrphanedSessionsHandler(c echo.Context) error {
+	req := new(OrphanedSessionsRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+	if !req.All && len(req.SessionIDs) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "session_ids or all is required"})
+	}
+
+	ids, err := orphanedSessionIDs(req)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	deleted, err := models.DeleteOrphanedSessions(ids)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Sessions deleted successfully",
+		"deleted": deleted,
+	})
+}
@@ -90,6 +90,16 @@ func UpdateUserHandler(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
+	// A new password logs the user out everywhere, like a reset
+	if userInput.Password != "" {
+		if _, err := models.DeleteLoginSessionsForUser(id, 0); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		if err := models.DeleteAccessTokensForUser(id); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+	}
+
 	return c.JSON(http.StatusOK, map[string]string{"message": "User updated successfully"})
 }
 
@@ -112,6 +122,11 @@ func DeleteUserHandler(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
+	// Log the user out everywhere
+	if _, err := models.DeleteLoginSessionsForUser(id, 0); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
 	return c.JSON(http.StatusOK, map[string]string{"message": "User deleted successfully"})
 }
 
@@ -192,6 +207,15 @@ func ResetPasswordHandler(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
+	// The old password may have leaked, so log the user out everywhere and
+	// revoke the access tokens it could have minted
+	if _, err := models.DeleteLoginSessionsForUser(id, 0); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if err := models.DeleteAccessTokensForUser(id); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
 	// Return the new plaintext password to the admin
 	return c.JSON(http.StatusOK, map[string]string{
 		"message":  "Password reset successfully",
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	middleauth "pom/internal/api/middleware"
+	models "pom/internal/db"
+	"pom/internal/ical"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Days of history in the calendar feed, unless the URL asks for another number
+const (
+	defaultFeedDays = 90
+	maxFeedDays     = 365
+	maxFeedEvents   = 1000
+)
+
+// How long a rendered feed is served from memory. Calendar clients poll
+// every few minutes to hours, so a feed a few minutes old is fine.
+const feedCacheTTL = 5 * time.Minute
+
+type cachedFeed struct {
+	body    string
+	etag    string
+	expires time.Time
+}
+
+var feedCache = struct {
+	sync.Mutex
+	entries map[string]cachedFeed
+}{entries: make(map[string]cachedFeed)}
+
+// Helper function to build the public URL of a feed token
+func calendarFeedURL(c echo.Context, token string) string {
+	return fmt.Sprintf("%s://%s/calendar/%s.ics", c.Scheme(), c.Request().Host, token)
+}
+
+// Helper function to drop the cached feeds of a user
+func invalidateFeedCache(userID int) {
+	prefix := strconv.Itoa(userID) + ":"
+	feedCache.Lock()
+	defer feedCache.Unlock()
+	for key := range feedCache.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(feedCache.entries, key)
+		}
+	}
+}
+
+// Get the calendar feed URL of the current user
+func GetCalendarFeedHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	feed, err := models.GetCalendarFeed(currentUser.ID)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Calendar feed not enabled"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"url":        calendarFeedURL(c, feed.Token),
+		"created_at": feed.CreatedAt,
+	})
+}
+
+// Enable the calendar feed of the current user, or replace its URL if it is
+// already enabled
+func CreateCalendarFeedHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	feed, err := models.CreateCalendarFeed(currentUser.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	invalidateFeedCache(currentUser.ID)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"url":        calendarFeedURL(c, feed.Token),
+		"created_at": feed.CreatedAt,
+	})
+}
+
+// Disable the calendar feed of the current user
+func DeleteCalendarFeedHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	if err := models.DeleteCalendarFeed(currentUser.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	invalidateFeedCache(currentUser.ID)
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Calendar feed disabled successfully"})
+}
+
+// Serve the completed sessions of the token's owner as an iCalendar feed.
+// Public, the token in the URL is the credential. Takes days, the number
+// of days of history to include.
+func CalendarFeedHandler(c echo.Context) error {
+	token := strings.TrimSuffix(c.Param("token"), ".ics")
+	user, err := models.GetUserByCalendarToken(token)
+	if err == sql.ErrNoRows {
+		return c.String(http.StatusNotFound, "Calendar not found")
+	}
+	if err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+
+	days := defaultFeedDays
+	if daysStr := c.QueryParam("days"); daysStr != "" {
+		days, err = strconv.Atoi(daysStr)
+		if err != nil || days < 1 || days > maxFeedDays {
+			return c.String(http.StatusBadRequest, fmt.Sprintf("days must be between 1 and %d", maxFeedDays))
+		}
+	}
+
+	key := fmt.Sprintf("%d:%d", user.ID, days)
+	feedCache.Lock()
+	feed, ok := feedCache.entries[key]
+	feedCache.Unlock()
+
+	if !ok || time.Now().After(feed.expires) {
+		body, err := renderCalendarFeed(user, days)
+		if err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		sum := sha256.Sum256([]byte(body))
+		feed = cachedFeed{
+			body:    body,
+			etag:    `"` + hex.EncodeToString(sum[:8]) + `"`,
+			expires: time.Now().Add(feedCacheTTL),
+		}
+
+		feedCache.Lock()
+		for cachedKey, entry := range feedCache.entries {
+			if time.Now().After(entry.expires) {
+				delete(feedCache.entries, cachedKey)
+			}
+		}
+		feedCache.entries[key] = feed
+		feedCache.Unlock()
+	}
+
+	c.Response().Header().Set("ETag", feed.etag)
+	c.Response().Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(feedCacheTTL.Seconds())))
+	if c.Request().Header.Get("If-None-Match") == feed.etag {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, `inline; filename="pomonotes.ics"`)
+	return c.Blob(http.StatusOK, "text/calendar; charset=utf-8", []byte(feed.body))
+}
+
+// Helper function to render the feed of a user's completed sessions
+func renderCalendarFeed(user models.User, days int) (string, error) {
+	now := time.Now().UTC()
+	startDate := now.AddDate(0, 0, -days).Format("2006-01-02T15:04:05.000Z")
+	endDate := now.Format("2006-01-02T15:04:05.000Z")
+
+	sessions, err := models.GetSessionsByDateRangeForUser(startDate, endDate, user.ID, nil)
+	if err != nil {
+		return "", err
+	}
+
+	projects, err := models.GetProjectsForUser(user.ID, true)
+	if err != nil {
+		return "", err
+	}
+	projectNames := make(map[int]string)
+	for _, project := range projects {
+		projectNames[project.ID] = project.Name
+	}
+
+	// Newest first, so the limit keeps the most recent sessions
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartTime > sessions[j].StartTime
+	})
+
+	type feedSession struct {
+		session    models.Session
+		start, end time.Time
+	}
+	feedSessions := make([]feedSession, 0, len(sessions))
+	sessionIDs := make([]int, 0, len(sessions))
+	for _, session := range sessions {
+		if len(feedSessions) >= maxFeedEvents {
+			break
+		}
+		if session.Status != "completed" || session.EndTime == nil {
+			continue
+		}
+		start, err := time.Parse(time.RFC3339, session.StartTime)
+		if err != nil {
+			continue
+		}
+		end, err := time.Parse(time.RFC3339, *session.EndTime)
+		if err != nil || end.Before(start) {
+			continue
+		}
+		feedSessions = append(feedSessions, feedSession{session, start, end})
+		sessionIDs = append(sessionIDs, session.ID)
+	}
+
+	notesBySession, err := models.GetNotesForSessions(sessionIDs)
+	if err != nil {
+		return "", err
+	}
+
+	calendar := ical.Calendar{
+		ProdID: "-//Pomonotes//Focus sessions//EN",
+		Name:   "Pomonotes - " + user.Username,
+	}
+	// DTSTAMP is the end of the newest session rather than the render time,
+	// so unchanged data renders to the same feed and keeps its ETag
+	var stamp time.Time
+	for _, item := range feedSessions {
+		session, start, end := item.session, item.start, item.end
+		if end.After(stamp) {
+			stamp = end
+		}
+
+		tags := ParseTagsFromQueryParam(session.Tags)
+		summary := strings.Join(tags, ", ")
+		if session.ProjectID != nil {
+			if name, ok := projectNames[*session.ProjectID]; ok {
+				if summary != "" {
+					summary = name + ": " + summary
+				} else {
+					summary = name
+				}
+			}
+		}
+		if summary == "" {
+			summary = "Focus session"
+		}
+
+		// Notes come newest first, the description reads in writing order
+		notes := notesBySession[session.ID]
+		texts := make([]string, 0, len(notes))
+		for i := len(notes) - 1; i >= 0; i-- {
+			if text := strings.TrimSpace(notes[i].NoteText); text != "" {
+				texts = append(texts, text)
+			}
+		}
+
+		calendar.Events = append(calendar.Events, ical.Event{
+			UID:         ical.UID("session", session.ID, "pomonotes"),
+			Start:       start,
+			End:         end,
+			Summary:     summary,
+			Description: strings.Join(texts, "\n\n"),
+			Categories:  tags,
+		})
+	}
+
+	return calendar.Render(stamp), nil
+}
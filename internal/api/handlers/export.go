@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	middleauth "pom/internal/api/middleware"
+	models "pom/internal/db"
+	"pom/internal/markdown"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Export the notes of the current user as a zip with one Markdown file per session
+func ExportNotesHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	sessions, err := models.GetSessionsForUser(currentUser.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	// Render everything before the response starts so that a failure can
+	// still be reported
+	contents := make([]string, len(sessions))
+	for i, session := range sessions {
+		contents[i], err = markdown.Session(session, false)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("Failed to export session %d: %v", session.ID, err)})
+		}
+	}
+
+	filename := fmt.Sprintf("pomonotes-notes-%s.zip", time.Now().Format("2006-01-02"))
+	c.Response().Header().Set(echo.HeaderContentType, "application/zip")
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Response().WriteHeader(http.StatusOK)
+
+	// The zip is streamed, so errors past this point can only be logged. The
+	// archive is left unfinished then, a truncated download mustn't look complete.
+	archive := zip.NewWriter(c.Response())
+	for i, session := range sessions {
+		file, err := archive.Create(markdown.SessionFilename(session))
+		if err != nil {
+			log.Printf("Error adding session %d to export: %v\n", session.ID, err)
+			return nil
+		}
+		if _, err := file.Write([]byte(contents[i])); err != nil {
+			log.Printf("Error writing session %d to export: %v\n", session.ID, err)
+			return nil
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		log.Printf("Error finishing notes export: %v\n", err)
+	}
+	return nil
+}
+
+// Export all data of the current user as a versioned JSON document
+func ExportAccountHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	export, err := models.ExportUserData(currentUser.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	filename := fmt.Sprintf("pomonotes-%s-%s.json", currentUser.Username, time.Now().Format("2006-01-02"))
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+	return c.JSON(http.StatusOK, export)
+}
+
+// Import a document made by ExportAccountHandler into the current user's account
+func ImportAccountHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	data := new(models.AccountExport)
+	if err := c.Bind(data); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	summary, err := models.ImportUserData(currentUser.ID, *data)
+	if errors.Is(err, models.ErrUnsupportedExport) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Import completed successfully",
+		"summary": summary,
+	})
+}
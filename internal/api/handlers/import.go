@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	middleauth "pom/internal/api/middleware"
+	"pom/internal/importer"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Largest file accepted by the session importer
+const maxImportFileSize = 10 << 20
+
+// Helper function to read the uploaded time tracker export into an import
+// plan. The multipart form carries the file, its format (toggl, clockify or
+// csv), the timezone of times without one and, for generic CSV files, the
+// column mapping as JSON.
+func buildImportPlan(c echo.Context, userID int) (importer.Plan, int, error) {
+	var opts importer.Options
+
+	if mapping := c.FormValue("mapping"); mapping != "" {
+		if err := json.Unmarshal([]byte(mapping), &opts.Mapping); err != nil {
+			return importer.Plan{}, http.StatusBadRequest, errors.New("mapping must be a JSON object")
+		}
+	}
+
+	if timezone := c.FormValue("timezone"); timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return importer.Plan{}, http.StatusBadRequest, errors.New("unknown timezone")
+		}
+		opts.Location = loc
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return importer.Plan{}, http.StatusBadRequest, errors.New("a file is required")
+	}
+	if fileHeader.Size > maxImportFileSize {
+		return importer.Plan{}, http.StatusRequestEntityTooLarge, errors.New("the file is too large")
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return importer.Plan{}, http.StatusBadRequest, err
+	}
+	defer file.Close()
+
+	format := c.FormValue("format")
+	if format == "" {
+		format = "csv"
+	}
+	entries, rowErrors, err := importer.Parse(format, file, opts)
+	if err != nil {
+		return importer.Plan{}, http.StatusBadRequest, err
+	}
+
+	plan, err := importer.BuildPlan(userID, entries, rowErrors)
+	if err != nil {
+		return importer.Plan{}, http.StatusInternalServerError, err
+	}
+	return plan, http.StatusOK, nil
+}
+
+// Show what importing a time tracker export would do without writing anything
+func PreviewSessionImportHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	plan, status, err := buildImportPlan(c, currentUser.ID)
+	if err != nil {
+		return c.JSON(status, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, plan)
+}
+
+// Import the sessions of a time tracker export. Takes the same form as the
+// preview, conflicting rows are only imported with include_conflicts=true.
+func ImportSessionsHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	plan, status, err := buildImportPlan(c, currentUser.ID)
+	if err != nil {
+		return c.JSON(status, map[string]string{"error": err.Error()})
+	}
+
+	result, err := importer.Commit(currentUser.ID, plan, c.FormValue("include_conflicts") == "true")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	// Rows that couldn't be read are reported along with the failed writes
+	result.Errors = append(plan.Errors, result.Errors...)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Import completed successfully",
+		"summary": result,
+	})
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"net/http"
+	middleauth "pom/internal/api/middleware"
+	models "pom/internal/db"
+	"pom/internal/timer"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Log interruption request structure
+type InterruptionRequest struct {
+	Type       string  `json:"type"`        // "internal" or "external", or their marks ' and -
+	Reason     *string `json:"reason"`      // Optional
+	PomodoroID *int    `json:"pomodoro_id"` // Defaults to the pomodoro of the running timer
+}
+
+// Log an interruption of a running pomodoro
+func LogInterruptionHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	req := new(InterruptionRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	switch req.Type {
+	case models.InterruptionInternal, "'":
+		req.Type = models.InterruptionInternal
+	case models.InterruptionExternal, "-":
+		req.Type = models.InterruptionExternal
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Type must be internal or external"})
+	}
+	if req.Reason != nil {
+		if reason := strings.TrimSpace(*req.Reason); reason != "" {
+			req.Reason = &reason
+		} else {
+			req.Reason = nil
+		}
+	}
+
+	pomodoroID := 0
+	if req.PomodoroID != nil {
+		pomodoroID = *req.PomodoroID
+	} else {
+		snapshot := timerEngine.Status(currentUser.ID)
+		if snapshot.Active && snapshot.Interval == timer.PhaseWork {
+			pomodoroID = snapshot.PomodoroID
+		}
+	}
+	if pomodoroID == 0 {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "No pomodoro is running"})
+	}
+
+	pomodoro, err := models.GetPomodoro(pomodoroID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Pomodoro not found"})
+	}
+	if err := middleauth.AuthorizeSession(c, pomodoro.SessionID); err != nil {
+		return middleauth.AuthorizationError(c, err, "Pomodoro")
+	}
+	if pomodoro.Status != "running" {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Interruptions can only be logged during a running pomodoro"})
+	}
+
+	interruptionID, err := models.CreateInterruption(models.Interruption{
+		SessionID:  pomodoro.SessionID,
+		PomodoroID: pomodoro.ID,
+		Type:       req.Type,
+		Reason:     req.Reason,
+		CreatedAt:  time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message":     "Interruption logged successfully",
+		"id":          interruptionID,
+		"pomodoro_id": pomodoro.ID,
+	})
+}
+
+// Get the interruptions of a pomodoro
+func GetInterruptionsHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid pomodoro ID"})
+	}
+
+	interruptions, err := models.GetInterruptions(id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, interruptions)
+}
+
+// Count interruptions per day or per tag (group) between from and to
+// (YYYY-MM-DD, the last 30 days by default)
+func GetInterruptionReportHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from, to := today.AddDate(0, 0, -29), today
+	if fromStr := c.QueryParam("from"); fromStr != "" {
+		if from, err = time.Parse("2006-01-02", fromStr); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "from must be a date in YYYY-MM-DD format"})
+		}
+	}
+	if toStr := c.QueryParam("to"); toStr != "" {
+		if to, err = time.Parse("2006-01-02", toStr); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "to must be a date in YYYY-MM-DD format"})
+		}
+	}
+	if to.Before(from) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "to must not be before from"})
+	}
+
+	groupBy := c.QueryParam("group")
+	if groupBy == "" {
+		groupBy = "day"
+	}
+	if groupBy != "day" && groupBy != "tag" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "group must be day or tag"})
+	}
+
+	stats, err := models.GetInterruptionStats(currentUser.ID,
+		from.Format("2006-01-02T15:04:05.000Z"), to.Add(24*time.Hour-time.Millisecond).Format("2006-01-02T15:04:05.000Z"), groupBy)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"from":  from.Format("2006-01-02"),
+		"to":    to.Format("2006-01-02"),
+		"group": groupBy,
+		"stats": stats,
+	})
+}
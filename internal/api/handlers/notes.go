@@ -1,9 +1,15 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"net/http"
+	middleauth "pom/internal/api/middleware"
 	models "pom/internal/db"
+	"pom/internal/textdiff"
 	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
@@ -15,6 +21,13 @@ func CreateNoteHandler(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
 	}
 
+	if err := middleauth.AuthorizeSession(c, note.SessionID); err != nil {
+		return middleauth.AuthorizationError(c, err, "Session")
+	}
+	if msg := checkSessionPomodoro(note.SessionID, note.PomodoroID); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+
 	err := models.CreateNote(note.SessionID, note.PomodoroID, note.NoteText)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
@@ -37,8 +50,29 @@ func GetNotesHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, notes)
 }
 
+// Get one note, with its version as the ETag for a later update
+func GetNoteHandler(c echo.Context) error {
+	noteID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid note ID"})
+	}
+
+	note, err := models.GetNote(noteID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Note not found"})
+	}
+
+	setETag(c, note.Version)
+	return c.JSON(http.StatusOK, note)
+}
+
 func GetAllNotesHandler(c echo.Context) error {
-	notes, err := models.GetAllNotes()
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	notes, err := models.GetAllNotesForUser(currentUser.ID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
@@ -46,6 +80,64 @@ func GetAllNotesHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, notes)
 }
 
+// Full-text search over the notes of the current user's sessions
+func SearchNotesHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	opts := models.NoteSearchOptions{
+		Query:  c.QueryParam("q"),
+		From:   c.QueryParam("from"),
+		To:     c.QueryParam("to"),
+		Tags:   ParseTagsFromQueryParam(c.QueryParam("tags")),
+		Limit:  20,
+		Offset: 0,
+	}
+
+	if opts.Query == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Query parameter q is required"})
+	}
+
+	// Dates are inclusive days
+	for _, date := range []string{opts.From, opts.To} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Dates must be in YYYY-MM-DD format"})
+		}
+	}
+
+	if limitStr := c.QueryParam("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > 100 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Limit must be between 1 and 100"})
+		}
+		opts.Limit = limit
+	}
+	if offsetStr := c.QueryParam("offset"); offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid offset"})
+		}
+		opts.Offset = offset
+	}
+
+	results, total, err := models.SearchNotes(currentUser.ID, opts)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"results": results,
+		"total":   total,
+		"limit":   opts.Limit,
+		"offset":  opts.Offset,
+	})
+}
+
 func UpdateNoteHandler(c echo.Context) error {
 	// Get the note ID from the URL parameter
 	noteID, err := strconv.Atoi(c.Param("id"))
@@ -53,6 +145,17 @@ func UpdateNoteHandler(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid note ID"})
 	}
 
+	existing, err := models.GetNote(noteID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Note not found"})
+	}
+
+	// Refuse to overwrite an edit made from another device
+	ifVersion, ok := checkIfMatch(c, existing.Version)
+	if !ok {
+		return preconditionFailed(c, existing, existing.Version)
+	}
+
 	// Bind the request body to a Note struct
 	note := new(models.Note)
 	if err := c.Bind(note); err != nil {
@@ -60,10 +163,23 @@ func UpdateNoteHandler(c echo.Context) error {
 	}
 
 	// Update the note in the database
-	if err := models.UpdateNote(noteID, note.NoteText); err != nil {
+	err = models.UpdateNote(noteID, note.NoteText, ifVersion)
+	if errors.Is(err, models.ErrVersionConflict) {
+		if current, err := models.GetNote(noteID); err == nil {
+			return preconditionFailed(c, current, current.Version)
+		}
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Note not found"})
+	}
+	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
+	if updated, err := models.GetNote(noteID); err == nil {
+		setETag(c, updated.Version)
+	}
+
 	return c.JSON(http.StatusOK, map[string]string{"message": "Note updated successfully"})
 }
 
@@ -75,9 +191,122 @@ func DeleteNoteHandler(c echo.Context) error {
 	}
 
 	// Delete the note from the database
-	if err := models.DeleteNote(noteID); err != nil {
+	err = models.DeleteNote(noteID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Note not found"})
+	}
+	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{"message": "Note deleted successfully"})
 }
+
+// Helper function to load the revisions of a note, ownership is checked by
+// the route
+func loadNoteRevisions(c echo.Context) ([]models.NoteRevision, int, string) {
+	noteID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, http.StatusBadRequest, "Invalid note ID"
+	}
+
+	revisions, err := models.GetNoteRevisions(noteID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err.Error()
+	}
+	if len(revisions) == 0 {
+		return nil, http.StatusNotFound, "Note not found"
+	}
+
+	return revisions, http.StatusOK, ""
+}
+
+// List the revisions of a note, oldest first
+func GetNoteRevisionsHandler(c echo.Context) error {
+	revisions, status, msg := loadNoteRevisions(c)
+	if status != http.StatusOK {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+
+	return c.JSON(http.StatusOK, revisions)
+}
+
+// Unified diff between two revisions of a note. Without parameters the
+// latest revision is compared with the one before it.
+func GetNoteRevisionDiffHandler(c echo.Context) error {
+	revisions, status, msg := loadNoteRevisions(c)
+	if status != http.StatusOK {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+
+	// Find a revision by the ID in a query parameter, with a fallback index
+	pick := func(param string, fallback int) (models.NoteRevision, bool) {
+		value := c.QueryParam(param)
+		if value == "" {
+			return revisions[max(fallback, 0)], true
+		}
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return models.NoteRevision{}, false
+		}
+		for _, revision := range revisions {
+			if revision.ID == id {
+				return revision, true
+			}
+		}
+		return models.NoteRevision{}, false
+	}
+
+	to, ok := pick("to", len(revisions)-1)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Revision not found"})
+	}
+
+	toIndex := 0
+	for i, revision := range revisions {
+		if revision.ID == to.ID {
+			toIndex = i
+		}
+	}
+	from, ok := pick("from", toIndex-1)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Revision not found"})
+	}
+
+	diff := textdiff.Unified(from.NoteText, to.NoteText,
+		fmt.Sprintf("revision %d (%s)", from.ID, from.CreatedAt),
+		fmt.Sprintf("revision %d (%s)", to.ID, to.CreatedAt),
+		textdiff.DefaultContext)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"from": from.ID,
+		"to":   to.ID,
+		"diff": diff,
+	})
+}
+
+// Restore a note to one of its revisions, undeleting it if needed
+func RestoreNoteRevisionHandler(c echo.Context) error {
+	revisions, status, msg := loadNoteRevisions(c)
+	if status != http.StatusOK {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+
+	revisionID, err := strconv.Atoi(c.Param("revision_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid revision ID"})
+	}
+
+	err = models.RestoreNoteRevision(revisions[0].NoteID, revisionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Revision not found"})
+	}
+	if errors.Is(err, models.ErrNoteSessionDeleted) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "The session of this note has been deleted, its text can only be read"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Note restored successfully"})
+}
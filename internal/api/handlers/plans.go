@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	middleauth "pom/internal/api/middleware"
+	models "pom/internal/db"
+	"pom/internal/ical"
+	"pom/internal/timer"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Longest range the reconciliation view covers at once
+const maxReconcileDays = 366
+
+var errPlanNotFound = errors.New("planned session not found")
+
+// Helper function to load a planned session owned by the current user
+func getOwnedPlannedSession(idParam string, userID int) (models.PlannedSession, error) {
+	id, err := strconv.Atoi(idParam)
+	if err != nil {
+		return models.PlannedSession{}, errPlanNotFound
+	}
+
+	plan, err := models.GetPlannedSession(id)
+	if err != nil || plan.UserID != userID {
+		return models.PlannedSession{}, errPlanNotFound
+	}
+	return plan, nil
+}
+
+// Helper function to get the pomodoro and short break lengths of the user's
+// default profile, which turn pomodoro counts into durations and back
+func planCycle(userID int) (int, int, error) {
+	profile, err := resolveTimerProfile(userID, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	if profile == nil {
+		return timer.DefaultSettings.WorkLength, timer.DefaultSettings.ShortBreakLength, nil
+	}
+	return profile.WorkLength, profile.ShortBreakLength, nil
+}
+
+// Planned session handlers
+func GetPlannedSessionsHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	plans, err := models.GetPlannedSessionsForUser(currentUser.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, plans)
+}
+
+func GetPlannedSessionHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	plan, err := getOwnedPlannedSession(c.Param("id"), currentUser.ID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Planned session not found"})
+	}
+
+	return c.JSON(http.StatusOK, plan)
+}
+
+// Schedule a focus block. Takes start_time (RFC 3339), expected_pomodoros,
+// and optionally title, duration (seconds, derived from the pomodoro count
+// when left out), tags, project_id, recurrence (RRULE), exdates (RFC 3339
+// starts of occurrences to leave out) and timezone.
+func CreatePlannedSessionHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	plan := new(models.PlannedSession)
+	if err := c.Bind(plan); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	start, err := time.Parse(time.RFC3339, plan.StartTime)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "start_time must be an RFC 3339 date and time"})
+	}
+	plan.StartTime = start.UTC().Format("2006-01-02T15:04:05.000Z")
+
+	if plan.ExpectedPomodoros == 0 {
+		plan.ExpectedPomodoros = 1
+	}
+	if plan.ExpectedPomodoros < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "expected_pomodoros must be positive"})
+	}
+	if plan.Duration < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "duration must be positive"})
+	}
+	if plan.Duration == 0 {
+		work, shortBreak, err := planCycle(currentUser.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		plan.Duration = plan.ExpectedPomodoros*work + (plan.ExpectedPomodoros-1)*shortBreak
+	}
+
+	if plan.Timezone == "" {
+		plan.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(plan.Timezone); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unknown timezone"})
+	}
+
+	if plan.Recurrence != nil {
+		if *plan.Recurrence == "" {
+			plan.Recurrence = nil
+		} else {
+			rule, err := ical.ParseRule(*plan.Recurrence)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			}
+			recurrence := rule.String()
+			plan.Recurrence = &recurrence
+		}
+	}
+	for i, value := range plan.ExDates {
+		exdate, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "exdates must be RFC 3339 dates and times"})
+		}
+		plan.ExDates[i] = exdate.UTC().Format("2006-01-02T15:04:05.000Z")
+	}
+
+	if err := checkSessionProject(currentUser.ID, plan.ProjectID, false); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	plan.Tags = strings.Join(ParseTagsFromQueryParam(plan.Tags), ",")
+	plan.ICalUID = nil
+
+	planID, err := models.CreatePlannedSession(currentUser.ID, *plan)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message": "Planned session created successfully",
+		"id":      planID,
+	})
+}
+
+func DeletePlannedSessionHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	plan, err := getOwnedPlannedSession(c.Param("id"), currentUser.ID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Planned session not found"})
+	}
+
+	if err := models.DeletePlannedSession(plan.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Planned session deleted successfully"})
+}
+
+// Mark every planned occurrence between from and to (inclusive YYYY-MM-DD
+// dates, the last 7 days by default) as done, partial, missed or upcoming
+func ReconcilePlannedSessionsHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from, to := today.AddDate(0, 0, -6), today
+	if fromStr := c.QueryParam("from"); fromStr != "" {
+		if from, err = time.Parse("2006-01-02", fromStr); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "from must be a date in YYYY-MM-DD format"})
+		}
+	}
+	if toStr := c.QueryParam("to"); toStr != "" {
+		if to, err = time.Parse("2006-01-02", toStr); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "to must be a date in YYYY-MM-DD format"})
+		}
+	}
+	if to.Before(from) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "to must not be before from"})
+	}
+	if to.Sub(from) > maxReconcileDays*24*time.Hour {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("The range can't be longer than %d days", maxReconcileDays)})
+	}
+
+	occurrences, err := models.ReconcilePlannedSessions(currentUser.ID, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	summary := map[string]int{
+		models.PlanStatusDone: 0, models.PlanStatusPartial: 0, models.PlanStatusMissed: 0, models.PlanStatusUpcoming: 0,
+	}
+	for _, occurrence := range occurrences {
+		summary[occurrence.Status]++
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"from":        from.Format("2006-01-02"),
+		"to":          to.Format("2006-01-02"),
+		"occurrences": occurrences,
+		"summary":     summary,
+	})
+}
+
+// Import plans from an uploaded .ics file. Every timed event becomes a plan,
+// with the expected pomodoros worked out from its length. An event moving a
+// single occurrence of a series (RECURRENCE-ID) becomes a plan of its own and
+// the occurrence is left out of the series. Takes the file and optionally the
+// timezone of times without one.
+func ImportPlannedSessionsHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	loc := time.UTC
+	if timezone := c.FormValue("timezone"); timezone != "" {
+		if loc, err = time.LoadLocation(timezone); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unknown timezone"})
+		}
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "A file is required"})
+	}
+	if fileHeader.Size > maxImportFileSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "The file is too large"})
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	defer file.Close()
+
+	events, err := ical.Parse(file, loc)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	work, shortBreak, err := planCycle(currentUser.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	// Overrides share the UID of their series, which leaves out the
+	// occurrence they replace
+	series := make(map[string]*ical.Event)
+	for i := range events {
+		if events[i].RecurrenceID.IsZero() && events[i].RRule != "" {
+			series[events[i].UID] = &events[i]
+		}
+	}
+	for _, event := range events {
+		if master, ok := series[event.UID]; ok && !event.RecurrenceID.IsZero() {
+			master.ExDates = append(master.ExDates, event.RecurrenceID)
+		}
+	}
+
+	var plans []models.PlannedSession
+	skipped := []map[string]string{}
+	for _, event := range events {
+		if event.Cancelled {
+			skipped = append(skipped, map[string]string{"uid": event.UID, "summary": event.Summary, "reason": "cancelled event"})
+			continue
+		}
+		if event.AllDay {
+			skipped = append(skipped, map[string]string{"uid": event.UID, "summary": event.Summary, "reason": "all-day event"})
+			continue
+		}
+
+		duration := int(event.End.Sub(event.Start).Seconds())
+		if duration <= 0 {
+			skipped = append(skipped, map[string]string{"uid": event.UID, "summary": event.Summary, "reason": "event has no length"})
+			continue
+		}
+
+		var recurrence *string
+		exdates := []string{}
+		if event.RRule != "" && event.RecurrenceID.IsZero() {
+			rule, err := ical.ParseRule(event.RRule)
+			if err != nil {
+				skipped = append(skipped, map[string]string{"uid": event.UID, "summary": event.Summary, "reason": err.Error()})
+				continue
+			}
+			value := rule.String()
+			recurrence = &value
+
+			for _, exdate := range event.ExDates {
+				exdates = append(exdates, exdate.UTC().Format("2006-01-02T15:04:05.000Z"))
+			}
+		}
+
+		// A pomodoro and its break make a cycle, the last break isn't needed
+		pomodoros := int(math.Round(float64(duration+shortBreak) / float64(work+shortBreak)))
+		if pomodoros < 1 {
+			pomodoros = 1
+		}
+
+		plan := models.PlannedSession{
+			Title:             event.Summary,
+			StartTime:         event.Start.UTC().Format("2006-01-02T15:04:05.000Z"),
+			Duration:          duration,
+			ExpectedPomodoros: pomodoros,
+			Tags:              strings.Join(event.Categories, ","),
+			Recurrence:        recurrence,
+			ExDates:           exdates,
+			Timezone:          event.Start.Location().String(),
+		}
+		if event.UID != "" {
+			uid := event.UID
+			// Keep an override apart from its series when importing again
+			if !event.RecurrenceID.IsZero() {
+				uid += "#" + event.RecurrenceID.UTC().Format("20060102T150405Z")
+			}
+			plan.ICalUID = &uid
+		}
+		plans = append(plans, plan)
+	}
+
+	created, updated, err := models.ImportPlannedSessions(currentUser.ID, plans)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Import completed successfully",
+		"created": created,
+		"updated": updated,
+		"skipped": skipped,
+	})
+}
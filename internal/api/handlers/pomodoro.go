@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	middleauth "pom/internal/api/middleware"
 	models "pom/internal/db"
+	"pom/internal/timer"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
@@ -15,8 +20,19 @@ func CreatePomodoroHandler(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
 	}
 
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+	if err := middleauth.AuthorizeSession(c, pomodoro.SessionID); err != nil {
+		return middleauth.AuthorizationError(c, err, "Session")
+	}
+	if err := checkPomodoroTask(currentUser.ID, pomodoro.TaskID); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
 	// Create new pomodoro
-	pomodoroID, err := models.CreatePomodoro(pomodoro.SessionID, pomodoro.Number, pomodoro.StartTime, pomodoro.Status)
+	pomodoroID, err := models.CreatePomodoro(pomodoro.SessionID, pomodoro.Number, pomodoro.StartTime, pomodoro.Status, pomodoro.TaskID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
@@ -42,6 +58,22 @@ func GetPomodorosHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, pomodoros)
 }
 
+// Get one pomodoro, with its version as the ETag for a later update
+func GetPomodoroHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid pomodoro ID"})
+	}
+
+	pomodoro, err := models.GetPomodoro(id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Pomodoro not found"})
+	}
+
+	setETag(c, pomodoro.Version)
+	return c.JSON(http.StatusOK, pomodoro)
+}
+
 func UpdatePomodoroHandler(c echo.Context) error {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -53,13 +85,184 @@ func UpdatePomodoroHandler(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
 	}
 
-	// Update the pomodoro
-	err = models.UpdatePomodoro(id, pomodoro.EndTime, pomodoro.Duration, pomodoro.Status)
+	existing, err := models.GetPomodoro(id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Pomodoro not found"})
+	}
+
+	ifVersion, ok := checkIfMatch(c, existing.Version)
+	if !ok {
+		return preconditionFailed(c, existing, existing.Version)
+	}
+
+	if msg := validatePomodoroRating(pomodoro); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+	rated := pomodoro.FocusRating != nil || pomodoro.EnergyLevel != nil || pomodoro.Reflection != nil
+
+	// A request with only a rating leaves the rest of the pomodoro alone
+	if rated && pomodoro.Status == "" {
+		err = models.RatePomodoro(id, pomodoro.FocusRating, pomodoro.EnergyLevel, pomodoro.Reflection, ifVersion)
+		if errors.Is(err, models.ErrVersionConflict) {
+			if current, err := models.GetPomodoro(id); err == nil {
+				return preconditionFailed(c, current, current.Version)
+			}
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+
+		if updated, err := models.GetPomodoro(id); err == nil {
+			setETag(c, updated.Version)
+		}
+		return c.JSON(http.StatusOK, map[string]string{"message": "Pomodoro updated successfully"})
+	}
+
+	session, err := models.GetSession(existing.SessionID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Session not found"})
+	}
+
+	// Flowtime pomodoros count up, so the duration is whatever was worked
+	if session.Mode == models.SessionModeFlowtime && pomodoro.Status != "running" {
+		if pomodoro.EndTime == "" {
+			pomodoro.EndTime = time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+		}
+		pomodoro.Duration, err = secondsBetween(existing.StartTime, pomodoro.EndTime)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid start or end time"})
+		}
+	}
+
+	// Update the pomodoro, together with its rating if one was sent
+	if rated {
+		err = models.UpdatePomodoroWithRating(id, pomodoro.EndTime, pomodoro.Duration, pomodoro.Status,
+			pomodoro.FocusRating, pomodoro.EnergyLevel, pomodoro.Reflection, ifVersion)
+	} else {
+		err = models.UpdatePomodoro(id, pomodoro.EndTime, pomodoro.Duration, pomodoro.Status, ifVersion)
+	}
+	if errors.Is(err, models.ErrVersionConflict) {
+		if current, err := models.GetPomodoro(id); err == nil {
+			return preconditionFailed(c, current, current.Version)
+		}
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{"message": "Pomodoro updated successfully"})
+	if updated, err := models.GetPomodoro(id); err == nil {
+		setETag(c, updated.Version)
+	}
+
+	if session.Mode != models.SessionModeFlowtime {
+		return c.JSON(http.StatusOK, map[string]string{"message": "Pomodoro updated successfully"})
+	}
+
+	// Propose the following break from the focus time
+	ratio := timer.DefaultSettings.FlowBreakRatio
+	if session.ProfileID != nil {
+		if profile, err := models.GetTimerProfile(*session.ProfileID); err == nil {
+			ratio = profile.FlowBreakRatio
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":        "Pomodoro updated successfully",
+		"duration":       pomodoro.Duration,
+		"proposed_break": timer.ProposeBreak(pomodoro.Duration, ratio),
+	})
+}
+
+// Helper function to check that a pomodoro referenced next to a session,
+// 0 meaning none, is part of that session
+func checkSessionPomodoro(sessionID int, pomodoroID int) string {
+	if pomodoroID == 0 {
+		return ""
+	}
+	pomodoro, err := models.GetPomodoro(pomodoroID)
+	if err != nil || pomodoro.SessionID != sessionID {
+		return "The pomodoro isn't part of this session"
+	}
+	return ""
+}
+
+// Helper function to check the focus rating and energy level of a pomodoro
+func validatePomodoroRating(pomodoro *models.Pomodoro) string {
+	if pomodoro.FocusRating != nil && (*pomodoro.FocusRating < 1 || *pomodoro.FocusRating > 5) {
+		return "focus_rating must be between 1 and 5"
+	}
+	if pomodoro.EnergyLevel != nil && (*pomodoro.EnergyLevel < 1 || *pomodoro.EnergyLevel > 5) {
+		return "energy_level must be between 1 and 5"
+	}
+	if pomodoro.Reflection != nil {
+		reflection := strings.TrimSpace(*pomodoro.Reflection)
+		pomodoro.Reflection = &reflection
+	}
+	return ""
+}
+
+// Relate focus ratings between from and to (YYYY-MM-DD, the last 90 days by
+// default) to the time of day, weekday, tag and pomodoro number. Hours and
+// weekdays are those of timezone, UTC by default.
+func GetFocusAnalyticsHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	loc := time.UTC
+	if timezone := c.QueryParam("timezone"); timezone != "" {
+		if loc, err = time.LoadLocation(timezone); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unknown timezone"})
+		}
+	}
+
+	today := time.Now().In(loc)
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, loc)
+	from, to := today.AddDate(0, 0, -89), today
+	if fromStr := c.QueryParam("from"); fromStr != "" {
+		if from, err = time.ParseInLocation("2006-01-02", fromStr, loc); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "from must be a date in YYYY-MM-DD format"})
+		}
+	}
+	if toStr := c.QueryParam("to"); toStr != "" {
+		if to, err = time.ParseInLocation("2006-01-02", toStr, loc); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "to must be a date in YYYY-MM-DD format"})
+		}
+	}
+	if to.Before(from) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "to must not be before from"})
+	}
+
+	analytics, err := models.GetFocusAnalytics(currentUser.ID,
+		from.UTC().Format("2006-01-02T15:04:05.000Z"), to.AddDate(0, 0, 1).Add(-time.Millisecond).UTC().Format("2006-01-02T15:04:05.000Z"), loc)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"from":      from.Format("2006-01-02"),
+		"to":        to.Format("2006-01-02"),
+		"timezone":  loc.String(),
+		"analytics": analytics,
+	})
+}
+
+// Helper function to get the whole seconds between two ISO8601 timestamps
+func secondsBetween(start string, end string) (int, error) {
+	startTime, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return 0, err
+	}
+	endTime, err := time.Parse(time.RFC3339, end)
+	if err != nil {
+		return 0, err
+	}
+
+	if endTime.Before(startTime) {
+		return 0, nil
+	}
+	return int(endTime.Sub(startTime).Seconds()), nil
 }
 
 // Break handlers
@@ -69,6 +272,13 @@ func CreateBreakHandler(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
 	}
 
+	if err := middleauth.AuthorizeSession(c, breakItem.SessionID); err != nil {
+		return middleauth.AuthorizationError(c, err, "Session")
+	}
+	if msg := checkSessionPomodoro(breakItem.SessionID, breakItem.PomodoroID); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+
 	// Create new break
 	breakID, err := models.CreateBreak(breakItem.SessionID, breakItem.PomodoroID, breakItem.Type, breakItem.StartTime, breakItem.Status)
 	if err != nil {
@@ -96,22 +306,57 @@ func GetBreaksHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, breaks)
 }
 
+// Get one break, with its version as the ETag for a later update
+func GetBreakHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid break ID"})
+	}
+
+	breakItem, err := models.GetBreak(id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Break not found"})
+	}
+
+	setETag(c, breakItem.Version)
+	return c.JSON(http.StatusOK, breakItem)
+}
+
 func UpdateBreakHandler(c echo.Context) error {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid break ID"})
 	}
 
+	existing, err := models.GetBreak(id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Break not found"})
+	}
+
+	ifVersion, ok := checkIfMatch(c, existing.Version)
+	if !ok {
+		return preconditionFailed(c, existing, existing.Version)
+	}
+
 	breakItem := new(models.Break)
 	if err := c.Bind(breakItem); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
 	}
 
 	// Update the break
-	err = models.UpdateBreak(id, breakItem.EndTime, breakItem.Duration, breakItem.Status)
+	err = models.UpdateBreak(id, breakItem.EndTime, breakItem.Duration, breakItem.Status, ifVersion)
+	if errors.Is(err, models.ErrVersionConflict) {
+		if current, err := models.GetBreak(id); err == nil {
+			return preconditionFailed(c, current, current.Version)
+		}
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
+	if updated, err := models.GetBreak(id); err == nil {
+		setETag(c, updated.Version)
+	}
+
 	return c.JSON(http.StatusOK, map[string]string{"message": "Break updated successfully"})
 }
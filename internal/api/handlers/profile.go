@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	middleauth "pom/internal/api/middleware"
+	models "pom/internal/db"
+	"pom/internal/timer"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+var errProfileNotFound = errors.New("timer profile not found")
+
+// Helper function to look up the profile a session runs under. An explicit
+// profile ID must belong to the user, otherwise the user's default profile
+// is used. Returns nil if the user has no default profile.
+func resolveTimerProfile(userID int, profileID *int) (*models.TimerProfile, error) {
+	var profile models.TimerProfile
+	var err error
+	if profileID != nil {
+		profile, err = models.GetTimerProfile(*profileID)
+		if err == nil && profile.UserID != userID {
+			err = sql.ErrNoRows
+		}
+	} else {
+		profile, err = models.GetDefaultTimerProfile(userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errProfileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// Helper function to fill in and check the lengths of a profile
+func validateTimerProfile(profile *models.TimerProfile) string {
+	if profile.Name == "" {
+		return "Profile name is required"
+	}
+
+	// Fall back to the classic cycle for anything left out
+	if profile.WorkLength == 0 {
+		profile.WorkLength = timer.DefaultSettings.WorkLength
+	}
+	if profile.ShortBreakLength == 0 {
+		profile.ShortBreakLength = timer.DefaultSettings.ShortBreakLength
+	}
+	if profile.LongBreakLength == 0 {
+		profile.LongBreakLength = timer.DefaultSettings.LongBreakLength
+	}
+	if profile.LongBreakEvery == 0 {
+		profile.LongBreakEvery = timer.DefaultSettings.LongBreakEvery
+	}
+	if profile.FlowBreakRatio == 0 {
+		profile.FlowBreakRatio = timer.DefaultSettings.FlowBreakRatio
+	}
+
+	if profile.WorkLength < 0 || profile.ShortBreakLength < 0 || profile.LongBreakLength < 0 || profile.LongBreakEvery < 0 {
+		return "Lengths must be positive"
+	}
+	if profile.FlowBreakRatio < 0 {
+		return "Flow break ratio must be positive"
+	}
+
+	return ""
+}
+
+// Helper function to load a profile owned by the current user
+func getOwnedTimerProfile(idParam string, userID int) (models.TimerProfile, error) {
+	id, err := strconv.Atoi(idParam)
+	if err != nil {
+		return models.TimerProfile{}, errProfileNotFound
+	}
+
+	profile, err := models.GetTimerProfile(id)
+	if err != nil || profile.UserID != userID {
+		return models.TimerProfile{}, errProfileNotFound
+	}
+
+	return profile, nil
+}
+
+// Timer profile handlers
+func GetTimerProfilesHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	profiles, err := models.GetTimerProfilesForUser(currentUser.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, profiles)
+}
+
+func GetTimerProfileHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	profile, err := getOwnedTimerProfile(c.Param("id"), currentUser.ID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Profile not found"})
+	}
+
+	return c.JSON(http.StatusOK, profile)
+}
+
+func CreateTimerProfileHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	profile := new(models.TimerProfile)
+	if err := c.Bind(profile); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	if msg := validateTimerProfile(profile); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+
+	// Becomes the default as well if requested
+	profileID, err := models.CreateTimerProfile(currentUser.ID, *profile)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message": "Profile created successfully",
+		"id":      profileID,
+	})
+}
+
+func UpdateTimerProfileHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	existing, err := getOwnedTimerProfile(c.Param("id"), currentUser.ID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Profile not found"})
+	}
+
+	profile := new(models.TimerProfile)
+	if err := c.Bind(profile); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	if msg := validateTimerProfile(profile); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+
+	if err := models.UpdateTimerProfile(existing.ID, *profile); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Profile updated successfully"})
+}
+
+func DeleteTimerProfileHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	existing, err := getOwnedTimerProfile(c.Param("id"), currentUser.ID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Profile not found"})
+	}
+
+	if err := models.DeleteTimerProfile(existing.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Profile deleted successfully"})
+}
+
+// Make a profile the current user's default
+func SetDefaultTimerProfileHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	existing, err := getOwnedTimerProfile(c.Param("id"), currentUser.ID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Profile not found"})
+	}
+
+	if err := models.SetDefaultTimerProfile(currentUser.ID, existing.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Default profile updated successfully"})
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	middleauth "pom/internal/api/middleware"
+	models "pom/internal/db"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	errProjectNotFound = errors.New("project not found")
+	errProjectArchived = errors.New("project is archived")
+)
+
+// Helper function to check that a session may be booked against a project
+func checkSessionProject(userID int, projectID *int, allowArchived bool) error {
+	if projectID == nil {
+		return nil
+	}
+
+	project, err := models.GetProject(*projectID)
+	if err != nil || project.UserID != userID {
+		return errProjectNotFound
+	}
+	if project.Archived && !allowArchived {
+		return errProjectArchived
+	}
+	return nil
+}
+
+// Helper function to load a project owned by the current user
+func getOwnedProject(idParam string, userID int) (models.Project, error) {
+	id, err := strconv.Atoi(idParam)
+	if err != nil {
+		return models.Project{}, errProjectNotFound
+	}
+
+	project, err := models.GetProject(id)
+	if err != nil || project.UserID != userID {
+		return models.Project{}, errProjectNotFound
+	}
+
+	return project, nil
+}
+
+// Project handlers
+func GetProjectsHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	includeArchived := c.QueryParam("archived") == "true"
+	projects, err := models.GetProjectsForUser(currentUser.ID, includeArchived)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, projects)
+}
+
+func GetProjectHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	project, err := getOwnedProject(c.Param("id"), currentUser.ID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found"})
+	}
+
+	return c.JSON(http.StatusOK, project)
+}
+
+func CreateProjectHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	project := new(models.Project)
+	if err := c.Bind(project); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	if project.Name == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Project name is required"})
+	}
+
+	projectID, err := models.CreateProject(currentUser.ID, *project)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message": "Project created successfully",
+		"id":      projectID,
+	})
+}
+
+func UpdateProjectHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	existing, err := getOwnedProject(c.Param("id"), currentUser.ID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found"})
+	}
+
+	// Fields left out of the request keep their current values
+	project := existing
+	if err := c.Bind(&project); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	if project.Name == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Project name is required"})
+	}
+	if project.Color == "" {
+		project.Color = existing.Color
+	}
+
+	if err := models.UpdateProject(existing.ID, project); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Project updated successfully"})
+}
+
+func DeleteProjectHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	existing, err := getOwnedProject(c.Param("id"), currentUser.ID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found"})
+	}
+
+	if err := models.DeleteProject(existing.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Project deleted successfully"})
+}
+
+// Get time booked per project for the current user
+func GetProjectStatsHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	rangeParam := c.QueryParam("range")
+	if rangeParam == "" {
+		rangeParam = "all"
+	}
+	startDate, endDate := getDateRangeFromParam(rangeParam)
+
+	stats, err := models.GetProjectStatsForUser(currentUser.ID, startDate, endDate)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, stats)
+}
@@ -1,7 +1,7 @@
 package handlers
 
 import (
-	"log"
+	"errors"
 	"net/http"
 	middleauth "pom/internal/api/middleware"
 	models "pom/internal/db"
@@ -18,23 +18,35 @@ func CreateSessionHandler(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
 	}
 
-	// Get current user ID
+	mode, ok := parseSessionMode(session.Mode)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid session mode"})
+	}
+
+	// Every session belongs to the user creating it
 	currentUser, err := middleauth.GetCurrentUser(c)
 	if err != nil {
-		// If there's an error getting the user, use the session without a user ID
-		log.Printf("Warning: Creating session without user ID: %v", err)
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
 	}
 
-	// Create a new session with tags
-	var sessionID int64
-	if err == nil {
-		// Create session with user ID
-		sessionID, err = models.CreateSessionWithUser(session.StartTime, session.Tags, currentUser.ID)
-	} else {
-		// Create session without user ID (fallback)
-		sessionID, err = models.CreateSession(session.StartTime, session.Tags)
+	// Record the requested profile, or the user's default one
+	profile, err := resolveTimerProfile(currentUser.ID, session.ProfileID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 
+	session.ProfileID = nil
+	if profile != nil {
+		session.ProfileID = &profile.ID
+	}
+	session.Mode = mode
+
+	if err := checkSessionProject(currentUser.ID, session.ProjectID, false); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	// Create a new session with tags
+	sessionID, err := models.CreateSessionWithUser(*session, currentUser.ID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
@@ -58,6 +70,16 @@ func GetSessionsHandler(c echo.Context) error {
 	rangeParam := c.QueryParam("range")
 	daysParam := c.QueryParam("days")
 
+	// Optional project filter combined with the other filters
+	var projectID *int
+	if projectParam := c.QueryParam("project_id"); projectParam != "" {
+		parsedID, err := strconv.Atoi(projectParam)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid project ID"})
+		}
+		projectID = &parsedID
+	}
+
 	// Default to 7 days if not specified
 	days := 7
 	if daysParam != "" {
@@ -69,7 +91,7 @@ func GetSessionsHandler(c echo.Context) error {
 
 	// If tag filter provided, get sessions by tag
 	if tag != "" {
-		sessions, err := models.GetSessionsByTagForUser(tag, currentUser.ID)
+		sessions, err := models.GetSessionsByTagForUser(tag, currentUser.ID, projectID)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 		}
@@ -80,7 +102,7 @@ func GetSessionsHandler(c echo.Context) error {
 	if rangeParam != "" {
 		// Get start and end dates based on range parameter
 		startDate, endDate := getDateRangeFromParam(rangeParam)
-		sessions, err := models.GetSessionsByDateRangeForUser(startDate, endDate, currentUser.ID)
+		sessions, err := models.GetSessionsByDateRangeForUser(startDate, endDate, currentUser.ID, projectID)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 		}
@@ -88,20 +110,17 @@ func GetSessionsHandler(c echo.Context) error {
 	}
 
 	// Otherwise get sessions for the specified number of days
-	sessions, err := models.GetSessionsByDaysForUser(days, currentUser.ID)
+	sessions, err := models.GetSessionsByDaysForUser(days, currentUser.ID, projectID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 	return c.JSON(http.StatusOK, sessions)
 }
 
+// Session, pomodoro, break and note handlers that take an ID in the path
+// run behind middleauth.RequireSessionOwner, which checks the session it
+// belongs to
 func GetSessionHandler(c echo.Context) error {
-	// Get current user
-	currentUser, err := middleauth.GetCurrentUser(c)
-	if err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
-	}
-
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid session ID"})
@@ -113,15 +132,7 @@ func GetSessionHandler(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "Session not found"})
 	}
 
-	// Check if admin or session owner
-	if !currentUser.IsAdmin {
-		// Check session ownership
-		isOwner, err := models.IsSessionOwner(id, currentUser.ID)
-		if err != nil || !isOwner {
-			return c.JSON(http.StatusForbidden, map[string]string{"error": "You don't have permission to access this session"})
-		}
-	}
-
+	setETag(c, session.Version)
 	return c.JSON(http.StatusOK, session)
 }
 
@@ -132,23 +143,50 @@ func UpdateSessionHandler(c echo.Context) error {
 	}
 
 	// Get existing session
-	_, err = models.GetSession(id)
+	existing, err := models.GetSession(id)
 	if err != nil {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "Session not found"})
 	}
 
-	// Update session
+	// Refuse to overwrite changes the client hasn't seen
+	ifVersion, ok := checkIfMatch(c, existing.Version)
+	if !ok {
+		return preconditionFailed(c, existing, existing.Version)
+	}
+
+	// Update session, keeping the project unless the request sets one
 	session := new(models.Session)
+	session.ProjectID = existing.ProjectID
 	if err := c.Bind(session); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
 	}
 
+	projectChanged := !sameProject(existing.ProjectID, session.ProjectID)
+	if projectChanged {
+		currentUser, err := middleauth.GetCurrentUser(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+		}
+		if err := checkSessionProject(currentUser.ID, session.ProjectID, true); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+
 	// Call the updateSession function with the id parameter and tags
-	err = models.UpdateSession(id, session.EndTime, session.TotalTime, session.Status, session.Completed, session.Tags)
+	err = models.UpdateSession(id, session.EndTime, session.TotalTime, session.Status, session.Completed, session.Tags, session.ProjectID, ifVersion)
+	if errors.Is(err, models.ErrVersionConflict) {
+		if current, err := models.GetSession(id); err == nil {
+			return preconditionFailed(c, current, current.Version)
+		}
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
+	if updated, err := models.GetSession(id); err == nil {
+		setETag(c, updated.Version)
+	}
+
 	return c.JSON(http.StatusOK, map[string]string{"message": "Session updated successfully"})
 }
 
@@ -169,9 +207,32 @@ func DeleteSessionHandler(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
+	// A timer still running the session can't be stopped anymore
+	timerEngine.DropSession(id)
+
 	return c.JSON(http.StatusOK, map[string]string{"message": "Session deleted successfully"})
 }
 
+// Helper function to compare two optional project IDs
+func sameProject(a *int, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// Helper function to validate a session mode, defaulting to classic
+func parseSessionMode(mode string) (string, bool) {
+	switch mode {
+	case "", models.SessionModeClassic:
+		return models.SessionModeClassic, true
+	case models.SessionModeFlowtime:
+		return models.SessionModeFlowtime, true
+	default:
+		return "", false
+	}
+}
+
 // Helper function to get start and end dates from range parameter
 func getDateRangeFromParam(rangeParam string) (string, string) {
 	now := time.Now()
@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	middleauth "pom/internal/api/middleware"
 	models "pom/internal/db"
 	"strconv"
 	"strings"
@@ -25,7 +27,12 @@ func ParseTagsFromQueryParam(tagsParam string) []string {
 
 // Tag handlers
 func GetTagsHandler(c echo.Context) error {
-	tags, err := models.GetTags()
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	tags, err := models.GetTagsForUser(currentUser.ID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
@@ -34,6 +41,11 @@ func GetTagsHandler(c echo.Context) error {
 
 // Get monthly stats for each tag
 func GetMonthlyTagStatsHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
 	yearStr := c.QueryParam("year")
 	if yearStr == "" {
 		// Default to current year
@@ -45,7 +57,10 @@ func GetMonthlyTagStatsHandler(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid year parameter"})
 	}
 
-	stats, err := models.GetMonthlyTagStats(year)
+	// Roll the minutes of child tags up into their parents if requested
+	rollup := c.QueryParam("rollup") == "true"
+
+	stats, err := models.GetMonthlyTagStats(year, currentUser.ID, rollup)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
@@ -54,13 +69,25 @@ func GetMonthlyTagStatsHandler(c echo.Context) error {
 }
 
 func CreateTagHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
 	tag := new(models.Tag)
 	if err := c.Bind(tag); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
 	}
 
-	// Create new tag
-	tagID, err := models.CreateTag(tag.Name, tag.Color)
+	if msg := validateTagName(tag); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+
+	// Create new tag in the user's namespace
+	tagID, err := models.CreateTag(tag.Name, tag.Color, &currentUser.ID)
+	if errors.Is(err, models.ErrTagExists) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "A tag with this name already exists"})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
@@ -72,10 +99,85 @@ func CreateTagHandler(c echo.Context) error {
 	})
 }
 
-func UpdateTagHandler(c echo.Context) error {
+// Create a global tag everybody can pick from (admin only)
+func CreateGlobalTagHandler(c echo.Context) error {
+	tag := new(models.Tag)
+	if err := c.Bind(tag); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	if msg := validateTagName(tag); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+
+	tagID, err := models.CreateTag(tag.Name, tag.Color, nil)
+	if errors.Is(err, models.ErrTagExists) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "A tag with this name already exists"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message": "Global tag created successfully",
+		"id":      tagID,
+	})
+}
+
+// Helper function to load a tag the current user may change. Users manage
+// their own tags, global tags can only be changed by admins.
+func getManageableTag(c echo.Context) (models.Tag, int, string) {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return models.Tag{}, http.StatusUnauthorized, "Authentication required"
+	}
+
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid tag ID"})
+		return models.Tag{}, http.StatusBadRequest, "Invalid tag ID"
+	}
+
+	tag, err := models.GetTag(id)
+	if err != nil {
+		return models.Tag{}, http.StatusNotFound, "Tag not found"
+	}
+
+	if status, msg := checkTagManageable(tag, currentUser); status != http.StatusOK {
+		return models.Tag{}, status, msg
+	}
+
+	return tag, http.StatusOK, ""
+}
+
+// Helper function to clean up and check the name of a tag. Sessions list
+// their tags comma-separated, so a name can't contain a comma.
+func validateTagName(tag *models.Tag) string {
+	tag.Name = strings.TrimSpace(tag.Name)
+	if tag.Name == "" {
+		return "Tag name is required"
+	}
+	if strings.Contains(tag.Name, ",") {
+		return "Tag name can't contain a comma"
+	}
+	return ""
+}
+
+// Helper function to check that a user may change a tag
+func checkTagManageable(tag models.Tag, user models.User) (int, string) {
+	if tag.UserID == nil {
+		if !user.IsAdmin {
+			return http.StatusForbidden, "Only admins can change global tags"
+		}
+	} else if *tag.UserID != user.ID {
+		return http.StatusNotFound, "Tag not found"
+	}
+	return http.StatusOK, ""
+}
+
+func UpdateTagHandler(c echo.Context) error {
+	existing, status, msg := getManageableTag(c)
+	if status != http.StatusOK {
+		return c.JSON(status, map[string]string{"error": msg})
 	}
 
 	tag := new(models.Tag)
@@ -83,8 +185,15 @@ func UpdateTagHandler(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
 	}
 
-	// Update the tag
-	err = models.UpdateTag(id, tag.Name, tag.Color)
+	if msg := validateTagName(tag); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+
+	// Update the tag, sessions follow the new name
+	err := models.UpdateTag(existing.ID, tag.Name, tag.Color)
+	if errors.Is(err, models.ErrTagExists) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
@@ -93,13 +202,13 @@ func UpdateTagHandler(c echo.Context) error {
 }
 
 func DeleteTagHandler(c echo.Context) error {
-	id, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid tag ID"})
+	existing, status, msg := getManageableTag(c)
+	if status != http.StatusOK {
+		return c.JSON(status, map[string]string{"error": msg})
 	}
 
 	// Delete the tag
-	err = models.DeleteTag(id)
+	err := models.DeleteTag(existing.ID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
@@ -107,14 +216,131 @@ func DeleteTagHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"message": "Tag deleted successfully"})
 }
 
-// Get sessions by tag
+// Merge tags request structure
+type MergeTagsRequest struct {
+	SourceIDs []int `json:"source_ids"` // Tags folded into the target
+}
+
+// Fold one or more tags into the tag given in the path
+func MergeTagsHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	targetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid tag ID"})
+	}
+
+	target, err := models.GetTag(targetID)
+	if err != nil || (target.UserID != nil && *target.UserID != currentUser.ID) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Tag not found"})
+	}
+
+	req := new(MergeTagsRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	if len(req.SourceIDs) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "At least one source tag is required"})
+	}
+
+	// A tag listed twice is only merged once
+	seen := make(map[int]bool)
+	sourceIDs := make([]int, 0, len(req.SourceIDs))
+	for _, sourceID := range req.SourceIDs {
+		if !seen[sourceID] {
+			seen[sourceID] = true
+			sourceIDs = append(sourceIDs, sourceID)
+		}
+	}
+
+	for _, sourceID := range sourceIDs {
+		if sourceID == target.ID {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "A tag can't be merged into itself"})
+		}
+
+		source, err := models.GetTag(sourceID)
+		if err != nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Tag not found"})
+		}
+		if status, msg := checkTagManageable(source, currentUser); status != http.StatusOK {
+			return c.JSON(status, map[string]string{"error": msg})
+		}
+
+		// Global tags are shared, so their sessions can't end up on a user's own tag
+		if source.UserID == nil && target.UserID != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Global tags can only be merged into global tags"})
+		}
+	}
+
+	retagged, err := models.MergeTags(target.ID, sourceIDs)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":          "Tags merged successfully",
+		"sessions_updated": retagged,
+	})
+}
+
+// Move tag request structure, a nil parent makes the tag top level
+type MoveTagRequest struct {
+	ParentID *int `json:"parent_id"`
+}
+
+// Move a tag under another tag
+func MoveTagHandler(c echo.Context) error {
+	existing, status, msg := getManageableTag(c)
+	if status != http.StatusOK {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+
+	req := new(MoveTagRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	if req.ParentID != nil {
+		parent, err := models.GetTag(*req.ParentID)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Parent tag not found"})
+		}
+
+		// A global tag can only sit under another global tag, a user's tag
+		// under one of their own tags or a global one
+		if parent.UserID != nil && (existing.UserID == nil || *parent.UserID != *existing.UserID) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Parent tag not found"})
+		}
+	}
+
+	err := models.SetTagParent(existing.ID, req.ParentID)
+	if errors.Is(err, models.ErrTagCycle) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Tag moved successfully"})
+}
+
+// Get sessions by tag, including the sessions of its descendant tags
 func GetSessionsByTagHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
 	tag := c.QueryParam("tag")
 	if tag == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Tag parameter is required"})
 	}
 
-	sessions, err := models.GetSessionsByTag(tag)
+	sessions, err := models.GetSessionsByTagForUser(tag, currentUser.ID, nil)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	middleauth "pom/internal/api/middleware"
+	models "pom/internal/db"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var errTaskNotFound = errors.New("task not found")
+
+// Helper function to load a task owned by the current user
+func getOwnedTask(idParam string, userID int) (models.Task, error) {
+	id, err := strconv.Atoi(idParam)
+	if err != nil {
+		return models.Task{}, errTaskNotFound
+	}
+
+	task, err := models.GetTask(id)
+	if err != nil || task.UserID != userID {
+		return models.Task{}, errTaskNotFound
+	}
+
+	return task, nil
+}
+
+// Helper function to check that pomodoros may be booked against a task
+func checkPomodoroTask(userID int, taskID *int) error {
+	if taskID == nil {
+		return nil
+	}
+	_, err := getOwnedTask(strconv.Itoa(*taskID), userID)
+	return err
+}
+
+// Helper function to check and normalise a task from a request
+func validateTask(task *models.Task, userID int) string {
+	task.Title = strings.TrimSpace(task.Title)
+	if task.Title == "" {
+		return "Task title is required"
+	}
+	if task.Estimate == 0 {
+		task.Estimate = 1
+	}
+	if task.Estimate < 0 {
+		return "Estimate must be a positive number of pomodoros"
+	}
+
+	if task.Status == "" {
+		task.Status = models.TaskStatusTodo
+	}
+	switch task.Status {
+	case models.TaskStatusTodo, models.TaskStatusInProgress, models.TaskStatusDone, models.TaskStatusCancelled:
+	default:
+		return "Status must be one of todo, in_progress, done or cancelled"
+	}
+
+	if task.DueDate != nil && *task.DueDate == "" {
+		task.DueDate = nil
+	}
+	if task.DueDate != nil {
+		if _, err := time.Parse("2006-01-02", *task.DueDate); err != nil {
+			return "due_date must be a date in YYYY-MM-DD format"
+		}
+	}
+
+	if err := checkSessionProject(userID, task.ProjectID, true); err != nil {
+		return err.Error()
+	}
+	task.Tags = strings.Join(ParseTagsFromQueryParam(task.Tags), ",")
+	return ""
+}
+
+// Task handlers
+func GetTasksHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	tasks, err := models.GetTasksForUser(currentUser.ID, c.QueryParam("status"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, tasks)
+}
+
+func GetTaskHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	task, err := getOwnedTask(c.Param("id"), currentUser.ID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Task not found"})
+	}
+
+	return c.JSON(http.StatusOK, task)
+}
+
+func CreateTaskHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	task := new(models.Task)
+	if err := c.Bind(task); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	if msg := validateTask(task, currentUser.ID); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+
+	taskID, err := models.CreateTask(currentUser.ID, *task)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message": "Task created successfully",
+		"id":      taskID,
+	})
+}
+
+func UpdateTaskHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	existing, err := getOwnedTask(c.Param("id"), currentUser.ID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Task not found"})
+	}
+
+	task := new(models.Task)
+	if err := c.Bind(task); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	if msg := validateTask(task, currentUser.ID); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+
+	if err := models.UpdateTask(existing.ID, *task); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Task updated successfully"})
+}
+
+func DeleteTaskHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	existing, err := getOwnedTask(c.Param("id"), currentUser.ID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Task not found"})
+	}
+
+	if err := models.DeleteTask(existing.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Task deleted successfully"})
+}
+
+// Compare estimates with the pomodoros actually spent on the tasks finished
+// between from and to (YYYY-MM-DD, the last 90 days by default), per task and
+// per week or month (period, month by default)
+func GetTaskReportHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from, to := today.AddDate(0, 0, -90), today
+	if fromStr := c.QueryParam("from"); fromStr != "" {
+		if from, err = time.Parse("2006-01-02", fromStr); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "from must be a date in YYYY-MM-DD format"})
+		}
+	}
+	if toStr := c.QueryParam("to"); toStr != "" {
+		if to, err = time.Parse("2006-01-02", toStr); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "to must be a date in YYYY-MM-DD format"})
+		}
+	}
+	if to.Before(from) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "to must not be before from"})
+	}
+
+	period := c.QueryParam("period")
+	if period == "" {
+		period = "month"
+	}
+	if period != "week" && period != "month" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "period must be week or month"})
+	}
+
+	tasks, err := models.GetCompletedTasksForUser(currentUser.ID,
+		from.Format("2006-01-02T15:04:05.000Z"), to.Add(24*time.Hour-time.Millisecond).Format("2006-01-02T15:04:05.000Z"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	type taskReport struct {
+		ID         int    `json:"id"`
+		Title      string `json:"title"`
+		Estimate   int    `json:"estimate"`
+		Actual     int    `json:"actual"`
+		Difference int    `json:"difference"` // Actual minus estimate
+		TimeSpent  int    `json:"time_spent"` // In seconds
+		Completed  string `json:"completed_at"`
+	}
+	perTask := make([]taskReport, 0, len(tasks))
+	estimated, actual := 0, 0
+	for _, task := range tasks {
+		perTask = append(perTask, taskReport{
+			ID: task.ID, Title: task.Title, Estimate: task.Estimate, Actual: task.ActualPomodoros,
+			Difference: task.ActualPomodoros - task.Estimate, TimeSpent: task.TimeSpent, Completed: *task.CompletedAt,
+		})
+		estimated += task.Estimate
+		actual += task.ActualPomodoros
+	}
+
+	ratio := 0.0
+	if estimated > 0 {
+		ratio = float64(actual) / float64(estimated)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"from":    from.Format("2006-01-02"),
+		"to":      to.Format("2006-01-02"),
+		"period":  period,
+		"tasks":   perTask,
+		"periods": models.SummarizeTaskEstimates(tasks, period),
+		"totals": map[string]interface{}{
+			"tasks":     len(tasks),
+			"estimated": estimated,
+			"actual":    actual,
+			"ratio":     ratio,
+		},
+	})
+}
+
+// Link a pomodoro to a task, or unlink it with a null task_id
+func SetPomodoroTaskHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid pomodoro ID"})
+	}
+
+	var req struct {
+		TaskID *int `json:"task_id"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	if err := checkPomodoroTask(currentUser.ID, req.TaskID); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := models.SetPomodoroTask(id, req.TaskID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Pomodoro updated successfully"})
+}
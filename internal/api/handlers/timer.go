@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	middleauth "pom/internal/api/middleware"
+	"pom/internal/timer"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Server side timer shared by every client of a user
+var timerEngine = timer.NewEngine()
+
+// Pick up the timers that were running before the server restarted
+func RestoreTimers() {
+	if err := timerEngine.Restore(); err != nil {
+		log.Printf("Error restoring timers: %v\n", err)
+	}
+}
+
+// Start timer request structure
+type TimerStartRequest struct {
+	Tags      string `json:"tags"`
+	ProfileID *int   `json:"profile_id"` // Defaults to the user's default profile
+	Mode      string `json:"mode"`       // "classic" (default) or "flowtime"
+	ProjectID *int   `json:"project_id"`
+	TaskID    *int   `json:"task_id"` // Task the pomodoros are spent on
+}
+
+// Get the current timer state
+func GetTimerHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	return c.JSON(http.StatusOK, timerEngine.Status(currentUser.ID))
+}
+
+// Start a new session with its first pomodoro
+func StartTimerHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	req := new(TimerStartRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	mode, ok := parseSessionMode(req.Mode)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid session mode"})
+	}
+
+	if err := checkSessionProject(currentUser.ID, req.ProjectID, false); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := checkPomodoroTask(currentUser.ID, req.TaskID); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	profile, err := resolveTimerProfile(currentUser.ID, req.ProfileID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	opts := timer.StartOptions{Tags: req.Tags, Mode: mode, ProjectID: req.ProjectID, TaskID: req.TaskID, Settings: timer.DefaultSettings}
+	if profile != nil {
+		opts.ProfileID = &profile.ID
+		opts.Settings = timer.SettingsFromProfile(*profile)
+	}
+
+	snapshot, err := timerEngine.Start(currentUser.ID, opts)
+	return timerResponse(c, snapshot, err)
+}
+
+func PauseTimerHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	snapshot, err := timerEngine.Pause(currentUser.ID)
+	return timerResponse(c, snapshot, err)
+}
+
+func ResumeTimerHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	snapshot, err := timerEngine.Resume(currentUser.ID)
+	return timerResponse(c, snapshot, err)
+}
+
+func SkipTimerHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	snapshot, err := timerEngine.Skip(currentUser.ID)
+	return timerResponse(c, snapshot, err)
+}
+
+// Switch the task the running timer books pomodoros against
+func SetTimerTaskHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	var req struct {
+		TaskID *int `json:"task_id"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	if err := checkPomodoroTask(currentUser.ID, req.TaskID); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	snapshot, err := timerEngine.SetTask(currentUser.ID, req.TaskID)
+	return timerResponse(c, snapshot, err)
+}
+
+func StopTimerHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	snapshot, err := timerEngine.Stop(currentUser.ID)
+	return timerResponse(c, snapshot, err)
+}
+
+// Helper function to map timer errors to status codes
+func timerResponse(c echo.Context, snapshot timer.Snapshot, err error) error {
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusOK, snapshot)
+	case errors.Is(err, timer.ErrNoTimer):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, timer.ErrAlreadyRunning), errors.Is(err, timer.ErrPaused), errors.Is(err, timer.ErrNotPaused):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	default:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"net/http"
+	middleauth "pom/internal/api/middleware"
+	models "pom/internal/db"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Columns of the timesheet, the same for every grouping so that
+// spreadsheets importing it keep working when the options change
+var timesheetHeader = []string{
+	"group_by", "group", "period_start", "period_end", "sessions", "pomodoros",
+	"work_minutes", "break_minutes", "total_minutes", "total_hours",
+}
+
+// One row of the timesheet
+type timesheetRow struct {
+	group       string
+	periodStart string
+	periodEnd   string
+	sessions    int
+	pomodoros   int
+	work        float64 // In seconds
+	breaks      float64 // In seconds
+}
+
+// Export a timesheet as CSV. Takes from/to (YYYY-MM-DD) or a range like the
+// sessions list, group (day, week, tag or project), round (minutes) with
+// round_mode (nearest, up or down) and include_breaks.
+func ExportTimesheetHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+
+	startDate, endDate := getDateRangeFromParam(c.QueryParam("range"))
+	fromStr, toStr := c.QueryParam("from"), c.QueryParam("to")
+	if fromStr != "" || toStr != "" {
+		from, err := time.Parse("2006-01-02", fromStr)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "from must be a date in YYYY-MM-DD format"})
+		}
+		to, err := time.Parse("2006-01-02", toStr)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "to must be a date in YYYY-MM-DD format"})
+		}
+		if to.Before(from) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "to must not be before from"})
+		}
+		startDate = from.Format("2006-01-02T15:04:05.000Z")
+		endDate = to.Add(24*time.Hour - time.Millisecond).Format("2006-01-02T15:04:05.000Z")
+	}
+
+	groupBy := c.QueryParam("group")
+	if groupBy == "" {
+		groupBy = "day"
+	}
+	if groupBy != "day" && groupBy != "week" && groupBy != "tag" && groupBy != "project" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "group must be one of day, week, tag or project"})
+	}
+
+	roundTo := 0
+	if roundStr := c.QueryParam("round"); roundStr != "" {
+		roundTo, err = strconv.Atoi(roundStr)
+		if err != nil || roundTo < 0 || roundTo > 24*60 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "round must be a number of minutes"})
+		}
+	}
+
+	roundMode := c.QueryParam("round_mode")
+	if roundMode == "" {
+		roundMode = "nearest"
+	}
+	if roundMode != "nearest" && roundMode != "up" && roundMode != "down" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "round_mode must be one of nearest, up or down"})
+	}
+
+	includeBreaks := c.QueryParam("include_breaks") == "true"
+
+	sessions, err := models.GetSessionsByDateRangeForUser(startDate, endDate, currentUser.ID, nil)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	totals, err := models.GetSessionTotalsForUser(startDate, endDate, currentUser.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	projectNames := make(map[int]string)
+	if groupBy == "project" {
+		projects, err := models.GetProjectsForUser(currentUser.ID, true)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		for _, project := range projects {
+			projectNames[project.ID] = project.Name
+		}
+	}
+
+	rows := make(map[string]*timesheetRow)
+	for _, session := range sessions {
+		start, err := time.Parse(time.RFC3339, session.StartTime)
+		if err != nil {
+			continue
+		}
+		start = start.UTC()
+
+		// Work time comes from the pomodoros, falling back to the session total
+		total := totals[session.ID]
+		work := total.PomodoroTime
+		if total.Pomodoros == 0 {
+			work = session.TotalTime
+		}
+
+		breakTime := 0
+		if includeBreaks {
+			breakTime = total.BreakTime
+		}
+
+		// Sessions with several tags split their time evenly between them
+		var groups []string
+		periodStart, periodEnd := "", ""
+		switch groupBy {
+		case "day":
+			groups = []string{start.Format("2006-01-02")}
+			periodStart, periodEnd = groups[0], groups[0]
+		case "week":
+			year, week := start.ISOWeek()
+			monday := time.Date(start.Year(), start.Month(), start.Day()-(int(start.Weekday())+6)%7, 0, 0, 0, 0, time.UTC)
+			groups = []string{fmt.Sprintf("%04d-W%02d", year, week)}
+			periodStart, periodEnd = monday.Format("2006-01-02"), monday.AddDate(0, 0, 6).Format("2006-01-02")
+		case "tag":
+			groups = ParseTagsFromQueryParam(session.Tags)
+			if len(groups) == 0 {
+				groups = []string{"(untagged)"}
+			}
+		case "project":
+			groups = []string{"(no project)"}
+			if session.ProjectID != nil {
+				if name, ok := projectNames[*session.ProjectID]; ok {
+					groups = []string{name}
+				}
+			}
+		}
+
+		share := 1 / float64(len(groups))
+		for _, group := range groups {
+			row, ok := rows[group]
+			if !ok {
+				row = &timesheetRow{group: group, periodStart: periodStart, periodEnd: periodEnd}
+				rows[group] = row
+			}
+			row.sessions++
+			row.pomodoros += total.Pomodoros
+			row.work += float64(work) * share
+			row.breaks += float64(breakTime) * share
+		}
+	}
+
+	// Tag and project rows cover the whole range
+	if groupBy == "tag" || groupBy == "project" {
+		for _, row := range rows {
+			row.periodStart, row.periodEnd = startDate[:10], endDate[:10]
+		}
+	}
+
+	ordered := make([]*timesheetRow, 0, len(rows))
+	for _, row := range rows {
+		ordered = append(ordered, row)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].group < ordered[j].group
+	})
+
+	filename := fmt.Sprintf("timesheet-%s-%s.csv", startDate[:10], endDate[:10])
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Response().WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(c.Response())
+	writer.Write(timesheetHeader)
+	for _, row := range ordered {
+		work := roundMinutes(row.work/60, roundTo, roundMode)
+		breaks := roundMinutes(row.breaks/60, roundTo, roundMode)
+		writer.Write([]string{
+			groupBy, row.group, row.periodStart, row.periodEnd,
+			strconv.Itoa(row.sessions), strconv.Itoa(row.pomodoros),
+			formatMinutes(work), formatMinutes(breaks), formatMinutes(work + breaks),
+			strconv.FormatFloat((work+breaks)/60, 'f', 2, 64),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Helper function to round minutes to a multiple of roundTo, 0 leaves them as is
+func roundMinutes(minutes float64, roundTo int, mode string) float64 {
+	if roundTo <= 0 || minutes <= 0 {
+		return minutes
+	}
+	steps := minutes / float64(roundTo)
+	switch mode {
+	case "up":
+		// Ignore floating point noise so exact multiples stay put
+		steps = math.Ceil(steps - 1e-9)
+	case "down":
+		steps = math.Floor(steps + 1e-9)
+	default:
+		steps = math.Round(steps)
+	}
+	return steps * float64(roundTo)
+}
+
+func formatMinutes(minutes float64) string {
+	return strconv.FormatFloat(minutes, 'f', 2, 64)
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"net/http"
+	middleauth "pom/internal/api/middleware"
+	models "pom/internal/db"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Create access token request structure
+type AccessTokenRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`     // full, read or timer, full by default
+	ExpiresAt *string  `json:"expires_at"` // RFC 3339, never by default
+}
+
+// Helper function to keep access tokens from managing access tokens, so a
+// leaked token can't mint new ones
+func rejectAccessToken(c echo.Context) error {
+	return c.JSON(http.StatusForbidden, map[string]string{"error": "Access tokens can only be managed after logging in"})
+}
+
+// Access token handlers
+func GetAccessTokensHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+	if middleauth.UsesAccessToken(c) {
+		return rejectAccessToken(c)
+	}
+
+	tokens, err := models.GetAccessTokensForUser(currentUser.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// Create an access token. The token itself is only part of this response.
+func CreateAccessTokenHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+	if middleauth.UsesAccessToken(c) {
+		return rejectAccessToken(c)
+	}
+
+	req := new(AccessTokenRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Token name is required"})
+	}
+
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{models.ScopeFull}
+	}
+	for _, scope := range req.Scopes {
+		if !middleauth.ValidScope(scope) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Scopes must be full, read or timer"})
+		}
+	}
+
+	if req.ExpiresAt != nil && *req.ExpiresAt == "" {
+		req.ExpiresAt = nil
+	}
+	if req.ExpiresAt != nil {
+		expiresAt, err := time.Parse(time.RFC3339, *req.ExpiresAt)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "expires_at must be an RFC 3339 date and time"})
+		}
+		if !expiresAt.After(time.Now()) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "expires_at must be in the future"})
+		}
+		normalized := expiresAt.UTC().Format("2006-01-02T15:04:05.000Z")
+		req.ExpiresAt = &normalized
+	}
+
+	token, secret, err := models.CreateAccessToken(currentUser.ID, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message":      "Access token created successfully",
+		"id":           token.ID,
+		"token":        secret,
+		"access_token": token,
+	})
+}
+
+// Revoke an access token
+func DeleteAccessTokenHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+	if middleauth.UsesAccessToken(c) {
+		return rejectAccessToken(c)
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Access token not found"})
+	}
+	token, err := models.GetAccessToken(id)
+	if err != nil || token.UserID != currentUser.ID {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Access token not found"})
+	}
+
+	if err := models.DeleteAccessToken(token.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Access token revoked successfully"})
+}
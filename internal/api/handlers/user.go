@@ -4,6 +4,7 @@ import (
 	"net/http"
 	middleauth "pom/internal/api/middleware"
 	models "pom/internal/db"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
 	"golang.org/x/crypto/bcrypt"
@@ -77,5 +78,98 @@ func UpdateUserProfileHandler(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update profile: " + err.Error()})
 	}
 
+	// A new password logs out every other device and revokes access tokens
+	if userInput.Password != "" {
+		if _, err := models.DeleteLoginSessionsForUser(currentUser.ID, middleauth.CurrentLoginSessionID(c)); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		if err := models.DeleteAccessTokensForUser(currentUser.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+	}
+
 	return c.JSON(http.StatusOK, map[string]string{"message": "Profile updated successfully"})
 }
+
+// Login session with a flag for the one making the request
+type loginSessionResponse struct {
+	models.LoginSession
+	Current bool `json:"current"`
+}
+
+// Helper function to keep access tokens away from login sessions, so a
+// leaked token can't log devices out
+func rejectAccessTokenForLogins(c echo.Context) error {
+	return c.JSON(http.StatusForbidden, map[string]string{"error": "Login sessions can only be managed after logging in"})
+}
+
+// List the devices the current user is logged in on
+func GetLoginSessionsHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+	if middleauth.UsesAccessToken(c) {
+		return rejectAccessTokenForLogins(c)
+	}
+
+	logins, err := models.GetLoginSessionsForUser(currentUser.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	currentID := middleauth.CurrentLoginSessionID(c)
+	response := make([]loginSessionResponse, 0, len(logins))
+	for _, login := range logins {
+		response = append(response, loginSessionResponse{LoginSession: login, Current: login.ID == currentID})
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// Log one device out
+func RevokeLoginSessionHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+	if middleauth.UsesAccessToken(c) {
+		return rejectAccessTokenForLogins(c)
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Login session not found"})
+	}
+	login, err := models.GetLoginSession(id)
+	if err != nil || login.UserID != currentUser.ID {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Login session not found"})
+	}
+
+	if err := models.DeleteLoginSession(login.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Login session revoked successfully"})
+}
+
+// Log every device out except the one making the request
+func RevokeOtherLoginSessionsHandler(c echo.Context) error {
+	currentUser, err := middleauth.GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+	if middleauth.UsesAccessToken(c) {
+		return rejectAccessTokenForLogins(c)
+	}
+
+	revoked, err := models.DeleteLoginSessionsForUser(currentUser.ID, middleauth.CurrentLoginSessionID(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Login sessions revoked successfully",
+		"revoked": revoked,
+	})
+}
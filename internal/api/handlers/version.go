@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Helper function to send the version of a record as its ETag
+func setETag(c echo.Context, version int) {
+	c.Response().Header().Set("ETag", fmt.Sprintf(`"%d"`, version))
+}
+
+// Helper function to check the If-Match header against the current version
+// of a record. Returns the version the update must be made against, 0 when
+// the client didn't ask for a check, and false if the precondition fails.
+func checkIfMatch(c echo.Context, version int) (int, bool) {
+	header := c.Request().Header.Get("If-Match")
+	if header == "" {
+		return 0, true
+	}
+
+	current := fmt.Sprintf(`"%d"`, version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			return version, true
+		}
+	}
+	return 0, false
+}
+
+// Helper function to reject a stale update with the current server copy, so
+// the client can offer to merge instead of overwriting
+func preconditionFailed(c echo.Context, current interface{}, version int) error {
+	setETag(c, version)
+	return c.JSON(http.StatusPreconditionFailed, map[string]interface{}{
+		"error":   "The record was changed since you loaded it",
+		"current": current,
+	})
+}
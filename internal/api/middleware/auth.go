@@ -21,8 +21,9 @@ var adminPassword = getEnvWithDefault("ADMIN_PASSWORD", "admin123")
 
 // JWT claims struct
 type JwtCustomClaims struct {
-	Name  string `json:"name"`
-	Admin bool   `json:"admin"`
+	Name      string `json:"name"`
+	Admin     bool   `json:"admin"`
+	SessionID int    `json:"sid,omitempty"` // Login session the token was issued for
 	jwt.StandardClaims
 }
 
@@ -30,6 +31,7 @@ type JwtCustomClaims struct {
 type LoginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Device   string `json:"device"` // Optional name for the device list
 }
 
 // Get environment variable with default fallback
@@ -57,36 +59,27 @@ func LoginHandler(c echo.Context) error {
 	// Update last login time
 	models.UpdateLastLogin(user.ID)
 
-	// Set custom claims
-	claims := &JwtCustomClaims{
-		user.Username,
-		user.IsAdmin,
-		jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Hour * 72).Unix(), // Token valid for 3 days
-		},
+	// Record the device and start its login session
+	userAgent := c.Request().UserAgent()
+	device := strings.TrimSpace(loginReq.Device)
+	if device == "" {
+		device = describeUserAgent(userAgent)
+	}
+	login, refreshToken, err := models.CreateLoginSession(user.ID, device, c.RealIP(), userAgent, refreshTokenLifetime)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Could not start session"})
 	}
 
-	// Create token with claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	// Generate encoded token
-	tokenString, err := token.SignedString(jwtSecret)
+	tokenString, err := issueTokens(c, user, login.ID, refreshToken)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Could not generate token"})
 	}
 
-	// Set token as an HTTP-only cookie
-	cookie := new(http.Cookie)
-	cookie.Name = "auth_token"
-	cookie.Value = tokenString
-	cookie.Expires = time.Now().Add(72 * time.Hour)
-	cookie.Path = "/"
-	cookie.HttpOnly = true
-	c.SetCookie(cookie)
-
-	// Return the token and user info
+	// Return the tokens and user info
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"token": tokenString,
+		"token":         tokenString,
+		"refresh_token": refreshToken,
+		"expires_in":    int(accessTokenLifetime.Seconds()),
 		"user": map[string]interface{}{
 			"id":       user.ID,
 			"username": user.Username,
@@ -95,14 +88,21 @@ func LoginHandler(c echo.Context) error {
 	})
 }
 func LogoutHandler(c echo.Context) error {
-	// Clear the auth cookie
-	cookie := new(http.Cookie)
-	cookie.Name = "auth_token"
-	cookie.Value = ""
-	cookie.Expires = time.Now().Add(-1 * time.Hour) // Expired
-	cookie.Path = "/"
-	cookie.HttpOnly = true
-	c.SetCookie(cookie)
+	// End the login session, so its tokens stop working everywhere
+	if login, ok := requestLoginSession(c); ok {
+		models.DeleteLoginSession(login.ID)
+	}
+
+	// Clear the auth cookies
+	for _, name := range []string{"auth_token", "refresh_token"} {
+		cookie := new(http.Cookie)
+		cookie.Name = name
+		cookie.Value = ""
+		cookie.Expires = time.Now().Add(-1 * time.Hour) // Expired
+		cookie.Path = "/"
+		cookie.HttpOnly = true
+		c.SetCookie(cookie)
+	}
 
 	return c.JSON(http.StatusOK, map[string]string{"message": "Logged out successfully"})
 }
@@ -158,28 +158,18 @@ func AuthStatusHandler(c echo.Context) error {
 func ConfigureJWTMiddleware() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			// Get the auth cookie
-			authCookie, err := c.Cookie("auth_token")
-			if err != nil || authCookie == nil || authCookie.Value == "" {
-				// Check if this is an API request or a page request
-				if strings.HasPrefix(c.Request().URL.Path, "/api/") {
-					// For API requests, return 401 Unauthorized
-					return echo.NewHTTPError(http.StatusUnauthorized, "Please login to continue")
-				} else {
-					// For page requests, redirect to login page
-					return c.Redirect(http.StatusFound, "/login")
-				}
+			// Authenticate with the Authorization header or the auth cookie
+			err := authenticate(c)
+			if errors.Is(err, errOutOfScope) {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "The access token's scopes don't allow this request"})
 			}
-
-			// Parse and validate the token
-			token, err := jwt.ParseWithClaims(authCookie.Value, &JwtCustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-				return jwtSecret, nil
-			})
-
-			if err != nil || !token.Valid {
+			if err != nil {
 				// Check if this is an API request or a page request
 				if strings.HasPrefix(c.Request().URL.Path, "/api/") {
 					// For API requests, return 401 Unauthorized
+					if errors.Is(err, errNoCredentials) {
+						return echo.NewHTTPError(http.StatusUnauthorized, "Please login to continue")
+					}
 					return echo.NewHTTPError(http.StatusUnauthorized, "Invalid or expired token")
 				} else {
 					// For page requests, redirect to login page
@@ -187,8 +177,6 @@ func ConfigureJWTMiddleware() echo.MiddlewareFunc {
 				}
 			}
 
-			// Token is valid, set it in context
-			c.Set("user", token)
 			return next(c)
 		}
 	}
@@ -197,16 +185,8 @@ func ConfigureJWTMiddleware() echo.MiddlewareFunc {
 // Optional auth middleware - doesn't require auth but sets user if available
 func OptionalAuth(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		authCookie, err := c.Cookie("auth_token")
-		if err == nil && authCookie != nil && authCookie.Value != "" {
-			token, err := jwt.ParseWithClaims(authCookie.Value, &JwtCustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-				return jwtSecret, nil
-			})
-
-			if err == nil && token.Valid {
-				c.Set("user", token)
-			}
-		}
+		// A failed authentication just leaves the user out
+		authenticate(c)
 		return next(c)
 	}
 }
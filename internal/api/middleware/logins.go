@@ -0,0 +1,203 @@
+package middleauth
+
+import (
+	"errors"
+	"net/http"
+	models "pom/internal/db"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// Access tokens are short-lived, revoking a login session stops them
+	// at once anyway but this bounds how long a copied one is useful
+	accessTokenLifetime = 15 * time.Minute
+
+	// How long a device stays logged in without being used
+	refreshTokenLifetime = 30 * 24 * time.Hour
+
+	// How long a refresh token that was just rotated is still accepted, for
+	// parallel requests of the same device
+	refreshGracePeriod = 30 * time.Second
+)
+
+// Helper function to check the signature of a JWT
+func jwtKey(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, errors.New("unexpected signing method")
+	}
+	return jwtSecret, nil
+}
+
+// Helper function to tell a JWT that only expired from an invalid one
+func onlyExpired(err error) bool {
+	var validationErr *jwt.ValidationError
+	return errors.As(err, &validationErr) && validationErr.Errors == jwt.ValidationErrorExpired
+}
+
+// Helper function to sign an access token for a login session, and hand it
+// and the refresh token, if any, to the browser as cookies
+func issueTokens(c echo.Context, user models.User, loginID int, refreshToken string) (string, error) {
+	claims := &JwtCustomClaims{
+		Name:      user.Username,
+		Admin:     user.IsAdmin,
+		SessionID: loginID,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(accessTokenLifetime).Unix(),
+		},
+	}
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+	if err != nil {
+		return "", err
+	}
+
+	// The cookies outlive the access token so that an expired one can be
+	// refreshed without the browser logging in again
+	cookies := map[string]string{"auth_token": tokenString}
+	if refreshToken != "" {
+		cookies["refresh_token"] = refreshToken
+	}
+	for name, value := range cookies {
+		cookie := new(http.Cookie)
+		cookie.Name = name
+		cookie.Value = value
+		cookie.Expires = time.Now().Add(refreshTokenLifetime)
+		cookie.Path = "/"
+		cookie.HttpOnly = true
+		c.SetCookie(cookie)
+	}
+
+	c.Set("user", &jwt.Token{Claims: claims, Valid: true})
+	return tokenString, nil
+}
+
+// Helper function to swap a refresh token for new tokens
+func refreshTokens(c echo.Context, refreshToken string) (string, string, error) {
+	login, newRefreshToken, err := models.RotateRefreshToken(refreshToken, refreshTokenLifetime, refreshGracePeriod)
+	if err != nil {
+		return "", "", err
+	}
+
+	user, err := models.GetUserByID(login.UserID)
+	if err != nil {
+		return "", "", err
+	}
+	if user.AccountStatus != "active" {
+		models.DeleteLoginSession(login.ID)
+		return "", "", errors.New("account is not active")
+	}
+
+	tokenString, err := issueTokens(c, user, login.ID, newRefreshToken)
+	return tokenString, newRefreshToken, err
+}
+
+// Helper function to find the login session a request belongs to, from the
+// refresh token cookie or the access token, expired or not
+func requestLoginSession(c echo.Context) (models.LoginSession, bool) {
+	if cookie, err := c.Cookie("refresh_token"); err == nil && cookie.Value != "" {
+		if login, err := models.GetLoginSessionByRefreshToken(cookie.Value); err == nil {
+			return login, true
+		}
+	}
+
+	credential, _, err := requestCredential(c)
+	if err != nil {
+		return models.LoginSession{}, false
+	}
+	claims := &JwtCustomClaims{}
+	if _, err := jwt.ParseWithClaims(credential, claims, jwtKey); err != nil && !onlyExpired(err) {
+		return models.LoginSession{}, false
+	}
+
+	login, err := models.GetLoginSession(claims.SessionID)
+	return login, err == nil
+}
+
+// Get the login session the current request authenticated with, 0 for
+// requests made with a personal access token
+func CurrentLoginSessionID(c echo.Context) int {
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return 0
+	}
+	claims, ok := token.Claims.(*JwtCustomClaims)
+	if !ok {
+		return 0
+	}
+	return claims.SessionID
+}
+
+// Swap a refresh token, from the request body or the refresh cookie, for a
+// new access token and refresh token. The old refresh token stops working.
+func RefreshHandler(c echo.Context) error {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if req.RefreshToken == "" {
+		if cookie, err := c.Cookie("refresh_token"); err == nil {
+			req.RefreshToken = cookie.Value
+		}
+	}
+	if req.RefreshToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "A refresh token is required"})
+	}
+
+	tokenString, refreshToken, err := refreshTokens(c, req.RefreshToken)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid or expired refresh token"})
+	}
+
+	response := map[string]interface{}{
+		"token":      tokenString,
+		"expires_in": int(accessTokenLifetime.Seconds()),
+	}
+	// A token refreshed a moment ago by a parallel request keeps working
+	if refreshToken != "" {
+		response["refresh_token"] = refreshToken
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// Helper function to name a device after its user agent, e.g. Firefox on Linux
+func describeUserAgent(userAgent string) string {
+	browsers := []struct{ marker, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"},
+		{"Safari/", "Safari"}, {"curl/", "curl"},
+	}
+	systems := []struct{ marker, name string }{
+		{"Android", "Android"}, {"iPhone", "iOS"}, {"iPad", "iPadOS"}, {"Windows", "Windows"},
+		{"Mac OS X", "macOS"}, {"Linux", "Linux"},
+	}
+
+	browser := ""
+	for _, b := range browsers {
+		if strings.Contains(userAgent, b.marker) {
+			browser = b.name
+			break
+		}
+	}
+	system := ""
+	for _, s := range systems {
+		if strings.Contains(userAgent, s.marker) {
+			system = s.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	default:
+		return "Unknown device"
+	}
+}
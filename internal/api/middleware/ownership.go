@@ -0,0 +1,182 @@
+package middleauth
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	models "pom/internal/db"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	ErrResourceNotFound = errors.New("resource not found")
+	ErrForbidden        = errors.New("you don't have permission to access this session")
+)
+
+// Finds the session that owns the resource a request is about
+type SessionResolver func(c echo.Context) (int, error)
+
+// Context key of the session ID checked by RequireSessionOwner
+const ownedSessionKey = "owned_session_id"
+
+// Check that the current user may access a session. Sessions belong to
+// exactly one user, only admins may access those of others or sessions
+// without an owner.
+func AuthorizeSession(c echo.Context, sessionID int) error {
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	ownerID, err := models.GetSessionOwner(sessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrResourceNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return authorizeOwner(user, ownerID)
+}
+
+// Helper function to check the current user against the owner of a session
+func authorizeOwner(user models.User, ownerID *int) error {
+	if user.IsAdmin || (ownerID != nil && *ownerID == user.ID) {
+		return nil
+	}
+	return ErrForbidden
+}
+
+// Helper function to turn an authorization failure into a response
+func AuthorizationError(c echo.Context, err error, resource string) error {
+	switch {
+	case errors.Is(err, ErrResourceNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": resource + " not found"})
+	case errors.Is(err, ErrForbidden):
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "You don't have permission to access this session"})
+	default:
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+	}
+}
+
+// Only let the owner of the session behind a resource, or an admin, through.
+// resource names the resource in the not found error.
+func RequireSessionOwner(resource string, resolve SessionResolver) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			sessionID, err := resolve(c)
+			if err == nil {
+				err = AuthorizeSession(c, sessionID)
+			}
+			if err != nil {
+				return AuthorizationError(c, err, resource)
+			}
+
+			c.Set(ownedSessionKey, sessionID)
+			return next(c)
+		}
+	}
+}
+
+// Get the session ID checked by RequireSessionOwner
+func OwnedSessionID(c echo.Context) int {
+	sessionID, _ := c.Get(ownedSessionKey).(int)
+	return sessionID
+}
+
+// Helper function to read a numeric path parameter
+func idParam(c echo.Context, name string) (int, error) {
+	id, err := strconv.Atoi(c.Param(name))
+	if err != nil {
+		return 0, ErrResourceNotFound
+	}
+	return id, nil
+}
+
+// Resolve a session named directly by a path parameter
+func SessionParam(name string) SessionResolver {
+	return func(c echo.Context) (int, error) {
+		return idParam(c, name)
+	}
+}
+
+// Resolve the session of the pomodoro named by a path parameter
+func PomodoroParam(name string) SessionResolver {
+	return func(c echo.Context) (int, error) {
+		id, err := idParam(c, name)
+		if err != nil {
+			return 0, err
+		}
+		pomodoro, err := models.GetPomodoro(id)
+		if err != nil {
+			return 0, ErrResourceNotFound
+		}
+		return pomodoro.SessionID, nil
+	}
+}
+
+// Resolve the session of the break named by a path parameter
+func BreakParam(name string) SessionResolver {
+	return func(c echo.Context) (int, error) {
+		id, err := idParam(c, name)
+		if err != nil {
+			return 0, err
+		}
+		breakItem, err := models.GetBreak(id)
+		if err != nil {
+			return 0, ErrResourceNotFound
+		}
+		return breakItem.SessionID, nil
+	}
+}
+
+// Resolve the session of the note named by a path parameter
+func NoteParam(name string) SessionResolver {
+	return func(c echo.Context) (int, error) {
+		id, err := idParam(c, name)
+		if err != nil {
+			return 0, err
+		}
+		note, err := models.GetNote(id)
+		if err != nil {
+			return 0, ErrResourceNotFound
+		}
+		return note.SessionID, nil
+	}
+}
+
+// Only let the owner of the history of the note named by a path parameter,
+// or an admin, through. Works for deleted notes, once the note's session is
+// deleted too the owner recorded with its revisions decides.
+func RequireNoteHistoryOwner(name string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user, err := GetCurrentUser(c)
+			if err != nil {
+				return AuthorizationError(c, err, "Note")
+			}
+
+			noteID, err := idParam(c, name)
+			if err != nil {
+				return AuthorizationError(c, err, "Note")
+			}
+			sessionID, ownerID, err := models.GetNoteRevisionOwner(noteID)
+			if err != nil {
+				return AuthorizationError(c, ErrResourceNotFound, "Note")
+			}
+
+			err = AuthorizeSession(c, sessionID)
+			if errors.Is(err, ErrResourceNotFound) {
+				err = authorizeOwner(user, ownerID)
+			}
+			if err != nil {
+				return AuthorizationError(c, err, "Note")
+			}
+
+			c.Set(ownedSessionKey, sessionID)
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package middleauth
+
+import (
+	"errors"
+	"net/http"
+	models "pom/internal/db"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	errNoCredentials      = errors.New("no credentials")
+	errInvalidCredentials = errors.New("invalid or expired token")
+	errOutOfScope         = errors.New("the access token doesn't allow this request")
+)
+
+// Context key of the personal access token a request authenticated with
+const accessTokenKey = "access_token"
+
+// Helper function to get the credential of a request from an Authorization:
+// Bearer header or else the auth cookie, telling which it came from
+func requestCredential(c echo.Context) (string, bool, error) {
+	if header := c.Request().Header.Get("Authorization"); header != "" {
+		scheme, value, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(value) == "" {
+			return "", false, errInvalidCredentials
+		}
+		return strings.TrimSpace(value), false, nil
+	}
+	if cookie, err := c.Cookie("auth_token"); err == nil && cookie.Value != "" {
+		return cookie.Value, true, nil
+	}
+	return "", false, errNoCredentials
+}
+
+// Authenticate a request with an Authorization: Bearer header carrying a JWT
+// or a personal access token, or else with the auth cookie. Either way the
+// user ends up in the context as JWT claims, as after a login. An expired
+// cookie is refreshed with the refresh cookie.
+func authenticate(c echo.Context) error {
+	credential, fromCookie, err := requestCredential(c)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(credential, models.AccessTokenPrefix) {
+		user, accessToken, err := models.GetUserByAccessToken(credential)
+		if err != nil {
+			return errInvalidCredentials
+		}
+		if !scopesAllow(accessToken.Scopes, c.Request().Method, c.Request().URL.Path) {
+			return errOutOfScope
+		}
+
+		c.Set(accessTokenKey, accessToken)
+		c.Set("user", &jwt.Token{Claims: &JwtCustomClaims{Name: user.Username, Admin: user.IsAdmin}, Valid: true})
+		return nil
+	}
+
+	claims := &JwtCustomClaims{}
+	token, err := jwt.ParseWithClaims(credential, claims, jwtKey)
+	if fromCookie && onlyExpired(err) {
+		if cookie, cookieErr := c.Cookie("refresh_token"); cookieErr == nil && cookie.Value != "" {
+			if _, _, refreshErr := refreshTokens(c, cookie.Value); refreshErr == nil {
+				return nil
+			}
+		}
+	}
+	if err != nil || !token.Valid {
+		return errInvalidCredentials
+	}
+
+	// The token is only good while its login session hasn't been revoked
+	if _, err := models.GetLoginSession(claims.SessionID); err != nil {
+		return errInvalidCredentials
+	}
+	models.TouchLoginSession(claims.SessionID, c.RealIP())
+
+	c.Set("user", token)
+	return nil
+}
+
+// Check whether any of an access token's scopes allows a request
+func scopesAllow(scopes []string, method string, path string) bool {
+	for _, scope := range scopes {
+		switch scope {
+		case models.ScopeFull:
+			return true
+		case models.ScopeRead:
+			if method == http.MethodGet || method == http.MethodHead {
+				return true
+			}
+		case models.ScopeTimer:
+			if path == "/api/timer" || strings.HasPrefix(path, "/api/timer/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Check whether a scope name is known
+func ValidScope(scope string) bool {
+	switch scope {
+	case models.ScopeFull, models.ScopeRead, models.ScopeTimer:
+		return true
+	}
+	return false
+}
+
+// Check whether a request authenticated with a personal access token rather
+// than a login
+func UsesAccessToken(c echo.Context) bool {
+	_, ok := c.Get(accessTokenKey).(models.AccessToken)
+	return ok
+}
@@ -16,6 +16,8 @@ func SetupRoutes(e *echo.Echo) {
 	e.GET("/api/auth/status", middleauth.AuthStatusHandler, middleauth.OptionalAuth)
 	e.GET("/login", loginPage)
 	e.POST("/api/logout", middleauth.LogoutHandler)
+	e.POST("/api/auth/refresh", middleauth.RefreshHandler)
+	e.GET("/calendar/:token", handlers.CalendarFeedHandler)
 
 	// Create a group for routes that require authentication
 	authGroup := e.Group("")
@@ -24,6 +26,12 @@ func SetupRoutes(e *echo.Echo) {
 
 	// User routes
 	authGroup.GET("/api/user/current", handlers.GetCurrentUserHandler)
+	authGroup.GET("/api/user/tokens", handlers.GetAccessTokensHandler)
+	authGroup.POST("/api/user/tokens", handlers.CreateAccessTokenHandler)
+	authGroup.DELETE("/api/user/tokens/:id", handlers.DeleteAccessTokenHandler)
+	authGroup.GET("/api/user/sessions", handlers.GetLoginSessionsHandler)
+	authGroup.DELETE("/api/user/sessions", handlers.RevokeOtherLoginSessionsHandler)
+	authGroup.DELETE("/api/user/sessions/:id", handlers.RevokeLoginSessionHandler)
 
 	// Admin routes group - requires admin privileges
 	adminGroup := authGroup.Group("/admin")
@@ -36,6 +44,10 @@ func SetupRoutes(e *echo.Echo) {
 	adminGroup.DELETE("/api/users/:id", handlers.DeleteUserHandler)
 	adminGroup.PUT("/api/users/:id/admin", handlers.SetAdminHandler)
 	adminGroup.POST("/api/users/:id/reset-password", handlers.ResetPasswordHandler)
+	adminGroup.POST("/api/tags", handlers.CreateGlobalTagHandler)
+	adminGroup.GET("/api/sessions/orphaned", handlers.GetOrphanedSessionsHandler)
+	adminGroup.POST("/api/sessions/orphaned/assign", handlers.AssignOrphanedSessionsHandler)
+	adminGroup.DELETE("/api/sessions/orphaned", handlers.DeleteOrphanedSessionsHandler)
 
 	// Admin pages
 	adminGroup.GET("", adminDashboardPage)
@@ -49,39 +61,117 @@ func SetupRoutes(e *echo.Echo) {
 	authGroup.GET("/notes", notesPage)
 	authGroup.GET("/activities", activitiesPage)
 
+	// Ownership checks, resolving the session behind the resource in the path
+	ownSession := middleauth.RequireSessionOwner("Session", middleauth.SessionParam("id"))
+	ownSessionParam := middleauth.RequireSessionOwner("Session", middleauth.SessionParam("session_id"))
+	ownPomodoro := middleauth.RequireSessionOwner("Pomodoro", middleauth.PomodoroParam("id"))
+	ownBreak := middleauth.RequireSessionOwner("Break", middleauth.BreakParam("id"))
+	ownNote := middleauth.RequireSessionOwner("Note", middleauth.NoteParam("id"))
+	ownNoteHistory := middleauth.RequireNoteHistoryOwner("id")
+
 	// Session CRUD - protected API routes
 	authGroup.POST("/api/sessions", handlers.CreateSessionHandler)
 	authGroup.GET("/api/sessions", handlers.GetSessionsHandler)
-	authGroup.GET("/api/sessions/:id", handlers.GetSessionHandler)
-	authGroup.PUT("/api/sessions/:id", handlers.UpdateSessionHandler)
-	authGroup.DELETE("/api/sessions/:id", handlers.DeleteSessionHandler)
+	authGroup.GET("/api/sessions/:id", handlers.GetSessionHandler, ownSession)
+	authGroup.PUT("/api/sessions/:id", handlers.UpdateSessionHandler, ownSession)
+	authGroup.DELETE("/api/sessions/:id", handlers.DeleteSessionHandler, ownSession)
 	authGroup.GET("/api/sessions/tag", handlers.GetSessionsByTagHandler)
 
 	// Pomodoro CRUD - protected API routes
 	authGroup.POST("/api/pomodoros", handlers.CreatePomodoroHandler)
-	authGroup.GET("/api/pomodoros/:session_id", handlers.GetPomodorosHandler)
-	authGroup.PUT("/api/pomodoros/:id", handlers.UpdatePomodoroHandler)
+	authGroup.GET("/api/pomodoros/:session_id", handlers.GetPomodorosHandler, ownSessionParam)
+	authGroup.GET("/api/pomodoro/:id", handlers.GetPomodoroHandler, ownPomodoro)
+	authGroup.PUT("/api/pomodoros/:id", handlers.UpdatePomodoroHandler, ownPomodoro)
+	authGroup.PUT("/api/pomodoros/:id/task", handlers.SetPomodoroTaskHandler, ownPomodoro)
+	authGroup.GET("/api/pomodoros/:id/interruptions", handlers.GetInterruptionsHandler, ownPomodoro)
+
+	// Interruptions - protected API routes
+	authGroup.POST("/api/interruptions", handlers.LogInterruptionHandler)
+	authGroup.GET("/api/interruptions/report", handlers.GetInterruptionReportHandler)
 
 	// Break CRUD - protected API routes
 	authGroup.POST("/api/breaks", handlers.CreateBreakHandler)
-	authGroup.GET("/api/breaks/:session_id", handlers.GetBreaksHandler)
-	authGroup.PUT("/api/breaks/:id", handlers.UpdateBreakHandler)
+	authGroup.GET("/api/breaks/:session_id", handlers.GetBreaksHandler, ownSessionParam)
+	authGroup.GET("/api/break/:id", handlers.GetBreakHandler, ownBreak)
+	authGroup.PUT("/api/breaks/:id", handlers.UpdateBreakHandler, ownBreak)
 
 	// Note CRUD - protected API routes
 	authGroup.POST("/api/notes", handlers.CreateNoteHandler)
-	authGroup.GET("/api/notes/:session_id", handlers.GetNotesHandler)
+	authGroup.GET("/api/notes/:session_id", handlers.GetNotesHandler, ownSessionParam)
+	authGroup.GET("/api/note/:id", handlers.GetNoteHandler, ownNote)
 	authGroup.GET("/api/notes", handlers.GetAllNotesHandler)
-	authGroup.PUT("/api/notes/:id", handlers.UpdateNoteHandler)
-	authGroup.DELETE("/api/notes/:id", handlers.DeleteNoteHandler)
+	authGroup.GET("/api/notes/search", handlers.SearchNotesHandler)
+	authGroup.PUT("/api/notes/:id", handlers.UpdateNoteHandler, ownNote)
+	authGroup.DELETE("/api/notes/:id", handlers.DeleteNoteHandler, ownNote)
+	authGroup.GET("/api/notes/:id/revisions", handlers.GetNoteRevisionsHandler, ownNoteHistory)
+	authGroup.GET("/api/notes/:id/revisions/diff", handlers.GetNoteRevisionDiffHandler, ownNoteHistory)
+	authGroup.POST("/api/notes/:id/revisions/:revision_id/restore", handlers.RestoreNoteRevisionHandler, ownNoteHistory)
+
+	// Timer - protected API routes
+	authGroup.GET("/api/timer", handlers.GetTimerHandler)
+	authGroup.POST("/api/timer/start", handlers.StartTimerHandler)
+	authGroup.POST("/api/timer/pause", handlers.PauseTimerHandler)
+	authGroup.POST("/api/timer/resume", handlers.ResumeTimerHandler)
+	authGroup.POST("/api/timer/skip", handlers.SkipTimerHandler)
+	authGroup.POST("/api/timer/stop", handlers.StopTimerHandler)
+	authGroup.POST("/api/timer/task", handlers.SetTimerTaskHandler)
+
+	// Timer profiles - protected API routes
+	authGroup.GET("/api/profiles", handlers.GetTimerProfilesHandler)
+	authGroup.POST("/api/profiles", handlers.CreateTimerProfileHandler)
+	authGroup.GET("/api/profiles/:id", handlers.GetTimerProfileHandler)
+	authGroup.PUT("/api/profiles/:id", handlers.UpdateTimerProfileHandler)
+	authGroup.DELETE("/api/profiles/:id", handlers.DeleteTimerProfileHandler)
+	authGroup.PUT("/api/profiles/:id/default", handlers.SetDefaultTimerProfileHandler)
+
+	// Project CRUD - protected API routes
+	authGroup.GET("/api/projects", handlers.GetProjectsHandler)
+	authGroup.POST("/api/projects", handlers.CreateProjectHandler)
+	authGroup.GET("/api/projects/stats", handlers.GetProjectStatsHandler)
+	authGroup.GET("/api/projects/:id", handlers.GetProjectHandler)
+	authGroup.PUT("/api/projects/:id", handlers.UpdateProjectHandler)
+	authGroup.DELETE("/api/projects/:id", handlers.DeleteProjectHandler)
+
+	// Task CRUD - protected API routes
+	authGroup.GET("/api/tasks", handlers.GetTasksHandler)
+	authGroup.POST("/api/tasks", handlers.CreateTaskHandler)
+	authGroup.GET("/api/tasks/report", handlers.GetTaskReportHandler)
+	authGroup.GET("/api/tasks/:id", handlers.GetTaskHandler)
+	authGroup.PUT("/api/tasks/:id", handlers.UpdateTaskHandler)
+	authGroup.DELETE("/api/tasks/:id", handlers.DeleteTaskHandler)
+
+	// Planned sessions - protected API routes
+	authGroup.GET("/api/planned-sessions", handlers.GetPlannedSessionsHandler)
+	authGroup.POST("/api/planned-sessions", handlers.CreatePlannedSessionHandler)
+	authGroup.GET("/api/planned-sessions/reconcile", handlers.ReconcilePlannedSessionsHandler)
+	authGroup.POST("/api/planned-sessions/import", handlers.ImportPlannedSessionsHandler)
+	authGroup.GET("/api/planned-sessions/:id", handlers.GetPlannedSessionHandler)
+	authGroup.DELETE("/api/planned-sessions/:id", handlers.DeletePlannedSessionHandler)
 
 	// Tag CRUD - protected API routes
 	authGroup.GET("/api/tags", handlers.GetTagsHandler)
 	authGroup.POST("/api/tags", handlers.CreateTagHandler)
 	authGroup.PUT("/api/tags/:id", handlers.UpdateTagHandler)
 	authGroup.DELETE("/api/tags/:id", handlers.DeleteTagHandler)
+	authGroup.PUT("/api/tags/:id/parent", handlers.MoveTagHandler)
+	authGroup.POST("/api/tags/:id/merge", handlers.MergeTagsHandler)
+
+	// Export routes
+	authGroup.GET("/api/export", handlers.ExportAccountHandler)
+	authGroup.POST("/api/import", handlers.ImportAccountHandler)
+	authGroup.POST("/api/import/sessions/preview", handlers.PreviewSessionImportHandler)
+	authGroup.POST("/api/import/sessions", handlers.ImportSessionsHandler)
+	authGroup.GET("/api/export/notes", handlers.ExportNotesHandler)
+	authGroup.GET("/api/export/timesheet.csv", handlers.ExportTimesheetHandler)
+
+	// Calendar feed routes
+	authGroup.GET("/api/calendar/feed", handlers.GetCalendarFeedHandler)
+	authGroup.POST("/api/calendar/feed", handlers.CreateCalendarFeedHandler)
+	authGroup.DELETE("/api/calendar/feed", handlers.DeleteCalendarFeedHandler)
 
 	// Stats routes
 	authGroup.GET("/api/stats/monthly-tags", handlers.GetMonthlyTagStatsHandler)
+	authGroup.GET("/api/stats/focus", handlers.GetFocusAnalyticsHandler)
 
 	adminGroup.GET("/api/db-stats", handlers.GetDatabaseStatsHandler)
 	adminGroup.POST("/api/check-integrity", handlers.CheckDatabaseIntegrityHandler)
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Secret token giving read access to a user's calendar feed
+type CalendarFeed struct {
+	UserID    int    `json:"user_id"`
+	Token     string `json:"token"`
+	CreatedAt string `json:"created_at"`
+}
+
+func GetCalendarFeed(userID int) (CalendarFeed, error) {
+	var feed CalendarFeed
+	err := db.QueryRow("SELECT user_id, token, created_at FROM calendar_feeds WHERE user_id = ?", userID).
+		Scan(&feed.UserID, &feed.Token, &feed.CreatedAt)
+	return feed, err
+}
+
+// Give the user a new feed token, replacing the old one so that leaked URLs
+// stop working
+func CreateCalendarFeed(userID int) (CalendarFeed, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return CalendarFeed{}, err
+	}
+
+	_, err := db.Exec(`INSERT INTO calendar_feeds(user_id, token) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET token = excluded.token, created_at = CURRENT_TIMESTAMP`,
+		userID, hex.EncodeToString(bytes))
+	if err != nil {
+		return CalendarFeed{}, err
+	}
+	return GetCalendarFeed(userID)
+}
+
+func DeleteCalendarFeed(userID int) error {
+	_, err := db.Exec("DELETE FROM calendar_feeds WHERE user_id = ?", userID)
+	return err
+}
+
+// Get the active user a feed token belongs to
+func GetUserByCalendarToken(token string) (User, error) {
+	var user User
+	row := db.QueryRow(`
+		SELECT u.id, u.username, u.password_hash, u.email, u.is_admin, u.created_at, u.last_login, u.account_status
+		FROM calendar_feeds f
+		JOIN users u ON u.id = f.user_id
+		WHERE f.token = ? AND COALESCE(u.account_status, 'active') = 'active'
+	`, token)
+	err := row.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Email, &user.IsAdmin, &user.CreatedAt, &user.LastLogin, &user.AccountStatus)
+	return user, err
+}
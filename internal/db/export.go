@@ -0,0 +1,579 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Identifies an account export and the version of its layout. Bump the
+// version whenever the layout changes in a way older imports can't read.
+const (
+	ExportFormat  = "pomonotes-export"
+	ExportVersion = 2 // 2 added tasks, interruptions and planned sessions
+)
+
+var ErrUnsupportedExport = errors.New("unsupported export format or version")
+
+// Everything a user owns, with IDs only meaningful within the document
+type AccountExport struct {
+	Format     string          `json:"format"`
+	Version    int             `json:"version"`
+	ExportedAt string          `json:"exported_at"`
+	Username   string          `json:"username"`
+	Tags       []ExportTag     `json:"tags"`
+	Projects   []ExportProject `json:"projects"`
+	Profiles   []ExportProfile `json:"profiles"`
+	Sessions   []ExportSession `json:"sessions"`
+
+	Tasks           []ExportTask           `json:"tasks"`
+	PlannedSessions []ExportPlannedSession `json:"planned_sessions"`
+}
+
+type ExportTag struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Color    string `json:"color"`
+	ParentID *int   `json:"parent_id"`
+}
+
+type ExportProject struct {
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Color       string  `json:"color"`
+	Archived    bool    `json:"archived"`
+	Client      *string `json:"client"`
+	Description *string `json:"description"`
+}
+
+type ExportProfile struct {
+	ID                 int     `json:"id"`
+	Name               string  `json:"name"`
+	WorkLength         int     `json:"work_length"`
+	ShortBreakLength   int     `json:"short_break_length"`
+	LongBreakLength    int     `json:"long_break_length"`
+	LongBreakEvery     int     `json:"long_break_every"`
+	AutoStartBreaks    bool    `json:"auto_start_breaks"`
+	AutoStartPomodoros bool    `json:"auto_start_pomodoros"`
+	FlowBreakRatio     float64 `json:"flow_break_ratio"`
+}
+
+type ExportTask struct {
+	ID          int      `json:"id"`
+	Title       string   `json:"title"`
+	Estimate    int      `json:"estimate"`
+	Status      string   `json:"status"`
+	DueDate     *string  `json:"due_date"`
+	Tags        []string `json:"tags"`
+	ProjectID   *int     `json:"project_id"`
+	CreatedAt   string   `json:"created_at"`
+	CompletedAt *string  `json:"completed_at"`
+}
+
+type ExportPlannedSession struct {
+	ID                int      `json:"id"`
+	Title             string   `json:"title"`
+	StartTime         string   `json:"start_time"`
+	Duration          int      `json:"duration"`
+	ExpectedPomodoros int      `json:"expected_pomodoros"`
+	Tags              []string `json:"tags"`
+	ProjectID         *int     `json:"project_id"`
+	Recurrence        *string  `json:"recurrence"`
+	ExDates           []string `json:"exdates"`
+	Timezone          string   `json:"timezone"`
+	ICalUID           *string  `json:"ical_uid"`
+}
+
+type ExportSession struct {
+	ID                 int              `json:"id"`
+	StartTime          string           `json:"start_time"`
+	EndTime            *string          `json:"end_time"`
+	TotalTime          int              `json:"total_time"`
+	Status             string           `json:"status"`
+	CompletedPomodoros int              `json:"completed_pomodoros"`
+	Mode               string           `json:"mode"`
+	Tags               []string         `json:"tags"` // Tag names, in order
+	ProjectID          *int             `json:"project_id"`
+	ProfileID          *int             `json:"profile_id"`
+	Pomodoros          []ExportPomodoro `json:"pomodoros"`
+	Breaks             []ExportBreak    `json:"breaks"`
+	Notes              []ExportNote     `json:"notes"`
+}
+
+type ExportPomodoro struct {
+	ID        int    `json:"id"`
+	Number    int    `json:"number"`
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+	Duration  int    `json:"duration"`
+	Status    string `json:"status"`
+
+	FocusRating *int    `json:"focus_rating,omitempty"`
+	EnergyLevel *int    `json:"energy_level,omitempty"`
+	Reflection  *string `json:"reflection,omitempty"`
+
+	TaskID        *int                 `json:"task_id,omitempty"`
+	Interruptions []ExportInterruption `json:"interruptions,omitempty"`
+}
+
+type ExportInterruption struct {
+	Type      string  `json:"type"`
+	Reason    *string `json:"reason"`
+	CreatedAt string  `json:"created_at"`
+}
+
+type ExportBreak struct {
+	ID         int    `json:"id"`
+	PomodoroID *int   `json:"pomodoro_id"`
+	Type       string `json:"type"`
+	StartTime  string `json:"start_time"`
+	EndTime    string `json:"end_time"`
+	Duration   int    `json:"duration"`
+	Status     string `json:"status"`
+}
+
+type ExportNote struct {
+	ID         int    `json:"id"`
+	PomodoroID *int   `json:"pomodoro_id"`
+	Note       string `json:"note"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// What an import added to the account
+type ImportSummary struct {
+	SessionsImported int `json:"sessions_imported"`
+	SessionsSkipped  int `json:"sessions_skipped"` // Already present with the same start time
+	Pomodoros        int `json:"pomodoros"`
+	Breaks           int `json:"breaks"`
+	Notes            int `json:"notes"`
+	TagsCreated      int `json:"tags_created"`
+	ProjectsCreated  int `json:"projects_created"`
+	ProfilesCreated  int `json:"profiles_created"`
+	Interruptions    int `json:"interruptions"`
+	TasksCreated     int `json:"tasks_created"`
+	PlansCreated     int `json:"plans_created"`
+}
+
+// Helper function to turn a zero ID into a missing reference
+func optionalID(id int) *int {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
+
+// Collect all data of a user into an export document
+func ExportUserData(userID int) (AccountExport, error) {
+	export := AccountExport{
+		Format:     ExportFormat,
+		Version:    ExportVersion,
+		ExportedAt: time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+		Tags:       []ExportTag{},
+		Projects:   []ExportProject{},
+		Profiles:   []ExportProfile{},
+		Sessions:   []ExportSession{},
+
+		Tasks:           []ExportTask{},
+		PlannedSessions: []ExportPlannedSession{},
+	}
+
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return export, err
+	}
+	export.Username = user.Username
+
+	tags, err := GetTagsForUser(userID)
+	if err != nil {
+		return export, err
+	}
+	for _, tag := range tags {
+		export.Tags = append(export.Tags, ExportTag{ID: tag.ID, Name: tag.Name, Color: tag.Color, ParentID: tag.ParentID})
+	}
+
+	projects, err := GetProjectsForUser(userID, true)
+	if err != nil {
+		return export, err
+	}
+	for _, project := range projects {
+		export.Projects = append(export.Projects, ExportProject{
+			ID: project.ID, Name: project.Name, Color: project.Color, Archived: project.Archived,
+			Client: project.Client, Description: project.Description,
+		})
+	}
+
+	profiles, err := GetTimerProfilesForUser(userID)
+	if err != nil {
+		return export, err
+	}
+	for _, profile := range profiles {
+		export.Profiles = append(export.Profiles, ExportProfile{
+			ID: profile.ID, Name: profile.Name, WorkLength: profile.WorkLength, ShortBreakLength: profile.ShortBreakLength,
+			LongBreakLength: profile.LongBreakLength, LongBreakEvery: profile.LongBreakEvery, AutoStartBreaks: profile.AutoStartBreaks,
+			AutoStartPomodoros: profile.AutoStartPomodoros, FlowBreakRatio: profile.FlowBreakRatio,
+		})
+	}
+
+	tasks, err := GetTasksForUser(userID, "")
+	if err != nil {
+		return export, err
+	}
+	for _, task := range tasks {
+		export.Tasks = append(export.Tasks, ExportTask{
+			ID: task.ID, Title: task.Title, Estimate: task.Estimate, Status: task.Status, DueDate: task.DueDate,
+			Tags: parseTagNames(task.Tags), ProjectID: task.ProjectID, CreatedAt: task.CreatedAt, CompletedAt: task.CompletedAt,
+		})
+	}
+
+	plans, err := GetPlannedSessionsForUser(userID)
+	if err != nil {
+		return export, err
+	}
+	for _, plan := range plans {
+		export.PlannedSessions = append(export.PlannedSessions, ExportPlannedSession{
+			ID: plan.ID, Title: plan.Title, StartTime: plan.StartTime, Duration: plan.Duration, ExpectedPomodoros: plan.ExpectedPomodoros,
+			Tags: parseTagNames(plan.Tags), ProjectID: plan.ProjectID, Recurrence: plan.Recurrence, ExDates: plan.ExDates,
+			Timezone: plan.Timezone, ICalUID: plan.ICalUID,
+		})
+	}
+
+	sessions, err := GetSessionsForUser(userID)
+	if err != nil {
+		return export, err
+	}
+	for _, session := range sessions {
+		item := ExportSession{
+			ID: session.ID, StartTime: session.StartTime, EndTime: session.EndTime, TotalTime: session.TotalTime,
+			Status: session.Status, CompletedPomodoros: session.Completed, Mode: session.Mode, Tags: parseTagNames(session.Tags),
+			ProjectID: session.ProjectID, ProfileID: session.ProfileID,
+			Pomodoros: []ExportPomodoro{}, Breaks: []ExportBreak{}, Notes: []ExportNote{},
+		}
+
+		pomodoros, err := GetPomodoros(session.ID)
+		if err != nil {
+			return export, err
+		}
+		for _, pomodoro := range pomodoros {
+			exported := ExportPomodoro{
+				ID: pomodoro.ID, Number: pomodoro.Number, StartTime: pomodoro.StartTime, EndTime: pomodoro.EndTime,
+				Duration: pomodoro.Duration, Status: pomodoro.Status,
+				FocusRating: pomodoro.FocusRating, EnergyLevel: pomodoro.EnergyLevel, Reflection: pomodoro.Reflection,
+				TaskID: pomodoro.TaskID,
+			}
+
+			if pomodoro.InternalInterruptions+pomodoro.ExternalInterruptions > 0 {
+				interruptions, err := GetInterruptions(pomodoro.ID)
+				if err != nil {
+					return export, err
+				}
+				for _, interruption := range interruptions {
+					exported.Interruptions = append(exported.Interruptions, ExportInterruption{
+						Type: interruption.Type, Reason: interruption.Reason, CreatedAt: interruption.CreatedAt,
+					})
+				}
+			}
+
+			item.Pomodoros = append(item.Pomodoros, exported)
+		}
+
+		breaks, err := GetBreaks(session.ID)
+		if err != nil {
+			return export, err
+		}
+		for _, breakItem := range breaks {
+			item.Breaks = append(item.Breaks, ExportBreak{
+				ID: breakItem.ID, PomodoroID: optionalID(breakItem.PomodoroID), Type: breakItem.Type, StartTime: breakItem.StartTime,
+				EndTime: breakItem.EndTime, Duration: breakItem.Duration, Status: breakItem.Status,
+			})
+		}
+
+		notes, err := GetNotes(session.ID)
+		if err != nil {
+			return export, err
+		}
+		for _, note := range notes {
+			item.Notes = append(item.Notes, ExportNote{
+				ID: note.ID, PomodoroID: optionalID(note.PomodoroID), Note: note.NoteText, CreatedAt: note.CreatedAt,
+			})
+		}
+
+		export.Sessions = append(export.Sessions, item)
+	}
+
+	return export, nil
+}
+
+// Recreate an export in a user's account within one transaction. IDs are
+// remapped, tags, projects and profiles are merged with existing ones of the
+// same name, and sessions, tasks and plans already present (by start time,
+// by title and creation time, and by title and start time) are skipped.
+func ImportUserData(userID int, data AccountExport) (ImportSummary, error) {
+	var summary ImportSummary
+	if data.Format != ExportFormat || data.Version < 1 || data.Version > ExportVersion {
+		return summary, ErrUnsupportedExport
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return summary, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	owner := sql.NullInt64{Int64: int64(userID), Valid: true}
+
+	// Tags, merged by name with the user's own or global tags
+	tagIDs := make(map[int]int64)
+	createdTags := make(map[int]bool)
+	for _, tag := range data.Tags {
+		var tagID int64
+		err = tx.QueryRow("SELECT id FROM tags WHERE name = ? AND (user_id = ? OR user_id IS NULL) ORDER BY user_id IS NULL LIMIT 1",
+			tag.Name, userID).Scan(&tagID)
+		if errors.Is(err, sql.ErrNoRows) {
+			tagID, err = ensureTag(tx, owner, tag.Name)
+			if err == nil && tag.Color != "" {
+				_, err = tx.Exec("UPDATE tags SET color = ? WHERE id = ?", tag.Color, tagID)
+			}
+			createdTags[tag.ID] = true
+			summary.TagsCreated++
+		}
+		if err != nil {
+			return summary, fmt.Errorf("failed to import tag %q: %w", tag.Name, err)
+		}
+		tagIDs[tag.ID] = tagID
+	}
+
+	// Only new tags take over the exported hierarchy
+	for _, tag := range data.Tags {
+		if !createdTags[tag.ID] || tag.ParentID == nil {
+			continue
+		}
+		if parentID, ok := tagIDs[*tag.ParentID]; ok {
+			_, err = tx.Exec("UPDATE tags SET parent_id = ? WHERE id = ?", parentID, tagIDs[tag.ID])
+			if err != nil {
+				return summary, fmt.Errorf("failed to import parent of tag %q: %w", tag.Name, err)
+			}
+		}
+	}
+
+	// Projects, merged by name
+	projectIDs := make(map[int]int64)
+	for _, project := range data.Projects {
+		var projectID int64
+		err = tx.QueryRow("SELECT id FROM projects WHERE user_id = ? AND name = ?", userID, project.Name).Scan(&projectID)
+		if errors.Is(err, sql.ErrNoRows) {
+			color := project.Color
+			if color == "" {
+				color = getRandomColor()
+			}
+			var result sql.Result
+			result, err = tx.Exec("INSERT INTO projects(user_id, name, color, archived, client, description) VALUES (?, ?, ?, ?, ?, ?)",
+				userID, project.Name, color, project.Archived, project.Client, project.Description)
+			if err == nil {
+				projectID, err = result.LastInsertId()
+			}
+			summary.ProjectsCreated++
+		}
+		if err != nil {
+			return summary, fmt.Errorf("failed to import project %q: %w", project.Name, err)
+		}
+		projectIDs[project.ID] = projectID
+	}
+
+	// Timer profiles, merged by name
+	profileIDs := make(map[int]int64)
+	for _, profile := range data.Profiles {
+		var profileID int64
+		err = tx.QueryRow("SELECT id FROM timer_profiles WHERE user_id = ? AND name = ?", userID, profile.Name).Scan(&profileID)
+		if errors.Is(err, sql.ErrNoRows) {
+			var result sql.Result
+			result, err = tx.Exec(`INSERT INTO timer_profiles(user_id, name, work_length, short_break_length, long_break_length,
+				long_break_every, auto_start_breaks, auto_start_pomodoros, flow_break_ratio) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				userID, profile.Name, profile.WorkLength, profile.ShortBreakLength, profile.LongBreakLength,
+				profile.LongBreakEvery, profile.AutoStartBreaks, profile.AutoStartPomodoros, profile.FlowBreakRatio)
+			if err == nil {
+				profileID, err = result.LastInsertId()
+			}
+			summary.ProfilesCreated++
+		}
+		if err != nil {
+			return summary, fmt.Errorf("failed to import profile %q: %w", profile.Name, err)
+		}
+		profileIDs[profile.ID] = profileID
+	}
+
+	// Helper to translate an exported reference, dropping unknown ones
+	remap := func(ids map[int]int64, id *int) interface{} {
+		if id == nil {
+			return nil
+		}
+		if mapped, ok := ids[*id]; ok {
+			return mapped
+		}
+		return nil
+	}
+
+	// Tasks, before the pomodoros booked against them
+	taskIDs := make(map[int]int64)
+	for _, task := range data.Tasks {
+		var taskID int64
+		err = tx.QueryRow("SELECT id FROM tasks WHERE user_id = ? AND title = ? AND created_at = ?", userID, task.Title, task.CreatedAt).Scan(&taskID)
+		if errors.Is(err, sql.ErrNoRows) {
+			status := task.Status
+			if status == "" {
+				status = TaskStatusTodo
+			}
+			createdAt := task.CreatedAt
+			if createdAt == "" {
+				createdAt = time.Now().UTC().Format("2006-01-02 15:04:05")
+			}
+			var result sql.Result
+			result, err = tx.Exec("INSERT INTO tasks(user_id, title, estimate, status, due_date, project_id, created_at, completed_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+				userID, task.Title, task.Estimate, status, task.DueDate, remap(projectIDs, task.ProjectID), createdAt, task.CompletedAt)
+			if err == nil {
+				taskID, err = result.LastInsertId()
+			}
+			if err == nil {
+				err = setTagLinks(tx, "task_tags", "task_id", taskID, userID, strings.Join(task.Tags, ","))
+			}
+			summary.TasksCreated++
+		}
+		if err != nil {
+			return summary, fmt.Errorf("failed to import task %q: %w", task.Title, err)
+		}
+		taskIDs[task.ID] = taskID
+	}
+
+	for _, plan := range data.PlannedSessions {
+		var existing int
+		err = tx.QueryRow("SELECT COUNT(*) FROM planned_sessions WHERE user_id = ? AND COALESCE(title, '') = ? AND start_time = ?",
+			userID, plan.Title, plan.StartTime).Scan(&existing)
+		if err != nil {
+			return summary, fmt.Errorf("failed to check for duplicate plans: %w", err)
+		}
+		if existing > 0 {
+			continue
+		}
+
+		planned := PlannedSession{
+			Title: plan.Title, StartTime: plan.StartTime, Duration: plan.Duration, ExpectedPomodoros: plan.ExpectedPomodoros,
+			Tags: strings.Join(plan.Tags, ","), Recurrence: plan.Recurrence, ExDates: plan.ExDates, Timezone: plan.Timezone,
+			ICalUID: plan.ICalUID,
+		}
+		if projectID, ok := remap(projectIDs, plan.ProjectID).(int64); ok {
+			id := int(projectID)
+			planned.ProjectID = &id
+		}
+		if planned.Timezone == "" {
+			planned.Timezone = "UTC"
+		}
+		if _, err = insertPlannedSession(tx, userID, planned); err != nil {
+			return summary, fmt.Errorf("failed to import plan %q: %w", plan.Title, err)
+		}
+		summary.PlansCreated++
+	}
+
+	for _, session := range data.Sessions {
+		var existing int
+		err = tx.QueryRow("SELECT COUNT(*) FROM sessions WHERE user_id = ? AND start_time = ?", userID, session.StartTime).Scan(&existing)
+		if err != nil {
+			return summary, fmt.Errorf("failed to check for duplicate sessions: %w", err)
+		}
+		if existing > 0 {
+			summary.SessionsSkipped++
+			continue
+		}
+
+		mode := session.Mode
+		if mode == "" {
+			mode = SessionModeClassic
+		}
+
+		var result sql.Result
+		result, err = tx.Exec(`INSERT INTO sessions(start_time, end_time, total_time, status, completed_pomodoros, user_id, profile_id, mode, project_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			session.StartTime, session.EndTime, session.TotalTime, session.Status, session.CompletedPomodoros, userID,
+			remap(profileIDs, session.ProfileID), mode, remap(projectIDs, session.ProjectID))
+		if err != nil {
+			return summary, fmt.Errorf("failed to import session %d: %w", session.ID, err)
+		}
+
+		var sessionID int64
+		sessionID, err = result.LastInsertId()
+		if err != nil {
+			return summary, err
+		}
+
+		if err = setSessionTags(tx, sessionID, strings.Join(session.Tags, ",")); err != nil {
+			return summary, err
+		}
+
+		pomodoroIDs := make(map[int]int64)
+		for _, pomodoro := range session.Pomodoros {
+			result, err = tx.Exec(`INSERT INTO pomodoros(session_id, number, start_time, end_time, duration, status, focus_rating, energy_level, reflection, task_id)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				sessionID, pomodoro.Number, pomodoro.StartTime, pomodoro.EndTime, pomodoro.Duration, pomodoro.Status,
+				pomodoro.FocusRating, pomodoro.EnergyLevel, pomodoro.Reflection, remap(taskIDs, pomodoro.TaskID))
+			if err != nil {
+				return summary, fmt.Errorf("failed to import pomodoro %d: %w", pomodoro.ID, err)
+			}
+			if pomodoroIDs[pomodoro.ID], err = result.LastInsertId(); err != nil {
+				return summary, err
+			}
+			summary.Pomodoros++
+
+			for _, interruption := range pomodoro.Interruptions {
+				_, err = tx.Exec("INSERT INTO interruptions(session_id, pomodoro_id, type, reason, created_at) VALUES (?, ?, ?, ?, ?)",
+					sessionID, pomodoroIDs[pomodoro.ID], interruption.Type, interruption.Reason, interruption.CreatedAt)
+				if err != nil {
+					return summary, fmt.Errorf("failed to import interruption of pomodoro %d: %w", pomodoro.ID, err)
+				}
+				summary.Interruptions++
+			}
+		}
+
+		for _, breakItem := range session.Breaks {
+			_, err = tx.Exec("INSERT INTO breaks(session_id, pomodoro_id, type, start_time, end_time, duration, status) VALUES (?, ?, ?, ?, ?, ?, ?)",
+				sessionID, remap(pomodoroIDs, breakItem.PomodoroID), breakItem.Type, breakItem.StartTime, breakItem.EndTime,
+				breakItem.Duration, breakItem.Status)
+			if err != nil {
+				return summary, fmt.Errorf("failed to import break %d: %w", breakItem.ID, err)
+			}
+			summary.Breaks++
+		}
+
+		for _, note := range session.Notes {
+			createdAt := note.CreatedAt
+			if createdAt == "" {
+				createdAt = time.Now().UTC().Format("2006-01-02 15:04:05")
+			}
+			result, err = tx.Exec("INSERT INTO notes(session_id, pomodoro_id, note, created_at) VALUES (?, ?, ?, ?)",
+				sessionID, remap(pomodoroIDs, note.PomodoroID), note.Note, createdAt)
+			if err != nil {
+				return summary, fmt.Errorf("failed to import note %d: %w", note.ID, err)
+			}
+			var noteID int64
+			if noteID, err = result.LastInsertId(); err != nil {
+				return summary, err
+			}
+			if err = recordNoteRevision(tx, noteID, NoteRevisionCreate); err != nil {
+				return summary, err
+			}
+			summary.Notes++
+		}
+
+		summary.SessionsImported++
+	}
+
+	if err = tx.Commit(); err != nil {
+		return summary, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,188 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Average focus of the rated pomodoros in one group
+type FocusStats struct {
+	Group         string  `json:"group"`
+	Pomodoros     int     `json:"pomodoros"` // Rated pomodoros only
+	AverageFocus  float64 `json:"average_focus"`
+	AverageEnergy float64 `json:"average_energy"` // Over the pomodoros with an energy level, 0 without any
+}
+
+// Focus ratings of a user broken down by when and on what they were worked
+type FocusAnalytics struct {
+	Overall        FocusStats   `json:"overall"`
+	TimeOfDay      []FocusStats `json:"time_of_day"`     // Per starting hour, e.g. 09
+	Weekday        []FocusStats `json:"weekday"`         // Monday first
+	Tag            []FocusStats `json:"tag"`             // Sessions with several tags count towards each
+	PomodoroNumber []FocusStats `json:"pomodoro_number"` // Position within the session
+
+	// Pearson correlation of the focus rating with the energy level and with
+	// the pomodoro number, nil when there isn't enough data
+	EnergyCorrelation *float64 `json:"energy_correlation"`
+	NumberCorrelation *float64 `json:"number_correlation"`
+}
+
+// Running totals behind a FocusStats
+type focusTotals struct {
+	pomodoros, focus, energyRated, energy int
+}
+
+func (t *focusTotals) add(focus int, energy *int) {
+	t.pomodoros++
+	t.focus += focus
+	if energy != nil {
+		t.energyRated++
+		t.energy += *energy
+	}
+}
+
+func (t *focusTotals) stats(group string) FocusStats {
+	stats := FocusStats{Group: group, Pomodoros: t.pomodoros}
+	if t.pomodoros > 0 {
+		stats.AverageFocus = float64(t.focus) / float64(t.pomodoros)
+	}
+	if t.energyRated > 0 {
+		stats.AverageEnergy = float64(t.energy) / float64(t.energyRated)
+	}
+	return stats
+}
+
+// Helper function to turn grouped totals into stats in the given order
+func focusGroups(totals map[string]*focusTotals, order []string) []FocusStats {
+	result := []FocusStats{}
+	for _, group := range order {
+		if t, ok := totals[group]; ok {
+			result = append(result, t.stats(group))
+		}
+	}
+	return result
+}
+
+// Helper function to sort the keys of grouped totals
+func sortedFocusGroups(totals map[string]*focusTotals, less func(a, b string) bool) []string {
+	keys := make([]string, 0, len(totals))
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return less(keys[i], keys[j]) })
+	return keys
+}
+
+// Pearson correlation coefficient of two series, nil if it is undefined
+func pearson(xs, ys []float64) *float64 {
+	n := float64(len(xs))
+	if len(xs) < 3 {
+		return nil
+	}
+	var sumX, sumY float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+
+	var cov, varX, varY float64
+	for i := range xs {
+		dx, dy := xs[i]-meanX, ys[i]-meanY
+		cov += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return nil
+	}
+	r := math.Round(cov/math.Sqrt(varX*varY)*1000) / 1000
+	return &r
+}
+
+// Break a user's focus ratings between two dates down by time of day,
+// weekday, tag and pomodoro number. Hours and weekdays are those of loc.
+func GetFocusAnalytics(userID int, startDate string, endDate string, loc *time.Location) (FocusAnalytics, error) {
+	var analytics FocusAnalytics
+
+	rows, err := db.Query(`
+		SELECT p.start_time, p.number, p.focus_rating, p.energy_level, COALESCE(`+sessionTagsColumn+`, '')
+		FROM pomodoros p
+		JOIN sessions ON sessions.id = p.session_id
+		WHERE sessions.user_id = ? AND p.focus_rating IS NOT NULL AND p.start_time >= ? AND p.start_time <= ?
+	`, userID, startDate, endDate)
+	if err != nil {
+		return analytics, fmt.Errorf("failed to get focus ratings: %w", err)
+	}
+	defer rows.Close()
+
+	var overall focusTotals
+	hours := make(map[string]*focusTotals)
+	weekdays := make(map[string]*focusTotals)
+	tags := make(map[string]*focusTotals)
+	numbers := make(map[string]*focusTotals)
+	add := func(groups map[string]*focusTotals, group string, focus int, energy *int) {
+		if _, ok := groups[group]; !ok {
+			groups[group] = &focusTotals{}
+		}
+		groups[group].add(focus, energy)
+	}
+
+	var energyFocus, energies, numberFocus, positions []float64
+	for rows.Next() {
+		var startTime, tagList string
+		var number, focus int
+		var energy *int
+		if err := rows.Scan(&startTime, &number, &focus, &energy, &tagList); err != nil {
+			return analytics, err
+		}
+		start, err := time.Parse(time.RFC3339, startTime)
+		if err != nil {
+			continue
+		}
+		start = start.In(loc)
+
+		overall.add(focus, energy)
+		add(hours, fmt.Sprintf("%02d", start.Hour()), focus, energy)
+		add(weekdays, start.Weekday().String(), focus, energy)
+		add(numbers, fmt.Sprintf("%d", number), focus, energy)
+		if tagList == "" {
+			add(tags, "(untagged)", focus, energy)
+		}
+		for _, tag := range strings.Split(tagList, ",") {
+			if tag != "" {
+				add(tags, tag, focus, energy)
+			}
+		}
+
+		numberFocus = append(numberFocus, float64(focus))
+		positions = append(positions, float64(number))
+		if energy != nil {
+			energyFocus = append(energyFocus, float64(focus))
+			energies = append(energies, float64(*energy))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return analytics, err
+	}
+
+	weekdayOrder := []string{}
+	for day := time.Monday; day <= time.Saturday; day++ {
+		weekdayOrder = append(weekdayOrder, day.String())
+	}
+	weekdayOrder = append(weekdayOrder, time.Sunday.String())
+
+	analytics.Overall = overall.stats("all")
+	analytics.TimeOfDay = focusGroups(hours, sortedFocusGroups(hours, func(a, b string) bool { return a < b }))
+	analytics.Weekday = focusGroups(weekdays, weekdayOrder)
+	analytics.Tag = focusGroups(tags, sortedFocusGroups(tags, func(a, b string) bool { return a < b }))
+	analytics.PomodoroNumber = focusGroups(numbers, sortedFocusGroups(numbers, func(a, b string) bool {
+		return len(a) < len(b) || (len(a) == len(b) && a < b)
+	}))
+	analytics.EnergyCorrelation = pearson(energies, energyFocus)
+	analytics.NumberCorrelation = pearson(positions, numberFocus)
+	return analytics, nil
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Something that broke into a pomodoro
+type Interruption struct {
+	ID         int     `json:"id"`
+	SessionID  int     `json:"session_id"`
+	PomodoroID int     `json:"pomodoro_id"`
+	Type       string  `json:"type"`
+	Reason     *string `json:"reason"`
+	CreatedAt  string  `json:"created_at"`
+}
+
+// Interruption types
+const (
+	InterruptionInternal = "internal" // Your own urge to do something else, marked with '
+	InterruptionExternal = "external" // Someone or something else, marked with -
+)
+
+// Interruptions of one day or tag
+type InterruptionStats struct {
+	Group       string  `json:"group"`
+	Internal    int     `json:"internal"`
+	External    int     `json:"external"`
+	Total       int     `json:"total"`
+	Pomodoros   int     `json:"pomodoros"` // All pomodoros of the group, interrupted or not
+	PerPomodoro float64 `json:"per_pomodoro"`
+}
+
+// Interruption CRUD functions
+
+func CreateInterruption(interruption Interruption) (int64, error) {
+	result, err := db.Exec("INSERT INTO interruptions(session_id, pomodoro_id, type, reason, created_at) VALUES (?, ?, ?, ?, ?)",
+		interruption.SessionID, interruption.PomodoroID, interruption.Type, interruption.Reason, interruption.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func GetInterruptions(pomodoroID int) ([]Interruption, error) {
+	rows, err := db.Query("SELECT id, session_id, pomodoro_id, type, reason, created_at FROM interruptions WHERE pomodoro_id = ? ORDER BY created_at", pomodoroID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	interruptions := []Interruption{}
+	for rows.Next() {
+		var interruption Interruption
+		err := rows.Scan(&interruption.ID, &interruption.SessionID, &interruption.PomodoroID, &interruption.Type, &interruption.Reason, &interruption.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		interruptions = append(interruptions, interruption)
+	}
+	return interruptions, rows.Err()
+}
+
+// Count a user's interruptions between two dates per day or per session tag,
+// next to the number of pomodoros in the same group. Sessions with several
+// tags count towards each of them.
+func GetInterruptionStats(userID int, startDate string, endDate string, groupBy string) ([]InterruptionStats, error) {
+	var join, interruptionGroup, pomodoroGroup string
+	switch groupBy {
+	case "day":
+		interruptionGroup = "date(i.created_at)"
+		pomodoroGroup = "date(p.start_time)"
+	case "tag":
+		join = "LEFT JOIN session_tags st ON st.session_id = s.id LEFT JOIN tags t ON t.id = st.tag_id"
+		interruptionGroup = "COALESCE(t.name, '(untagged)')"
+		pomodoroGroup = interruptionGroup
+	default:
+		return nil, fmt.Errorf("unknown grouping %q", groupBy)
+	}
+
+	stats := make(map[string]*InterruptionStats)
+	group := func(name string) *InterruptionStats {
+		if _, ok := stats[name]; !ok {
+			stats[name] = &InterruptionStats{Group: name}
+		}
+		return stats[name]
+	}
+
+	rows, err := db.Query(`
+		SELECT `+interruptionGroup+`,
+			COALESCE(SUM(CASE WHEN i.type = 'internal' THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN i.type = 'external' THEN 1 ELSE 0 END), 0)
+		FROM interruptions i
+		JOIN sessions s ON s.id = i.session_id
+		`+join+`
+		WHERE s.user_id = ? AND i.created_at >= ? AND i.created_at <= ?
+		GROUP BY 1
+	`, userID, startDate, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count interruptions: %w", err)
+	}
+	for rows.Next() {
+		var name string
+		var internal, external int
+		if err := rows.Scan(&name, &internal, &external); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		g := group(name)
+		g.Internal, g.External = internal, external
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(`
+		SELECT `+pomodoroGroup+`, COUNT(*)
+		FROM pomodoros p
+		JOIN sessions s ON s.id = p.session_id
+		`+join+`
+		WHERE s.user_id = ? AND p.start_time >= ? AND p.start_time <= ?
+		GROUP BY 1
+	`, userID, startDate, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count pomodoros: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var pomodoros int
+		if err := rows.Scan(&name, &pomodoros); err != nil {
+			return nil, err
+		}
+		group(name).Pomodoros = pomodoros
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]InterruptionStats, 0, len(stats))
+	for _, g := range stats {
+		g.Total = g.Internal + g.External
+		if g.Pomodoros > 0 {
+			g.PerPomodoro = float64(g.Total) / float64(g.Pomodoros)
+		}
+		result = append(result, *g)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Group < result[j].Group
+	})
+	return result, nil
+}
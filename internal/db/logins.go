@@ -0,0 +1,188 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// Returned when a refresh token that was already rotated away is used again
+// after the grace period, which suggests it was stolen
+var ErrRefreshTokenReused = errors.New("the refresh token was already used")
+
+// A device a user is logged in on. The refresh token the device holds is
+// stored as a hash and replaced on every refresh.
+type LoginSession struct {
+	ID         int    `json:"id"`
+	UserID     int    `json:"user_id"`
+	Device     string `json:"device"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"user_agent"`
+	CreatedAt  string `json:"created_at"`
+	LastSeenAt string `json:"last_seen_at"`
+	ExpiresAt  string `json:"expires_at"` // When the refresh token runs out
+}
+
+const loginSessionColumns = "id, user_id, COALESCE(device, ''), COALESCE(ip, ''), COALESCE(user_agent, ''), created_at, last_seen_at, expires_at"
+
+func scanLoginSession(row rowScanner) (LoginSession, error) {
+	var login LoginSession
+	err := row.Scan(&login.ID, &login.UserID, &login.Device, &login.IP, &login.UserAgent, &login.CreatedAt, &login.LastSeenAt, &login.ExpiresAt)
+	return login, err
+}
+
+// Helper function to make a refresh token and the hash stored for it
+func newRefreshToken() (string, string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(bytes)
+	return token, hashRefreshToken(token), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Login session CRUD functions
+
+// Record a login, returning the session and its first refresh token
+func CreateLoginSession(userID int, device string, ip string, userAgent string, lifetime time.Duration) (LoginSession, string, error) {
+	token, hash, err := newRefreshToken()
+	if err != nil {
+		return LoginSession{}, "", err
+	}
+
+	now := time.Now().UTC()
+	result, err := db.Exec(`INSERT INTO login_sessions(user_id, refresh_hash, device, ip, user_agent, created_at, last_seen_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, hash, device, ip, userAgent, now.Format("2006-01-02T15:04:05.000Z"), now.Format("2006-01-02T15:04:05.000Z"),
+		now.Add(lifetime).Format("2006-01-02T15:04:05.000Z"))
+	if err != nil {
+		return LoginSession{}, "", err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return LoginSession{}, "", err
+	}
+
+	login, err := GetLoginSession(int(id))
+	return login, token, err
+}
+
+// Get a login session that hasn't expired
+func GetLoginSession(id int) (LoginSession, error) {
+	row := db.QueryRow("SELECT "+loginSessionColumns+" FROM login_sessions WHERE id = ? AND expires_at > ?",
+		id, time.Now().UTC().Format("2006-01-02T15:04:05.000Z"))
+	return scanLoginSession(row)
+}
+
+func GetLoginSessionsForUser(userID int) ([]LoginSession, error) {
+	rows, err := db.Query("SELECT "+loginSessionColumns+" FROM login_sessions WHERE user_id = ? AND expires_at > ? ORDER BY last_seen_at DESC",
+		userID, time.Now().UTC().Format("2006-01-02T15:04:05.000Z"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logins := []LoginSession{}
+	for rows.Next() {
+		login, err := scanLoginSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		logins = append(logins, login)
+	}
+	return logins, rows.Err()
+}
+
+// Note that a login session is in use, at most once a minute
+func TouchLoginSession(id int, ip string) error {
+	now := time.Now().UTC()
+	_, err := db.Exec("UPDATE login_sessions SET last_seen_at = ?, ip = ? WHERE id = ? AND last_seen_at < ?",
+		now.Format("2006-01-02T15:04:05.000Z"), ip, id, now.Add(-time.Minute).Format("2006-01-02T15:04:05.000Z"))
+	return err
+}
+
+// Swap a refresh token for a new one, extending the login session. A token
+// replaced less than grace ago still identifies its session but isn't
+// replaced again, so parallel requests of one device don't log it out. The
+// new token is empty in that case. Reusing an older token revokes the session.
+func RotateRefreshToken(token string, lifetime time.Duration, grace time.Duration) (LoginSession, string, error) {
+	hash := hashRefreshToken(token)
+	now := time.Now().UTC()
+	nowStr := now.Format("2006-01-02T15:04:05.000Z")
+
+	if login, err := checkReplacedRefreshToken(hash, now, grace); !errors.Is(err, sql.ErrNoRows) {
+		return login, "", err
+	}
+
+	newToken, newHash, err := newRefreshToken()
+	if err != nil {
+		return LoginSession{}, "", err
+	}
+	result, err := db.Exec(`UPDATE login_sessions
+		SET refresh_hash = ?, previous_hash = refresh_hash, rotated_at = ?, last_seen_at = ?, expires_at = ?
+		WHERE refresh_hash = ? AND expires_at > ?`,
+		newHash, nowStr, nowStr, now.Add(lifetime).Format("2006-01-02T15:04:05.000Z"), hash, nowStr)
+	if err != nil {
+		return LoginSession{}, "", err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		// A parallel request may have rotated the token since the check
+		login, err := checkReplacedRefreshToken(hash, now, grace)
+		return login, "", err
+	}
+
+	login, err := scanLoginSession(db.QueryRow("SELECT "+loginSessionColumns+" FROM login_sessions WHERE refresh_hash = ?", newHash))
+	return login, newToken, err
+}
+
+// Look up a refresh token that has already been replaced. Returns its session
+// within the grace period, revokes the session after it and returns
+// sql.ErrNoRows if the token isn't a replaced one.
+func checkReplacedRefreshToken(hash string, now time.Time, grace time.Duration) (LoginSession, error) {
+	var id int
+	var rotatedAt sql.NullString
+	err := db.QueryRow("SELECT id, rotated_at FROM login_sessions WHERE previous_hash = ? AND expires_at > ?",
+		hash, now.Format("2006-01-02T15:04:05.000Z")).Scan(&id, &rotatedAt)
+	if err != nil {
+		return LoginSession{}, err
+	}
+
+	if rotated, parseErr := time.Parse(time.RFC3339, rotatedAt.String); parseErr == nil && now.Sub(rotated) < grace {
+		return GetLoginSession(id)
+	}
+	if err := DeleteLoginSession(id); err != nil {
+		return LoginSession{}, err
+	}
+	return LoginSession{}, ErrRefreshTokenReused
+}
+
+// Get the login session a refresh token belongs to, without rotating it
+func GetLoginSessionByRefreshToken(token string) (LoginSession, error) {
+	hash := hashRefreshToken(token)
+	row := db.QueryRow("SELECT "+loginSessionColumns+" FROM login_sessions WHERE (refresh_hash = ? OR previous_hash = ?) AND expires_at > ?",
+		hash, hash, time.Now().UTC().Format("2006-01-02T15:04:05.000Z"))
+	return scanLoginSession(row)
+}
+
+// Revoke a login session
+func DeleteLoginSession(id int) error {
+	_, err := db.Exec("DELETE FROM login_sessions WHERE id = ?", id)
+	return err
+}
+
+// Revoke every login session of a user except keepID, 0 revokes them all
+func DeleteLoginSessionsForUser(userID int, keepID int) (int64, error) {
+	result, err := db.Exec("DELETE FROM login_sessions WHERE user_id = ? AND id != ?", userID, keepID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"context"
+	"database/sql"
 	"log"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -46,6 +48,120 @@ func migrateSchema() {
 			migration:   "ALTER TABLE users ADD COLUMN account_status TEXT DEFAULT 'active'",
 			description: "Add account_status column to users table",
 		},
+		{
+			table:       "sessions",
+			check:       "SELECT COUNT(*) FROM pragma_table_info('sessions') WHERE name='profile_id'",
+			migration:   "ALTER TABLE sessions ADD COLUMN profile_id INTEGER DEFAULT NULL REFERENCES timer_profiles(id) ON DELETE SET NULL",
+			description: "Add profile_id column to sessions table",
+		},
+		{
+			table:       "sessions",
+			check:       "SELECT COUNT(*) FROM pragma_table_info('sessions') WHERE name='mode'",
+			migration:   "ALTER TABLE sessions ADD COLUMN mode TEXT DEFAULT 'classic'",
+			description: "Add mode column to sessions table",
+		},
+		{
+			table:       "timer_profiles",
+			check:       "SELECT COUNT(*) FROM pragma_table_info('timer_profiles') WHERE name='flow_break_ratio'",
+			migration:   "ALTER TABLE timer_profiles ADD COLUMN flow_break_ratio REAL DEFAULT 0.2",
+			description: "Add flow_break_ratio column to timer_profiles table",
+		},
+		{
+			table:       "sessions",
+			check:       "SELECT COUNT(*) FROM pragma_index_list('sessions') WHERE name='idx_session_project_id'",
+			migration:   "CREATE INDEX idx_session_project_id ON sessions(project_id)",
+			description: "Add project_id index to sessions table",
+		},
+		{
+			table:       "tags",
+			check:       "SELECT COUNT(*) FROM pragma_table_info('tags') WHERE name='parent_id'",
+			migration:   "ALTER TABLE tags ADD COLUMN parent_id INTEGER DEFAULT NULL REFERENCES tags(id) ON DELETE SET NULL",
+			description: "Add parent_id column to tags table",
+		},
+		{
+			table:       "sessions",
+			check:       "SELECT COUNT(*) FROM pragma_table_info('sessions') WHERE name='version'",
+			migration:   "ALTER TABLE sessions ADD COLUMN version INTEGER DEFAULT 1",
+			description: "Add version column to sessions table",
+		},
+		{
+			table:       "pomodoros",
+			check:       "SELECT COUNT(*) FROM pragma_table_info('pomodoros') WHERE name='version'",
+			migration:   "ALTER TABLE pomodoros ADD COLUMN version INTEGER DEFAULT 1",
+			description: "Add version column to pomodoros table",
+		},
+		{
+			table:       "breaks",
+			check:       "SELECT COUNT(*) FROM pragma_table_info('breaks') WHERE name='version'",
+			migration:   "ALTER TABLE breaks ADD COLUMN version INTEGER DEFAULT 1",
+			description: "Add version column to breaks table",
+		},
+		{
+			table:       "notes",
+			check:       "SELECT COUNT(*) FROM pragma_table_info('notes') WHERE name='version'",
+			migration:   "ALTER TABLE notes ADD COLUMN version INTEGER DEFAULT 1",
+			description: "Add version column to notes table",
+		},
+		{
+			table:       "pomodoros",
+			check:       "SELECT COUNT(*) FROM pragma_table_info('pomodoros') WHERE name='task_id'",
+			migration:   "ALTER TABLE pomodoros ADD COLUMN task_id INTEGER DEFAULT NULL REFERENCES tasks(id) ON DELETE SET NULL",
+			description: "Add task_id column to pomodoros table",
+		},
+		{
+			table:       "pomodoros",
+			check:       "SELECT COUNT(*) FROM pragma_index_list('pomodoros') WHERE name='idx_pomodoros_task_id'",
+			migration:   "CREATE INDEX idx_pomodoros_task_id ON pomodoros(task_id)",
+			description: "Add task_id index to pomodoros table",
+		},
+		{
+			table:       "pomodoros",
+			check:       "SELECT COUNT(*) FROM pragma_table_info('pomodoros') WHERE name='focus_rating'",
+			migration:   "ALTER TABLE pomodoros ADD COLUMN focus_rating INTEGER DEFAULT NULL",
+			description: "Add focus_rating column to pomodoros table",
+		},
+		{
+			table:       "pomodoros",
+			check:       "SELECT COUNT(*) FROM pragma_table_info('pomodoros') WHERE name='energy_level'",
+			migration:   "ALTER TABLE pomodoros ADD COLUMN energy_level INTEGER DEFAULT NULL",
+			description: "Add energy_level column to pomodoros table",
+		},
+		{
+			table:       "pomodoros",
+			check:       "SELECT COUNT(*) FROM pragma_table_info('pomodoros') WHERE name='reflection'",
+			migration:   "ALTER TABLE pomodoros ADD COLUMN reflection TEXT DEFAULT NULL",
+			description: "Add reflection column to pomodoros table",
+		},
+		{
+			table:       "sessions",
+			check:       "SELECT COUNT(*) FROM pragma_table_info('sessions') WHERE name='legacy_tags_copied'",
+			migration:   "ALTER TABLE sessions ADD COLUMN legacy_tags_copied BOOLEAN DEFAULT 0",
+			description: "Add legacy_tags_copied column to sessions table",
+		},
+		{
+			table:       "planned_sessions",
+			check:       "SELECT COUNT(*) FROM pragma_table_info('planned_sessions') WHERE name='exdates'",
+			migration:   "ALTER TABLE planned_sessions ADD COLUMN exdates TEXT DEFAULT NULL",
+			description: "Add exdates column to planned_sessions table",
+		},
+		{
+			table:       "tasks",
+			check:       "SELECT COUNT(*) FROM pragma_table_info('tasks') WHERE name='legacy_tags_copied'",
+			migration:   "ALTER TABLE tasks ADD COLUMN legacy_tags_copied BOOLEAN DEFAULT 0",
+			description: "Add legacy_tags_copied column to tasks table",
+		},
+		{
+			table:       "planned_sessions",
+			check:       "SELECT COUNT(*) FROM pragma_table_info('planned_sessions') WHERE name='legacy_tags_copied'",
+			migration:   "ALTER TABLE planned_sessions ADD COLUMN legacy_tags_copied BOOLEAN DEFAULT 0",
+			description: "Add legacy_tags_copied column to planned_sessions table",
+		},
+		{
+			table:       "note_revisions",
+			check:       "SELECT COUNT(*) FROM pragma_table_info('note_revisions') WHERE name='user_id'",
+			migration:   "ALTER TABLE note_revisions ADD COLUMN user_id INTEGER DEFAULT NULL",
+			description: "Add user_id column to note_revisions table",
+		},
 	}
 
 	// Run each migration if needed
@@ -70,4 +186,299 @@ func migrateSchema() {
 			}
 		}
 	}
+
+	// Data migrations that need more than a single statement
+	migrateSessionTags()
+	migrateTagOwnership()
+	migrateTagLinks("tasks", "task_tags", "task_id")
+	migrateTagLinks("planned_sessions", "planned_session_tags", "plan_id")
+	migrateNoteRevisions()
+
+	// Full-text index over notes, needs FTS5
+	setupNotesSearch()
+}
+
+// Copy the legacy comma-separated sessions.tags strings into session_tags.
+// Every tag write keeps the column in step with session_tags so that an
+// older binary still finds the tags after a rollback. Each session is only
+// copied once, sessions created by an older binary are picked up on the next
+// start.
+func migrateSessionTags() {
+	rows, err := db.Query("SELECT id, tags, user_id FROM sessions WHERE tags IS NOT NULL AND tags != '' AND COALESCE(legacy_tags_copied, 0) = 0")
+	if err != nil {
+		log.Printf("Error checking migration for sessions (Copy tags to session_tags): %v\n", err)
+		return
+	}
+
+	type legacySession struct {
+		tags    string
+		ownerID sql.NullInt64
+	}
+	legacyTags := make(map[int64]legacySession)
+	for rows.Next() {
+		var sessionID int64
+		var session legacySession
+		if err := rows.Scan(&sessionID, &session.tags, &session.ownerID); err != nil {
+			continue
+		}
+		legacyTags[sessionID] = session
+	}
+	rows.Close()
+
+	if len(legacyTags) == 0 {
+		return
+	}
+
+	// Before tags have owners they're linked by name only
+	var ownedTags int
+	if err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('tags') WHERE name='user_id'").Scan(&ownedTags); err != nil {
+		log.Printf("Error checking migration for sessions (Copy tags to session_tags): %v\n", err)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error applying migration to sessions (Copy tags to session_tags): %v\n", err)
+		return
+	}
+
+	for sessionID, session := range legacyTags {
+		err := linkLegacySessionTags(tx, sessionID, session.tags, session.ownerID, ownedTags > 0)
+		if err == nil {
+			_, err = tx.Exec("UPDATE sessions SET legacy_tags_copied = 1 WHERE id = ?", sessionID)
+		}
+		if err != nil {
+			tx.Rollback()
+			log.Printf("Error applying migration to sessions (Copy tags to session_tags): %v\n", err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error applying migration to sessions (Copy tags to session_tags): %v\n", err)
+		return
+	}
+
+	log.Printf("Migration applied: Copy tags of %d sessions to session_tags\n", len(legacyTags))
+}
+
+func linkLegacySessionTags(tx *sql.Tx, sessionID int64, tagString string, ownerID sql.NullInt64, ownedTags bool) error {
+	for _, name := range parseTagNames(tagString) {
+		var tagID int64
+		var err error
+		if ownedTags {
+			tagID, err = ensureTag(tx, ownerID, name)
+		} else {
+			err = tx.QueryRow("SELECT id FROM tags WHERE name = ?", name).Scan(&tagID)
+		}
+		if err == sql.ErrNoRows {
+			var count int
+			if err := tx.QueryRow("SELECT COUNT(*) FROM tags").Scan(&count); err != nil {
+				return err
+			}
+
+			var result sql.Result
+			result, err = tx.Exec("INSERT INTO tags(name, color, usage_count) VALUES (?, ?, ?)", name, colorForIndex(count), 0)
+			if err == nil {
+				tagID, err = result.LastInsertId()
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("INSERT OR IGNORE INTO session_tags(session_id, tag_id) VALUES (?, ?)", sessionID, tagID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy the comma-separated tags column of tasks or planned sessions into
+// their link table, once per row like migrateSessionTags. The column is left
+// alone for older binaries.
+func migrateTagLinks(table string, linkTable string, column string) {
+	description := "Copy tags of " + table + " to " + linkTable
+
+	rows, err := db.Query("SELECT id, user_id, tags FROM " + table + " WHERE tags IS NOT NULL AND tags != '' AND COALESCE(legacy_tags_copied, 0) = 0")
+	if err != nil {
+		log.Printf("Error checking migration for %s (%s): %v\n", table, description, err)
+		return
+	}
+
+	type legacyRow struct {
+		id     int64
+		userID int
+		tags   string
+	}
+	var legacy []legacyRow
+	for rows.Next() {
+		var row legacyRow
+		if err := rows.Scan(&row.id, &row.userID, &row.tags); err != nil {
+			continue
+		}
+		legacy = append(legacy, row)
+	}
+	rows.Close()
+
+	if len(legacy) == 0 {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error applying migration to %s (%s): %v\n", table, description, err)
+		return
+	}
+
+	for _, row := range legacy {
+		err := setTagLinks(tx, linkTable, column, row.id, row.userID, row.tags)
+		if err == nil {
+			_, err = tx.Exec("UPDATE "+table+" SET legacy_tags_copied = 1 WHERE id = ?", row.id)
+		}
+		if err != nil {
+			tx.Rollback()
+			log.Printf("Error applying migration to %s (%s): %v\n", table, description, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error applying migration to %s (%s): %v\n", table, description, err)
+		return
+	}
+
+	log.Printf("Migration applied: %s for %d rows\n", description, len(legacy))
+}
+
+// Give the tags table an owner column. SQLite can't drop the old UNIQUE(name)
+// constraint, so the table is rebuilt with foreign keys off on a dedicated
+// connection, then every tag is handed to the users whose sessions carry it.
+// Tags nobody used stay global.
+func migrateTagOwnership() {
+	const description = "Add user_id column to tags table"
+
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('tags') WHERE name='user_id'").Scan(&count)
+	if err != nil {
+		log.Printf("Error checking migration for tags (%s): %v\n", description, err)
+		return
+	}
+
+	if count == 0 {
+		if err := rebuildTagsTable(); err != nil {
+			log.Printf("Error applying migration to tags (%s): %v\n", description, err)
+			return
+		}
+		log.Printf("Migration applied: %s\n", description)
+	}
+
+	// Created here rather than with the other indexes since the rebuild drops them
+	indexQueries := map[string]string{
+		// Tag names are unique per owner, with NULL standing for global tags
+		"idx_tags_owner_name": "CREATE UNIQUE INDEX IF NOT EXISTS idx_tags_owner_name ON tags(COALESCE(user_id, 0), name)",
+		"idx_tags_parent_id":  "CREATE INDEX IF NOT EXISTS idx_tags_parent_id ON tags(parent_id)",
+	}
+	for indexName, query := range indexQueries {
+		if _, err := db.Exec(query); err != nil {
+			log.Printf("Error creating %s index: %v\n", indexName, err)
+		}
+	}
+}
+
+func rebuildTagsTable() error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Dropping the old table must not cascade into session_tags
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`CREATE TABLE tags_new (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            name TEXT NOT NULL,
+            color TEXT,
+            usage_count INTEGER DEFAULT 0,
+            user_id INTEGER DEFAULT NULL REFERENCES users(id) ON DELETE CASCADE,
+            parent_id INTEGER DEFAULT NULL REFERENCES tags(id) ON DELETE SET NULL
+        )`,
+		"INSERT INTO tags_new(id, name, color, usage_count) SELECT id, name, color, usage_count FROM tags",
+		"DROP TABLE tags",
+		"ALTER TABLE tags_new RENAME TO tags",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	// Collect which users use which tag
+	rows, err := tx.Query(`
+		SELECT DISTINCT st.tag_id, s.user_id
+		FROM session_tags st
+		JOIN sessions s ON s.id = st.session_id
+		WHERE s.user_id IS NOT NULL
+		ORDER BY st.tag_id, s.user_id
+	`)
+	if err != nil {
+		return err
+	}
+
+	usersByTag := make(map[int64][]int64)
+	var tagOrder []int64
+	for rows.Next() {
+		var tagID, userID int64
+		if err := rows.Scan(&tagID, &userID); err != nil {
+			rows.Close()
+			return err
+		}
+		if _, ok := usersByTag[tagID]; !ok {
+			tagOrder = append(tagOrder, tagID)
+		}
+		usersByTag[tagID] = append(usersByTag[tagID], userID)
+	}
+	rows.Close()
+
+	for _, tagID := range tagOrder {
+		users := usersByTag[tagID]
+
+		// The first user keeps the original row
+		if _, err := tx.Exec("UPDATE tags SET user_id = ? WHERE id = ?", users[0], tagID); err != nil {
+			return err
+		}
+
+		// Everyone else gets a copy and their sessions are pointed at it
+		for _, userID := range users[1:] {
+			result, err := tx.Exec("INSERT INTO tags(name, color, usage_count, user_id) SELECT name, color, 0, ? FROM tags WHERE id = ?", userID, tagID)
+			if err != nil {
+				return err
+			}
+			copyID, err := result.LastInsertId()
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec("UPDATE session_tags SET tag_id = ? WHERE tag_id = ? AND session_id IN (SELECT id FROM sessions WHERE user_id = ?)",
+				copyID, tagID, userID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
 }
@@ -11,7 +11,7 @@ import (
 	"strings"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -26,17 +26,38 @@ type Session struct {
 	TotalTime int     `json:"total_time"`
 	Status    string  `json:"status"`
 	Completed int     `json:"completed_pomodoros"`
-	Tags      string  `json:"tags"` // Comma-separated tag list
+	Tags      string  `json:"tags"`       // Comma-separated tag list, derived from session_tags
+	ProfileID *int    `json:"profile_id"` // Timer profile the session was run under
+	Mode      string  `json:"mode"`       // "classic" or "flowtime"
+	ProjectID *int    `json:"project_id"`
+	Version   int     `json:"version"` // Bumped on every update, sent as the ETag
 }
 
+// Session modes
+const (
+	SessionModeClassic  = "classic"  // Fixed length pomodoros
+	SessionModeFlowtime = "flowtime" // Count-up pomodoros, breaks sized from focus time
+)
+
 type Pomodoro struct {
 	ID        int    `json:"id"`
 	SessionID int    `json:"session_id"`
-	Number    int    `json:"number"` // 1-4 for the 4 pomodoros in a session
+	Number    int    `json:"number"` // Position of the pomodoro within its session
 	StartTime string `json:"start_time"`
 	EndTime   string `json:"end_time"`
 	Duration  int    `json:"duration"` // In seconds
 	Status    string `json:"status"`   // "completed", "stopped", "running"
+	TaskID    *int   `json:"task_id"`  // Task the pomodoro was spent on
+	Version   int    `json:"version"`
+
+	// How the pomodoro went, rated by the user when it ends
+	FocusRating *int    `json:"focus_rating"` // 1 (distracted) to 5 (deep focus)
+	EnergyLevel *int    `json:"energy_level"` // 1 (drained) to 5 (energetic)
+	Reflection  *string `json:"reflection"`
+
+	// Interruptions logged during the pomodoro, the ' and - marks of the technique
+	InternalInterruptions int `json:"internal_interruptions"`
+	ExternalInterruptions int `json:"external_interruptions"`
 }
 
 type Break struct {
@@ -48,6 +69,7 @@ type Break struct {
 	EndTime    string `json:"end_time"`
 	Duration   int    `json:"duration"` // In seconds
 	Status     string `json:"status"`   // "completed", "stopped", "running"
+	Version    int    `json:"version"`
 }
 
 type Note struct {
@@ -56,13 +78,38 @@ type Note struct {
 	PomodoroID int    `json:"pomodoro_id"` // Which pomodoro it's associated with (can be null)
 	NoteText   string `json:"note"`
 	CreatedAt  string `json:"created_at"`
+	Version    int    `json:"version"`
+}
+
+// Returned by updates made against a version that is no longer current
+var ErrVersionConflict = errors.New("the record was changed by someone else")
+
+// Helper function to tell why a versioned update touched no rows
+func versionedUpdateError(result sql.Result, q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, table string, id int) error {
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		return nil
+	}
+
+	var exists int
+	err := q.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE id = ?", id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists == 0 {
+		return sql.ErrNoRows
+	}
+	return ErrVersionConflict
 }
 
 type Tag struct {
 	ID         int    `json:"id"`
 	Name       string `json:"name"`
 	Color      string `json:"color"`
-	UsageCount int    `json:"usage_count"`
+	UsageCount int    `json:"usage_count"` // Sessions of the requesting user carrying the tag
+	UserID     *int   `json:"user_id"`     // Owner of the tag, nil for a shared global tag
+	ParentID   *int   `json:"parent_id"`   // Parent in the tag hierarchy, nil for a top level tag
 }
 
 // User struct
@@ -161,13 +208,39 @@ func createInitialSchema() {
                 FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE,
                 FOREIGN KEY (pomodoro_id) REFERENCES pomodoros(id) ON DELETE CASCADE
             )
+        `,
+		"interruptions": `
+            CREATE TABLE IF NOT EXISTS interruptions (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                session_id INTEGER NOT NULL,
+                pomodoro_id INTEGER NOT NULL,
+                type TEXT NOT NULL,
+                reason TEXT,
+                created_at TEXT NOT NULL,
+                FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE,
+                FOREIGN KEY (pomodoro_id) REFERENCES pomodoros(id) ON DELETE CASCADE
+            )
+        `,
+		"note_revisions": `
+            CREATE TABLE IF NOT EXISTS note_revisions (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                note_id INTEGER NOT NULL,
+                session_id INTEGER,
+                pomodoro_id INTEGER,
+                note TEXT,
+                action TEXT NOT NULL,
+                created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+                user_id INTEGER
+            )
         `,
 		"tags": `
             CREATE TABLE IF NOT EXISTS tags (
                 id INTEGER PRIMARY KEY AUTOINCREMENT,
-                name TEXT UNIQUE,
+                name TEXT NOT NULL,
                 color TEXT,
-                usage_count INTEGER DEFAULT 0
+                usage_count INTEGER DEFAULT 0,
+                user_id INTEGER DEFAULT NULL REFERENCES users(id) ON DELETE CASCADE,
+                parent_id INTEGER DEFAULT NULL REFERENCES tags(id) ON DELETE SET NULL
             )
         `,
 		"users": `
@@ -181,6 +254,139 @@ func createInitialSchema() {
                 last_login TEXT,
                 account_status TEXT DEFAULT 'active'
             )
+        `,
+		"timer_profiles": `
+            CREATE TABLE IF NOT EXISTS timer_profiles (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                user_id INTEGER NOT NULL,
+                name TEXT NOT NULL,
+                work_length INTEGER NOT NULL,
+                short_break_length INTEGER NOT NULL,
+                long_break_length INTEGER NOT NULL,
+                long_break_every INTEGER NOT NULL,
+                auto_start_breaks BOOLEAN DEFAULT 0,
+                auto_start_pomodoros BOOLEAN DEFAULT 0,
+                is_default BOOLEAN DEFAULT 0,
+                flow_break_ratio REAL DEFAULT 0.2,
+                created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+                FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+            )
+        `,
+		"projects": `
+            CREATE TABLE IF NOT EXISTS projects (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                user_id INTEGER NOT NULL,
+                name TEXT NOT NULL,
+                color TEXT,
+                archived BOOLEAN DEFAULT 0,
+                client TEXT,
+                description TEXT,
+                created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+                FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                UNIQUE(user_id, name)
+            )
+        `,
+		"tasks": `
+            CREATE TABLE IF NOT EXISTS tasks (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                user_id INTEGER NOT NULL,
+                title TEXT NOT NULL,
+                estimate INTEGER NOT NULL DEFAULT 1,
+                status TEXT DEFAULT 'todo',
+                due_date TEXT,
+                tags TEXT,
+                project_id INTEGER,
+                created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+                completed_at TEXT,
+                FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE SET NULL
+            )
+        `,
+		"planned_sessions": `
+            CREATE TABLE IF NOT EXISTS planned_sessions (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                user_id INTEGER NOT NULL,
+                title TEXT,
+                start_time TEXT NOT NULL,
+                duration INTEGER NOT NULL,
+                expected_pomodoros INTEGER NOT NULL DEFAULT 1,
+                tags TEXT,
+                project_id INTEGER,
+                recurrence TEXT,
+                timezone TEXT DEFAULT 'UTC',
+                ical_uid TEXT,
+                created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+                FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE SET NULL
+            )
+        `,
+		"calendar_feeds": `
+            CREATE TABLE IF NOT EXISTS calendar_feeds (
+                user_id INTEGER PRIMARY KEY,
+                token TEXT UNIQUE NOT NULL,
+                created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+                FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+            )
+        `,
+		"access_tokens": `
+            CREATE TABLE IF NOT EXISTS access_tokens (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                user_id INTEGER NOT NULL,
+                name TEXT NOT NULL,
+                token_hash TEXT UNIQUE NOT NULL,
+                prefix TEXT NOT NULL,
+                scopes TEXT NOT NULL,
+                expires_at TEXT DEFAULT NULL,
+                last_used_at TEXT DEFAULT NULL,
+                created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+                FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+            )
+        `,
+		"login_sessions": `
+            CREATE TABLE IF NOT EXISTS login_sessions (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                user_id INTEGER NOT NULL,
+                refresh_hash TEXT UNIQUE NOT NULL,
+                previous_hash TEXT DEFAULT NULL,
+                rotated_at TEXT DEFAULT NULL,
+                device TEXT,
+                ip TEXT,
+                user_agent TEXT,
+                created_at TEXT NOT NULL,
+                last_seen_at TEXT NOT NULL,
+                expires_at TEXT NOT NULL,
+                FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+            )
+        `,
+		"task_tags": `
+            CREATE TABLE IF NOT EXISTS task_tags (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                task_id INTEGER NOT NULL,
+                tag_id INTEGER NOT NULL,
+                FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE,
+                FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE,
+                UNIQUE(task_id, tag_id)
+            )
+        `,
+		"planned_session_tags": `
+            CREATE TABLE IF NOT EXISTS planned_session_tags (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                plan_id INTEGER NOT NULL,
+                tag_id INTEGER NOT NULL,
+                FOREIGN KEY (plan_id) REFERENCES planned_sessions(id) ON DELETE CASCADE,
+                FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE,
+                UNIQUE(plan_id, tag_id)
+            )
+        `,
+		"timer_states": `
+            CREATE TABLE IF NOT EXISTS timer_states (
+                user_id INTEGER PRIMARY KEY,
+                session_id INTEGER NOT NULL,
+                state TEXT NOT NULL,
+                updated_at TEXT NOT NULL,
+                FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE
+            )
         `,
 		"session_tags": `
             CREATE TABLE IF NOT EXISTS session_tags (
@@ -206,11 +412,25 @@ func createInitialSchema() {
 
 	// Create indexes for better performance
 	indexQueries := map[string]string{
-		"idx_session_start_time":      "CREATE INDEX IF NOT EXISTS idx_session_start_time ON sessions(start_time)",
-		"idx_session_user_id":         "CREATE INDEX IF NOT EXISTS idx_session_user_id ON sessions(user_id)",
-		"idx_session_tags_session_id": "CREATE INDEX IF NOT EXISTS idx_session_tags_session_id ON session_tags(session_id)",
-		"idx_session_tags_tag_id":     "CREATE INDEX IF NOT EXISTS idx_session_tags_tag_id ON session_tags(tag_id)",
-		"idx_users_username":          "CREATE INDEX IF NOT EXISTS idx_users_username ON users(username)",
+		"idx_session_start_time":        "CREATE INDEX IF NOT EXISTS idx_session_start_time ON sessions(start_time)",
+		"idx_session_user_id":           "CREATE INDEX IF NOT EXISTS idx_session_user_id ON sessions(user_id)",
+		"idx_session_tags_session_id":   "CREATE INDEX IF NOT EXISTS idx_session_tags_session_id ON session_tags(session_id)",
+		"idx_pomodoros_session_id":      "CREATE INDEX IF NOT EXISTS idx_pomodoros_session_id ON pomodoros(session_id)",
+		"idx_breaks_session_id":         "CREATE INDEX IF NOT EXISTS idx_breaks_session_id ON breaks(session_id)",
+		"idx_session_tags_tag_id":       "CREATE INDEX IF NOT EXISTS idx_session_tags_tag_id ON session_tags(tag_id)",
+		"idx_task_tags_tag_id":          "CREATE INDEX IF NOT EXISTS idx_task_tags_tag_id ON task_tags(tag_id)",
+		"idx_planned_session_tags_tag":  "CREATE INDEX IF NOT EXISTS idx_planned_session_tags_tag ON planned_session_tags(tag_id)",
+		"idx_users_username":            "CREATE INDEX IF NOT EXISTS idx_users_username ON users(username)",
+		"idx_timer_profiles_user_id":    "CREATE INDEX IF NOT EXISTS idx_timer_profiles_user_id ON timer_profiles(user_id)",
+		"idx_projects_user_id":          "CREATE INDEX IF NOT EXISTS idx_projects_user_id ON projects(user_id)",
+		"idx_note_revisions_note_id":    "CREATE INDEX IF NOT EXISTS idx_note_revisions_note_id ON note_revisions(note_id)",
+		"idx_interruptions_pomodoro_id": "CREATE INDEX IF NOT EXISTS idx_interruptions_pomodoro_id ON interruptions(pomodoro_id)",
+		"idx_interruptions_session_id":  "CREATE INDEX IF NOT EXISTS idx_interruptions_session_id ON interruptions(session_id)",
+		"idx_tasks_user_id":             "CREATE INDEX IF NOT EXISTS idx_tasks_user_id ON tasks(user_id)",
+		"idx_planned_sessions_user_id":  "CREATE INDEX IF NOT EXISTS idx_planned_sessions_user_id ON planned_sessions(user_id)",
+		"idx_access_tokens_user_id":     "CREATE INDEX IF NOT EXISTS idx_access_tokens_user_id ON access_tokens(user_id)",
+		"idx_login_sessions_user_id":    "CREATE INDEX IF NOT EXISTS idx_login_sessions_user_id ON login_sessions(user_id)",
+		"idx_login_sessions_previous":   "CREATE INDEX IF NOT EXISTS idx_login_sessions_previous ON login_sessions(previous_hash)",
 	}
 
 	// Execute each index creation query
@@ -226,7 +446,7 @@ func createInitialSchema() {
 
 // Add table statistics function for database health checks
 func GetDatabaseStats() map[string]int {
-	tables := []string{"sessions", "pomodoros", "breaks", "notes", "tags"}
+	tables := []string{"sessions", "pomodoros", "breaks", "notes", "tags", "projects"}
 	stats := make(map[string]int)
 
 	for _, table := range tables {
@@ -241,6 +461,13 @@ func GetDatabaseStats() map[string]int {
 		}
 	}
 
+	// Sessions an admin still has to assign or delete
+	var orphaned int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sessions WHERE user_id IS NULL").Scan(&orphaned); err != nil {
+		orphaned = -1
+	}
+	stats["orphaned_sessions"] = orphaned
+
 	return stats
 }
 
@@ -265,26 +492,65 @@ func CheckDatabaseIntegrity() (bool, error) {
 
 // Session CRUD functions
 
-func CreateSession(startTime string, tags string) (int64, error) {
-	statement, err := db.Prepare("INSERT INTO sessions(start_time, status, completed_pomodoros, tags) VALUES (?, ?, ?, ?)")
+// Comma-separated tag names of a session, derived from session_tags
+const sessionTagsColumn = `(SELECT GROUP_CONCAT(name, ',') FROM (
+		SELECT t.name FROM session_tags st JOIN tags t ON t.id = st.tag_id
+		WHERE st.session_id = sessions.id ORDER BY st.id))`
+
+// Columns selected for every session read, in the order scanSession expects
+const sessionColumns = "id, start_time, end_time, total_time, status, completed_pomodoros, " + sessionTagsColumn + ", profile_id, mode, project_id, COALESCE(version, 1)"
+
+// Condition matching sessions that carry the tag bound to the placeholder
+const sessionHasTag = "EXISTS (SELECT 1 FROM session_tags st JOIN tags t ON t.id = st.tag_id WHERE st.session_id = sessions.id AND t.name = ?)"
+
+// Condition matching sessions that carry the named tag or any of its
+// descendants, among the tags visible to a user. Binds the tag name followed
+// by the user ID three times.
+const sessionHasTagTree = `EXISTS (SELECT 1 FROM session_tags st WHERE st.session_id = sessions.id AND st.tag_id IN (
+		WITH RECURSIVE subtree(id) AS (
+			SELECT id FROM tags WHERE name = ? AND (user_id = ? OR user_id IS NULL)
+			UNION
+			SELECT t.id FROM tags t JOIN subtree ON t.parent_id = subtree.id
+			WHERE t.user_id = ? OR t.user_id IS NULL
+		)
+		SELECT id FROM subtree))`
+
+// Common interface of *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSession(row rowScanner) (Session, error) {
+	var session Session
+	var tagsNullable sql.NullString // Use NullString to handle NULL values
+	var profileID, projectID sql.NullInt64
+
+	err := row.Scan(&session.ID, &session.StartTime, &session.EndTime, &session.TotalTime, &session.Status, &session.Completed, &tagsNullable, &profileID, &session.Mode, &projectID, &session.Version)
 	if err != nil {
-		return 0, err
+		return session, err
 	}
-	result, err := statement.Exec(startTime, "running", 0, tags)
-	if err != nil {
-		return 0, err
+
+	// Set tags to empty string if NULL or the actual value if not NULL
+	if tagsNullable.Valid {
+		session.Tags = tagsNullable.String
+	} else {
+		session.Tags = ""
 	}
 
-	// Update tag usage counts
-	if tags != "" {
-		updateTagCounts(tags)
+	if profileID.Valid {
+		id := int(profileID.Int64)
+		session.ProfileID = &id
+	}
+	if projectID.Valid {
+		id := int(projectID.Int64)
+		session.ProjectID = &id
 	}
 
-	return result.LastInsertId()
+	return session, nil
 }
 
 func getSessions() ([]Session, error) {
-	rows, err := db.Query("SELECT id, start_time, end_time, total_time, status, completed_pomodoros, tags FROM sessions ORDER BY id DESC")
+	rows, err := db.Query("SELECT " + sessionColumns + " FROM sessions ORDER BY id DESC")
 	if err != nil {
 		return nil, err
 	}
@@ -292,73 +558,71 @@ func getSessions() ([]Session, error) {
 
 	var sessions []Session
 	for rows.Next() {
-		var session Session
-		var tagsNullable sql.NullString // Use NullString to handle NULL values
-
-		// Scan using the nullable type for tags
-		err := rows.Scan(&session.ID, &session.StartTime, &session.EndTime, &session.TotalTime, &session.Status, &session.Completed, &tagsNullable)
+		session, err := scanSession(rows)
 		if err != nil {
 			return nil, err
 		}
-
-		// Set tags to empty string if NULL or the actual value if not NULL
-		if tagsNullable.Valid {
-			session.Tags = tagsNullable.String
-		} else {
-			session.Tags = ""
-		}
-
 		sessions = append(sessions, session)
 	}
 	return sessions, nil
 }
 
 func GetSession(id int) (Session, error) {
-	row := db.QueryRow("SELECT id, start_time, end_time, total_time, status, completed_pomodoros, tags FROM sessions WHERE id = ?", id)
-	var session Session
-	var tagsNullable sql.NullString // Use NullString to handle NULL values
+	row := db.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE id = ?", id)
+	return scanSession(row)
+}
 
-	err := row.Scan(&session.ID, &session.StartTime, &session.EndTime, &session.TotalTime, &session.Status, &session.Completed, &tagsNullable)
+// Update a session. A non-zero ifVersion makes the update fail with
+// ErrVersionConflict unless the session is still at that version.
+func UpdateSession(id int, endTime *string, totalTime int, status string, completedPomodoros int, tags string, projectID *int, ifVersion int) error {
+	tx, err := db.Begin()
 	if err != nil {
-		return session, err
-	}
-
-	// Set tags to empty string if NULL or the actual value if not NULL
-	if tagsNullable.Valid {
-		session.Tags = tagsNullable.String
-	} else {
-		session.Tags = ""
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 
-	return session, nil
-}
-func UpdateSession(id int, endTime *string, totalTime int, status string, completedPomodoros int, tags string) error {
-	// If tags are updated, update the tag usage counts
-	if tags != "" {
-		// Get the current tags to see what changed
-		currentSession, err := GetSession(id)
-		if err == nil && currentSession.Tags != tags {
-			// Decrement old tags and increment new ones
-			if currentSession.Tags != "" {
-				decrementTagCounts(currentSession.Tags)
-			}
-			updateTagCounts(tags)
+	defer func() {
+		if err != nil {
+			tx.Rollback()
 		}
+	}()
+
+	// Dereference the pointer or use NULL if it's nil
+	var endTimeValue interface{} = nil
+	if endTime != nil {
+		endTimeValue = *endTime
 	}
 
-	statement, err := db.Prepare("UPDATE sessions SET end_time = ?, total_time = ?, status = ?, completed_pomodoros = ?, tags = ? WHERE id = ?")
+	result, err := tx.Exec(`UPDATE sessions SET end_time = ?, total_time = ?, status = ?, completed_pomodoros = ?, project_id = ?, version = COALESCE(version, 1) + 1
+		WHERE id = ? AND (? = 0 OR COALESCE(version, 1) = ?)`,
+		endTimeValue, totalTime, status, completedPomodoros, projectID, id, ifVersion, ifVersion)
 	if err != nil {
 		return err
 	}
+	if err = versionedUpdateError(result, tx, "sessions", id); err != nil {
+		return err
+	}
 
-	// Dereference the pointer or use NULL if it's nil
-	var endTimeValue interface{} = nil
-	if endTime != nil {
-		endTimeValue = *endTime
+	if err = setSessionTags(tx, int64(id), tags); err != nil {
+		return err
 	}
 
-	_, err = statement.Exec(endTimeValue, totalTime, status, completedPomodoros, tags, id)
-	return err
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Record the progress of a session without touching its tags, for the timer
+// engine which doesn't own them
+func UpdateSessionProgress(id int, endTime *string, totalTime int, status string, completedPomodoros int) error {
+	result, err := db.Exec(`UPDATE sessions SET end_time = ?, total_time = ?, status = ?, completed_pomodoros = ?, version = COALESCE(version, 1) + 1
+		WHERE id = ?`,
+		endTime, totalTime, status, completedPomodoros, id)
+	if err != nil {
+		return err
+	}
+	return versionedUpdateError(result, db, "sessions", id)
 }
 
 // Enhanced function to update session tags
@@ -375,30 +639,18 @@ func UpdateSessionTags(sessionID int, newTags string) error {
 		}
 	}()
 
-	// Get current tags
-	var currentTags string
-	err = tx.QueryRow("SELECT tags FROM sessions WHERE id = ?", sessionID).Scan(&currentTags)
+	// Make sure the session exists
+	var exists int
+	err = tx.QueryRow("SELECT COUNT(*) FROM sessions WHERE id = ?", sessionID).Scan(&exists)
+	if err == nil && exists == 0 {
+		err = sql.ErrNoRows
+	}
 	if err != nil {
-		return fmt.Errorf("failed to get current tags: %w", err)
+		return fmt.Errorf("failed to get session: %w", err)
 	}
 
-	// Only update if tags have changed
-	if currentTags != newTags {
-		// Decrement old tags
-		if currentTags != "" {
-			decrementTagCounts(currentTags)
-		}
-
-		// Increment new tags
-		if newTags != "" {
-			updateTagCounts(newTags)
-		}
-
-		// Update the session
-		_, err = tx.Exec("UPDATE sessions SET tags = ? WHERE id = ?", newTags, sessionID)
-		if err != nil {
-			return fmt.Errorf("failed to update session tags: %w", err)
-		}
+	if err = setSessionTags(tx, int64(sessionID), newTags); err != nil {
+		return err
 	}
 
 	// Commit transaction
@@ -411,8 +663,8 @@ func UpdateSessionTags(sessionID int, newTags string) error {
 
 // Delete session and all related data (improved with transaction support)
 func DeleteSession(id int) error {
-	// Get session to decrement tag counts before deletion
-	session, err := GetSession(id)
+	// Make sure the session exists before deleting anything
+	_, err := GetSession(id)
 	if err != nil {
 		// If we can't get the session, it might not exist
 		return fmt.Errorf("session not found: %w", err)
@@ -431,53 +683,110 @@ func DeleteSession(id int) error {
 		}
 	}()
 
-	// Decrement tag counts if applicable
-	if session.Tags != "" {
-		decrementTagCounts(session.Tags)
+	if err = deleteSessionTx(tx, id); err != nil {
+		return err
+	}
+
+	// Commit the transaction
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Delete a session and all related data within a transaction
+func deleteSessionTx(tx *sql.Tx, id int) error {
+	// Note history outlives the session, so its notes can still be read back
+	_, err := tx.Exec(`INSERT INTO note_revisions(note_id, session_id, pomodoro_id, note, action, user_id)
+		SELECT n.id, n.session_id, n.pomodoro_id, n.note, ?, s.user_id
+		FROM notes n JOIN sessions s ON s.id = n.session_id
+		WHERE n.session_id = ?`, NoteRevisionDelete, id)
+	if err != nil {
+		return fmt.Errorf("failed to record note revisions: %w", err)
 	}
 
-	// Delete all related data within the transaction
 	deleteQueries := []struct {
 		query       string
 		description string
 	}{
 		{"DELETE FROM breaks WHERE session_id = ?", "breaks"},
+		{"DELETE FROM interruptions WHERE session_id = ?", "interruptions"},
 		{"DELETE FROM pomodoros WHERE session_id = ?", "pomodoros"},
 		{"DELETE FROM notes WHERE session_id = ?", "notes"},
+		{"DELETE FROM session_tags WHERE session_id = ?", "session tags"},
+		{"DELETE FROM timer_states WHERE session_id = ?", "timer state"},
 		{"DELETE FROM sessions WHERE id = ?", "session"},
 	}
 
 	for _, dq := range deleteQueries {
-		_, err = tx.Exec(dq.query, id)
-		if err != nil {
+		if _, err := tx.Exec(dq.query, id); err != nil {
 			return fmt.Errorf("failed to delete %s: %w", dq.description, err)
 		}
 	}
 
-	// Commit the transaction
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
-	}
-
 	return nil
 }
 
 // Tag CRUD functions
 
-func CreateTag(name string, color string) (int64, error) {
-	statement, err := db.Prepare("INSERT INTO tags(name, color, usage_count) VALUES (?, ?, ?)")
+// Create a tag owned by the user, or a global tag if userID is nil. Returns
+// ErrTagExists if the owner already has a tag of that name.
+func CreateTag(name string, color string, userID *int) (int64, error) {
+	// Checked up front for a clear error, the unique index on
+	// COALESCE(user_id, 0) and name still catches concurrent inserts
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM tags WHERE name = ? AND COALESCE(user_id, 0) = COALESCE(?, 0)", name, userID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return 0, ErrTagExists
+	}
+
+	statement, err := db.Prepare("INSERT INTO tags(name, color, usage_count, user_id) VALUES (?, ?, ?, ?)")
 	if err != nil {
 		return 0, err
 	}
-	result, err := statement.Exec(name, color, 0)
+	result, err := statement.Exec(name, color, 0, userID)
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return 0, ErrTagExists
+	}
 	if err != nil {
 		return 0, err
 	}
 	return result.LastInsertId()
 }
 
-func GetTags() ([]Tag, error) {
-	rows, err := db.Query("SELECT id, name, color, usage_count FROM tags ORDER BY name")
+func GetTag(id int) (Tag, error) {
+	var tag Tag
+	var color sql.NullString
+	var userID, parentID sql.NullInt64
+	row := db.QueryRow("SELECT id, name, color, user_id, parent_id FROM tags WHERE id = ?", id)
+	err := row.Scan(&tag.ID, &tag.Name, &color, &userID, &parentID)
+	tag.Color = color.String
+	if userID.Valid {
+		owner := int(userID.Int64)
+		tag.UserID = &owner
+	}
+	if parentID.Valid {
+		parent := int(parentID.Int64)
+		tag.ParentID = &parent
+	}
+	return tag, err
+}
+
+// Get the user's own tags and the global tags, with the user's usage counts
+func GetTagsForUser(userID int) ([]Tag, error) {
+	rows, err := db.Query(`
+		SELECT t.id, t.name, t.color, t.user_id, t.parent_id,
+			(SELECT COUNT(*) FROM session_tags st JOIN sessions s ON s.id = st.session_id
+			 WHERE st.tag_id = t.id AND s.user_id = ?)
+		FROM tags t
+		WHERE t.user_id = ? OR t.user_id IS NULL
+		ORDER BY t.name
+	`, userID, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -486,160 +795,462 @@ func GetTags() ([]Tag, error) {
 	var tags []Tag
 	for rows.Next() {
 		var tag Tag
-		err := rows.Scan(&tag.ID, &tag.Name, &tag.Color, &tag.UsageCount)
+		var color sql.NullString
+		var ownerID, parentID sql.NullInt64
+		err := rows.Scan(&tag.ID, &tag.Name, &color, &ownerID, &parentID, &tag.UsageCount)
 		if err != nil {
 			return nil, err
 		}
+		tag.Color = color.String
+		if ownerID.Valid {
+			owner := int(ownerID.Int64)
+			tag.UserID = &owner
+		}
+		if parentID.Valid {
+			parent := int(parentID.Int64)
+			tag.ParentID = &parent
+		}
 		tags = append(tags, tag)
 	}
 	return tags, nil
 }
 
+var ErrTagExists = errors.New("a tag with this name already exists, merge the tags instead")
+
+// Rename and recolor a tag. Sessions reference tags by ID, so every session
+// carrying the tag picks up the new name.
 func UpdateTag(id int, name string, color string) error {
-	statement, err := db.Prepare("UPDATE tags SET name = ?, color = ? WHERE id = ?")
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM tags
+		WHERE name = ? AND id != ? AND COALESCE(user_id, 0) = (SELECT COALESCE(user_id, 0) FROM tags WHERE id = ?)`,
+		name, id, id).Scan(&count)
 	if err != nil {
 		return err
 	}
-	_, err = statement.Exec(name, color, id)
-	return err
-}
+	if count > 0 {
+		return ErrTagExists
+	}
 
-func DeleteTag(id int) error {
-	// Get tag name before deletion
-	row := db.QueryRow("SELECT name FROM tags WHERE id = ?", id)
-	var tagName string
-	err := row.Scan(&tagName)
+	tx, err := db.Begin()
 	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec("UPDATE tags SET name = ?, color = ? WHERE id = ?", name, color, id); err != nil {
+		return err
+	}
+	if err = syncLegacySessionTags(tx, "id IN (SELECT session_id FROM session_tags WHERE tag_id = ?)", id); err != nil {
 		return err
 	}
 
-	// Remove this tag from all sessions that have it
-	rows, err := db.Query("SELECT id, tags FROM sessions WHERE tags LIKE ?", "%"+tagName+"%")
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Fold the source tags into the target tag. Sessions carrying a source tag
+// get the target instead, child tags move under the target and the sources
+// are deleted, all in one transaction. Returns the number of sessions that
+// were retagged.
+func MergeTags(targetID int, sourceIDs []int) (int64, error) {
+	tx, err := db.Begin()
 	if err != nil {
-		return err
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var sessionId int
-		var sessionTags string
-		err := rows.Scan(&sessionId, &sessionTags)
+	defer func() {
 		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var retagged int64
+	for _, sourceID := range sourceIDs {
+		if sourceID == targetID {
 			continue
 		}
 
-		// Remove the tag from the comma-separated list
-		tagList := strings.Split(sessionTags, ",")
-		newTagList := []string{}
-		for _, tag := range tagList {
-			tag = strings.TrimSpace(tag)
-			if tag != tagName {
-				newTagList = append(newTagList, tag)
+		var sourceParent sql.NullInt64
+		err = tx.QueryRow("SELECT parent_id FROM tags WHERE id = ?", sourceID).Scan(&sourceParent)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get tag %d: %w", sourceID, err)
+		}
+
+		// Point the links at the target, keeping their position in the
+		// session's tag list, unless the session already has the target
+		var result sql.Result
+		result, err = tx.Exec(`UPDATE session_tags SET tag_id = ?
+			WHERE tag_id = ? AND session_id NOT IN (SELECT session_id FROM session_tags WHERE tag_id = ?)`,
+			targetID, sourceID, targetID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to retag sessions: %w", err)
+		}
+		affected, _ := result.RowsAffected()
+		retagged += affected
+
+		_, err = tx.Exec("DELETE FROM session_tags WHERE tag_id = ?", sourceID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to remove duplicate session tags: %w", err)
+		}
+
+		// Tasks and plans follow the same way
+		for _, link := range tagLinkTables {
+			_, err = tx.Exec("UPDATE "+link.table+" SET tag_id = ? WHERE tag_id = ? AND "+link.column+" NOT IN (SELECT "+link.column+" FROM "+link.table+" WHERE tag_id = ?)",
+				targetID, sourceID, targetID)
+			if err == nil {
+				_, err = tx.Exec("DELETE FROM "+link.table+" WHERE tag_id = ?", sourceID)
+			}
+			if err != nil {
+				return 0, fmt.Errorf("failed to retag %s: %w", link.table, err)
 			}
 		}
 
-		// Update the session with the new tag list
-		newTags := strings.Join(newTagList, ",")
-		db.Exec("UPDATE sessions SET tags = ? WHERE id = ?", newTags, sessionId)
+		// Children move under the target, or up a level if the target
+		// itself sits below the source so that no loop is created
+		var underSource bool
+		underSource, err = tagIsDescendant(tx, targetID, sourceID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to check tag hierarchy: %w", err)
+		}
+		if underSource {
+			_, err = tx.Exec("UPDATE tags SET parent_id = ? WHERE parent_id = ?", sourceParent, sourceID)
+		} else {
+			_, err = tx.Exec("UPDATE tags SET parent_id = ? WHERE parent_id = ?", targetID, sourceID)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to move child tags: %w", err)
+		}
+
+		_, err = tx.Exec("DELETE FROM tags WHERE id = ?", sourceID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete tag %d: %w", sourceID, err)
+		}
 	}
 
-	// Delete the tag
-	_, err = db.Exec("DELETE FROM tags WHERE id = ?", id)
+	err = syncLegacySessionTags(tx, "id IN (SELECT session_id FROM session_tags WHERE tag_id = ?)", targetID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return retagged, nil
+}
+
+// Helper function to check whether a tag sits anywhere below another tag
+func tagIsDescendant(tx *sql.Tx, id int, ancestorID int) (bool, error) {
+	seen := map[int]bool{}
+	current := id
+	for !seen[current] {
+		seen[current] = true
+		var parentID sql.NullInt64
+		err := tx.QueryRow("SELECT parent_id FROM tags WHERE id = ?", current).Scan(&parentID)
+		if err != nil {
+			return false, err
+		}
+		if !parentID.Valid {
+			return false, nil
+		}
+		if int(parentID.Int64) == ancestorID {
+			return true, nil
+		}
+		current = int(parentID.Int64)
+	}
+	return false, nil
+}
+
+var ErrTagCycle = errors.New("a tag can't be moved under itself or one of its descendants")
+
+// Move a tag under another tag, nil makes it a top level tag
+func SetTagParent(id int, parentID *int) error {
+	// Walk up from the new parent to make sure we don't close a loop
+	for current := parentID; current != nil; {
+		if *current == id {
+			return ErrTagCycle
+		}
+		parent, err := GetTag(*current)
+		if err != nil {
+			return err
+		}
+		current = parent.ParentID
+	}
+
+	_, err := db.Exec("UPDATE tags SET parent_id = ? WHERE id = ?", parentID, id)
 	return err
 }
 
-// Helper functions for tag management
+func DeleteTag(id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
 
-func updateTagCounts(tagString string) {
-	if tagString == "" {
-		return
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// Move the children of this tag up to its parent
+	_, err = tx.Exec("UPDATE tags SET parent_id = (SELECT parent_id FROM tags WHERE id = ?) WHERE parent_id = ?", id, id)
+	if err != nil {
+		return fmt.Errorf("failed to move child tags: %w", err)
+	}
+
+	// Remove this tag from all sessions, tasks and plans that have it
+	var tagged []int
+	tagged, err = sessionIDsWithTag(tx, id)
+	if err != nil {
+		return fmt.Errorf("failed to get sessions with the tag: %w", err)
+	}
+	_, err = tx.Exec("DELETE FROM session_tags WHERE tag_id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to remove tag from sessions: %w", err)
+	}
+	if len(tagged) > 0 {
+		placeholders, args := inClause(tagged)
+		if err = syncLegacySessionTags(tx, "id IN ("+placeholders+")", args...); err != nil {
+			return err
+		}
+	}
+	for _, link := range tagLinkTables {
+		if _, err = tx.Exec("DELETE FROM "+link.table+" WHERE tag_id = ?", id); err != nil {
+			return fmt.Errorf("failed to remove tag from %s: %w", link.table, err)
+		}
+	}
+
+	// Delete the tag
+	result, err := tx.Exec("DELETE FROM tags WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete tag: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		err = sql.ErrNoRows
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	tagList := strings.Split(tagString, ",")
-	for _, tag := range tagList {
+	return nil
+}
+
+// Helper functions for tag management
+
+// Split a comma-separated tag list into unique, trimmed names
+func parseTagNames(tagString string) []string {
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, tag := range strings.Split(tagString, ",") {
 		tag = strings.TrimSpace(tag)
-		if tag == "" {
+		if tag == "" || seen[tag] {
 			continue
 		}
+		seen[tag] = true
+		names = append(names, tag)
+	}
+	return names
+}
 
-		// Check if tag exists
-		var count int
-		row := db.QueryRow("SELECT COUNT(*) FROM tags WHERE name = ?", tag)
-		err := row.Scan(&count)
+// Get the ID of a tag by name in the owner's namespace, creating the tag if it
+// doesn't exist yet. The owner's own tags win over shared global tags, and a
+// NULL owner only sees global tags.
+func ensureTag(tx *sql.Tx, ownerID sql.NullInt64, name string) (int64, error) {
+	var tagID int64
+	err := tx.QueryRow("SELECT id FROM tags WHERE name = ? AND (user_id = ? OR user_id IS NULL) ORDER BY user_id IS NULL LIMIT 1",
+		name, ownerID).Scan(&tagID)
+	if err == nil {
+		return tagID, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	// Create new tag with default color
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM tags").Scan(&count); err != nil {
+		return 0, err
+	}
+
+	result, err := tx.Exec("INSERT INTO tags(name, color, usage_count, user_id) VALUES (?, ?, ?, ?)", name, colorForIndex(count), 0, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// Replace the tags of a session in session_tags with the given comma-separated list
+func setSessionTags(tx *sql.Tx, sessionID int64, tagString string) error {
+	// Tags are looked up in the namespace of the session owner
+	var ownerID sql.NullInt64
+	err := tx.QueryRow("SELECT user_id FROM sessions WHERE id = ?", sessionID).Scan(&ownerID)
+	if err != nil {
+		return fmt.Errorf("failed to get session owner: %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM session_tags WHERE session_id = ?", sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to clear session tags: %w", err)
+	}
+
+	for _, name := range parseTagNames(tagString) {
+		tagID, err := ensureTag(tx, ownerID, name)
 		if err != nil {
-			continue
+			return fmt.Errorf("failed to get tag %q: %w", name, err)
 		}
 
-		if count > 0 {
-			// Increment usage count for existing tag
-			db.Exec("UPDATE tags SET usage_count = usage_count + 1 WHERE name = ?", tag)
-		} else {
-			// Create new tag with default color
-			CreateTag(tag, getRandomColor())
+		_, err = tx.Exec("INSERT INTO session_tags(session_id, tag_id) VALUES (?, ?)", sessionID, tagID)
+		if err != nil {
+			return fmt.Errorf("failed to add tag %q: %w", name, err)
 		}
 	}
+
+	return syncLegacySessionTags(tx, "id = ?", sessionID)
 }
 
-func decrementTagCounts(tagString string) {
-	if tagString == "" {
-		return
+// Mirror the tags of the sessions matching condition into the legacy
+// sessions.tags column, which an older binary still reads after a rollback
+func syncLegacySessionTags(tx *sql.Tx, condition string, args ...interface{}) error {
+	_, err := tx.Exec("UPDATE sessions SET tags = COALESCE("+sessionTagsColumn+", ''), legacy_tags_copied = 1 WHERE "+condition, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update legacy session tags: %w", err)
 	}
+	return nil
+}
 
-	tagList := strings.Split(tagString, ",")
-	for _, tag := range tagList {
-		tag = strings.TrimSpace(tag)
-		if tag == "" {
-			continue
-		}
+// Helper function to get the IDs of the sessions carrying a tag
+func sessionIDsWithTag(tx *sql.Tx, tagID int) ([]int, error) {
+	rows, err := tx.Query("SELECT session_id FROM session_tags WHERE tag_id = ?", tagID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		// Decrement usage count
-		db.Exec("UPDATE tags SET usage_count = MAX(0, usage_count - 1) WHERE name = ?", tag)
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
 	}
+	return ids, rows.Err()
 }
 
-func getRandomColor() string {
-	// Generate a random color from a predefined list
-	colors := []string{
-		"#3498db", // Blue
-		"#2ecc71", // Green
-		"#e74c3c", // Red
-		"#f39c12", // Orange
-		"#9b59b6", // Purple
-		"#1abc9c", // Teal
-		"#d35400", // Dark Orange
-		"#34495e", // Dark Blue
-		"#16a085", // Light Green
-		"#c0392b", // Burgundy
+// Tables linking tasks and planned sessions to their tags, like session_tags
+var tagLinkTables = []struct {
+	table  string
+	column string
+}{
+	{"task_tags", "task_id"},
+	{"planned_session_tags", "plan_id"},
+}
+
+// Comma-separated tag names linked through one of tagLinkTables, for the row
+// of the outer query referenced by outerID
+func tagLinksColumn(table string, column string, outerID string) string {
+	return `(SELECT GROUP_CONCAT(name, ',') FROM (
+		SELECT tg.name FROM ` + table + ` link JOIN tags tg ON tg.id = link.tag_id
+		WHERE link.` + column + ` = ` + outerID + ` ORDER BY link.id))`
+}
+
+// Replace the tags a task or planned session is linked to with the given
+// comma-separated list, looked up in the owner's namespace
+func setTagLinks(tx *sql.Tx, table string, column string, id int64, ownerID int, tagString string) error {
+	_, err := tx.Exec("DELETE FROM "+table+" WHERE "+column+" = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to clear tags: %w", err)
+	}
+
+	owner := sql.NullInt64{Int64: int64(ownerID), Valid: true}
+	for _, name := range parseTagNames(tagString) {
+		tagID, err := ensureTag(tx, owner, name)
+		if err != nil {
+			return fmt.Errorf("failed to get tag %q: %w", name, err)
+		}
+
+		_, err = tx.Exec("INSERT INTO "+table+"("+column+", tag_id) VALUES (?, ?)", id, tagID)
+		if err != nil {
+			return fmt.Errorf("failed to add tag %q: %w", name, err)
+		}
 	}
 
+	return nil
+}
+
+// Predefined colors handed out to new tags and projects
+var tagColors = []string{
+	"#3498db", // Blue
+	"#2ecc71", // Green
+	"#e74c3c", // Red
+	"#f39c12", // Orange
+	"#9b59b6", // Purple
+	"#1abc9c", // Teal
+	"#d35400", // Dark Orange
+	"#34495e", // Dark Blue
+	"#16a085", // Light Green
+	"#c0392b", // Burgundy
+}
+
+func colorForIndex(index int) string {
+	return tagColors[index%len(tagColors)]
+}
+
+func getRandomColor() string {
 	// Get count of tags to use as index
 	var count int
 	row := db.QueryRow("SELECT COUNT(*) FROM tags")
 	err := row.Scan(&count)
 	if err != nil {
-		return colors[0]
+		return tagColors[0]
 	}
 
-	index := count % len(colors)
-	return colors[index]
+	return colorForIndex(count)
 }
 
 // Pomodoro CRUD functions
 
-func CreatePomodoro(sessionID int, number int, startTime string, status string) (int64, error) {
-	statement, err := db.Prepare("INSERT INTO pomodoros(session_id, number, start_time, status) VALUES (?, ?, ?, ?)")
+func CreatePomodoro(sessionID int, number int, startTime string, status string, taskID *int) (int64, error) {
+	statement, err := db.Prepare("INSERT INTO pomodoros(session_id, number, start_time, status, task_id) VALUES (?, ?, ?, ?, ?)")
 	if err != nil {
 		return 0, err
 	}
-	result, err := statement.Exec(sessionID, number, startTime, status)
+	result, err := statement.Exec(sessionID, number, startTime, status, taskID)
 	if err != nil {
 		return 0, err
 	}
 	return result.LastInsertId()
 }
 
+const pomodoroColumns = `id, session_id, number, start_time, end_time, duration, status, task_id, COALESCE(version, 1),
+	focus_rating, energy_level, reflection,
+	(SELECT COUNT(*) FROM interruptions i WHERE i.pomodoro_id = pomodoros.id AND i.type = 'internal'),
+	(SELECT COUNT(*) FROM interruptions i WHERE i.pomodoro_id = pomodoros.id AND i.type = 'external')`
+
+func scanPomodoro(row rowScanner) (Pomodoro, error) {
+	var pomodoro Pomodoro
+	var endTime sql.NullString
+	var duration sql.NullInt64
+	err := row.Scan(&pomodoro.ID, &pomodoro.SessionID, &pomodoro.Number, &pomodoro.StartTime, &endTime, &duration, &pomodoro.Status, &pomodoro.TaskID, &pomodoro.Version,
+		&pomodoro.FocusRating, &pomodoro.EnergyLevel, &pomodoro.Reflection, &pomodoro.InternalInterruptions, &pomodoro.ExternalInterruptions)
+	// A running pomodoro has no end time or duration yet
+	pomodoro.EndTime = endTime.String
+	pomodoro.Duration = int(duration.Int64)
+	return pomodoro, err
+}
+
 func GetPomodoros(sessionID int) ([]Pomodoro, error) {
-	rows, err := db.Query("SELECT id, session_id, number, start_time, end_time, duration, status FROM pomodoros WHERE session_id = ? ORDER BY number", sessionID)
+	rows, err := db.Query("SELECT "+pomodoroColumns+" FROM pomodoros WHERE session_id = ? ORDER BY number", sessionID)
 	if err != nil {
 		return nil, err
 	}
@@ -647,8 +1258,7 @@ func GetPomodoros(sessionID int) ([]Pomodoro, error) {
 
 	var pomodoros []Pomodoro
 	for rows.Next() {
-		var pomodoro Pomodoro
-		err := rows.Scan(&pomodoro.ID, &pomodoro.SessionID, &pomodoro.Number, &pomodoro.StartTime, &pomodoro.EndTime, &pomodoro.Duration, &pomodoro.Status)
+		pomodoro, err := scanPomodoro(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -657,13 +1267,61 @@ func GetPomodoros(sessionID int) ([]Pomodoro, error) {
 	return pomodoros, nil
 }
 
-func UpdatePomodoro(id int, endTime string, duration int, status string) error {
-	statement, err := db.Prepare("UPDATE pomodoros SET end_time = ?, duration = ?, status = ? WHERE id = ?")
+func GetPomodoro(id int) (Pomodoro, error) {
+	row := db.QueryRow("SELECT "+pomodoroColumns+" FROM pomodoros WHERE id = ?", id)
+	return scanPomodoro(row)
+}
+
+// Link a pomodoro to the task it was spent on, nil unlinks it
+func SetPomodoroTask(id int, taskID *int) error {
+	_, err := db.Exec("UPDATE pomodoros SET task_id = ?, version = COALESCE(version, 1) + 1 WHERE id = ?", taskID, id)
+	return err
+}
+
+// Update a pomodoro, a non-zero ifVersion guards against concurrent changes
+func UpdatePomodoro(id int, endTime string, duration int, status string, ifVersion int) error {
+	statement, err := db.Prepare(`UPDATE pomodoros SET end_time = ?, duration = ?, status = ?, version = COALESCE(version, 1) + 1
+		WHERE id = ? AND (? = 0 OR COALESCE(version, 1) = ?)`)
 	if err != nil {
 		return err
 	}
-	_, err = statement.Exec(endTime, duration, status, id)
-	return err
+	result, err := statement.Exec(endTime, duration, status, id, ifVersion, ifVersion)
+	if err != nil {
+		return err
+	}
+	return versionedUpdateError(result, db, "pomodoros", id)
+}
+
+// Rate a pomodoro. Only the given values are changed, an empty reflection
+// removes it. A non-zero ifVersion guards against concurrent changes.
+func RatePomodoro(id int, focusRating *int, energyLevel *int, reflection *string, ifVersion int) error {
+	result, err := db.Exec(`UPDATE pomodoros SET
+			focus_rating = COALESCE(?, focus_rating),
+			energy_level = COALESCE(?, energy_level),
+			reflection = CASE WHEN ? IS NULL THEN reflection ELSE NULLIF(?, '') END,
+			version = COALESCE(version, 1) + 1
+		WHERE id = ? AND (? = 0 OR COALESCE(version, 1) = ?)`,
+		focusRating, energyLevel, reflection, reflection, id, ifVersion, ifVersion)
+	if err != nil {
+		return err
+	}
+	return versionedUpdateError(result, db, "pomodoros", id)
+}
+
+// Update a pomodoro and rate it in one versioned write, the rating values
+// behave as in RatePomodoro
+func UpdatePomodoroWithRating(id int, endTime string, duration int, status string, focusRating *int, energyLevel *int, reflection *string, ifVersion int) error {
+	result, err := db.Exec(`UPDATE pomodoros SET end_time = ?, duration = ?, status = ?,
+			focus_rating = COALESCE(?, focus_rating),
+			energy_level = COALESCE(?, energy_level),
+			reflection = CASE WHEN ? IS NULL THEN reflection ELSE NULLIF(?, '') END,
+			version = COALESCE(version, 1) + 1
+		WHERE id = ? AND (? = 0 OR COALESCE(version, 1) = ?)`,
+		endTime, duration, status, focusRating, energyLevel, reflection, reflection, id, ifVersion, ifVersion)
+	if err != nil {
+		return err
+	}
+	return versionedUpdateError(result, db, "pomodoros", id)
 }
 
 // Break CRUD functions
@@ -680,8 +1338,22 @@ func CreateBreak(sessionID int, pomodoroID int, breakType string, startTime stri
 	return result.LastInsertId()
 }
 
+const breakColumns = "id, session_id, pomodoro_id, type, start_time, end_time, duration, status, COALESCE(version, 1)"
+
+func scanBreak(row rowScanner) (Break, error) {
+	var breakItem Break
+	var pomodoroID sql.NullInt64
+	var endTime sql.NullString
+	var duration sql.NullInt64
+	err := row.Scan(&breakItem.ID, &breakItem.SessionID, &pomodoroID, &breakItem.Type, &breakItem.StartTime, &endTime, &duration, &breakItem.Status, &breakItem.Version)
+	breakItem.PomodoroID = int(pomodoroID.Int64)
+	breakItem.EndTime = endTime.String
+	breakItem.Duration = int(duration.Int64)
+	return breakItem, err
+}
+
 func GetBreaks(sessionID int) ([]Break, error) {
-	rows, err := db.Query("SELECT id, session_id, pomodoro_id, type, start_time, end_time, duration, status FROM breaks WHERE session_id = ? ORDER BY id", sessionID)
+	rows, err := db.Query("SELECT "+breakColumns+" FROM breaks WHERE session_id = ? ORDER BY id", sessionID)
 	if err != nil {
 		return nil, err
 	}
@@ -689,8 +1361,7 @@ func GetBreaks(sessionID int) ([]Break, error) {
 
 	var breaks []Break
 	for rows.Next() {
-		var breakItem Break
-		err := rows.Scan(&breakItem.ID, &breakItem.SessionID, &breakItem.PomodoroID, &breakItem.Type, &breakItem.StartTime, &breakItem.EndTime, &breakItem.Duration, &breakItem.Status)
+		breakItem, err := scanBreak(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -699,28 +1370,68 @@ func GetBreaks(sessionID int) ([]Break, error) {
 	return breaks, nil
 }
 
-func UpdateBreak(id int, endTime string, duration int, status string) error {
-	statement, err := db.Prepare("UPDATE breaks SET end_time = ?, duration = ?, status = ? WHERE id = ?")
+func GetBreak(id int) (Break, error) {
+	row := db.QueryRow("SELECT "+breakColumns+" FROM breaks WHERE id = ?", id)
+	return scanBreak(row)
+}
+
+// Update a break, a non-zero ifVersion guards against concurrent changes
+func UpdateBreak(id int, endTime string, duration int, status string, ifVersion int) error {
+	statement, err := db.Prepare(`UPDATE breaks SET end_time = ?, duration = ?, status = ?, version = COALESCE(version, 1) + 1
+		WHERE id = ? AND (? = 0 OR COALESCE(version, 1) = ?)`)
 	if err != nil {
 		return err
 	}
-	_, err = statement.Exec(endTime, duration, status, id)
-	return err
+	result, err := statement.Exec(endTime, duration, status, id, ifVersion, ifVersion)
+	if err != nil {
+		return err
+	}
+	return versionedUpdateError(result, db, "breaks", id)
 }
 
 // Note CRUD functions
 
 func CreateNote(sessionID int, pomodoroID int, noteText string) error {
-	statement, err := db.Prepare("INSERT INTO notes(session_id, pomodoro_id, note) VALUES (?, ?, ?)")
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// Notes that don't belong to a pomodoro keep a NULL pomodoro_id
+	var pomodoroValue interface{} = nil
+	if pomodoroID != 0 {
+		pomodoroValue = pomodoroID
+	}
+
+	result, err := tx.Exec("INSERT INTO notes(session_id, pomodoro_id, note) VALUES (?, ?, ?)", sessionID, pomodoroValue, noteText)
 	if err != nil {
 		return err
 	}
-	_, err = statement.Exec(sessionID, pomodoroID, noteText)
-	return err
+
+	noteID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	if err = recordNoteRevision(tx, noteID, NoteRevisionCreate); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
 }
 
 func GetNotes(sessionID int) ([]Note, error) {
-	rows, err := db.Query("SELECT id, session_id, pomodoro_id, note, created_at FROM notes WHERE session_id = ? ORDER BY created_at DESC", sessionID)
+	rows, err := db.Query("SELECT id, session_id, COALESCE(pomodoro_id, 0), note, created_at, COALESCE(version, 1) FROM notes WHERE session_id = ? ORDER BY created_at DESC", sessionID)
 	if err != nil {
 		return nil, err
 	}
@@ -729,7 +1440,7 @@ func GetNotes(sessionID int) ([]Note, error) {
 	var notes []Note
 	for rows.Next() {
 		var note Note
-		err := rows.Scan(&note.ID, &note.SessionID, &note.PomodoroID, &note.NoteText, &note.CreatedAt)
+		err := rows.Scan(&note.ID, &note.SessionID, &note.PomodoroID, &note.NoteText, &note.CreatedAt, &note.Version)
 		if err != nil {
 			return nil, err
 		}
@@ -738,28 +1449,110 @@ func GetNotes(sessionID int) ([]Note, error) {
 	return notes, nil
 }
 
-func UpdateNote(id int, noteText string) error {
-	statement, err := db.Prepare("UPDATE notes SET note = ? WHERE id = ?")
+// Get the notes of several sessions in one query, keyed by session ID and
+// newest first like GetNotes
+func GetNotesForSessions(sessionIDs []int) (map[int][]Note, error) {
+	notes := make(map[int][]Note)
+	if len(sessionIDs) == 0 {
+		return notes, nil
+	}
+
+	placeholders, args := inClause(sessionIDs)
+	rows, err := db.Query("SELECT id, session_id, COALESCE(pomodoro_id, 0), note, created_at, COALESCE(version, 1) FROM notes WHERE session_id IN ("+placeholders+") ORDER BY created_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var note Note
+		err := rows.Scan(&note.ID, &note.SessionID, &note.PomodoroID, &note.NoteText, &note.CreatedAt, &note.Version)
+		if err != nil {
+			return nil, err
+		}
+		notes[note.SessionID] = append(notes[note.SessionID], note)
+	}
+	return notes, rows.Err()
+}
+
+func GetNote(id int) (Note, error) {
+	var note Note
+	row := db.QueryRow("SELECT id, session_id, COALESCE(pomodoro_id, 0), note, created_at, COALESCE(version, 1) FROM notes WHERE id = ?", id)
+	err := row.Scan(&note.ID, &note.SessionID, &note.PomodoroID, &note.NoteText, &note.CreatedAt, &note.Version)
+	return note, err
+}
+
+// Update the text of a note, a non-zero ifVersion guards against concurrent changes
+func UpdateNote(id int, noteText string, ifVersion int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	result, err := tx.Exec(`UPDATE notes SET note = ?, version = COALESCE(version, 1) + 1
+		WHERE id = ? AND (? = 0 OR COALESCE(version, 1) = ?)`, noteText, id, ifVersion, ifVersion)
 	if err != nil {
 		return err
 	}
-	_, err = statement.Exec(noteText, id)
-	return err
+	if err = versionedUpdateError(result, tx, "notes", id); err != nil {
+		return err
+	}
+
+	if err = recordNoteRevision(tx, int64(id), NoteRevisionUpdate); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
 }
 
+// Delete a note, its last state stays in note_revisions so it can be restored
 func DeleteNote(id int) error {
-	_, err := db.Exec("DELETE FROM notes WHERE id = ?", id)
-	return err
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err = recordNoteRevision(tx, int64(id), NoteRevisionDelete); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM notes WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
 }
 
-// Get all notes for the notes browsing page
-func GetAllNotes() ([]Note, error) {
+// Get all notes of a user for the notes browsing page
+func GetAllNotesForUser(userID int) ([]Note, error) {
 	rows, err := db.Query(`
-		SELECT n.id, n.session_id, n.pomodoro_id, n.note, n.created_at 
+		SELECT n.id, n.session_id, COALESCE(n.pomodoro_id, 0), n.note, n.created_at, COALESCE(n.version, 1)
 		FROM notes n
 		JOIN sessions s ON n.session_id = s.id
+		WHERE s.user_id = ?
 		ORDER BY n.created_at DESC
-	`)
+	`, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -768,7 +1561,7 @@ func GetAllNotes() ([]Note, error) {
 	var notes []Note
 	for rows.Next() {
 		var note Note
-		err := rows.Scan(&note.ID, &note.SessionID, &note.PomodoroID, &note.NoteText, &note.CreatedAt)
+		err := rows.Scan(&note.ID, &note.SessionID, &note.PomodoroID, &note.NoteText, &note.CreatedAt, &note.Version)
 		if err != nil {
 			return nil, err
 		}
@@ -780,7 +1573,7 @@ func GetAllNotes() ([]Note, error) {
 // Get sessions by date range
 func getSessionsByDateRange(startDate string, endDate string) ([]Session, error) {
 	query := `
-		SELECT id, start_time, end_time, total_time, status, completed_pomodoros, tags 
+		SELECT ` + sessionColumns + `
 		FROM sessions 
 		WHERE start_time >= ? AND start_time <= ? 
 		ORDER BY start_time
@@ -793,21 +1586,10 @@ func getSessionsByDateRange(startDate string, endDate string) ([]Session, error)
 
 	var sessions []Session
 	for rows.Next() {
-		var session Session
-		var tagsNullable sql.NullString // Use NullString to handle NULL values
-
-		err := rows.Scan(&session.ID, &session.StartTime, &session.EndTime, &session.TotalTime, &session.Status, &session.Completed, &tagsNullable)
+		session, err := scanSession(rows)
 		if err != nil {
 			return nil, err
 		}
-
-		// Set tags to empty string if NULL or the actual value if not NULL
-		if tagsNullable.Valid {
-			session.Tags = tagsNullable.String
-		} else {
-			session.Tags = ""
-		}
-
 		sessions = append(sessions, session)
 	}
 	return sessions, nil
@@ -816,15 +1598,13 @@ func getSessionsByDateRange(startDate string, endDate string) ([]Session, error)
 // Get sessions by tag
 
 func GetSessionsByTag(tag string) ([]Session, error) {
-	// Using LIKE with wildcards to match tag in the comma-separated list
 	query := `
-		SELECT id, start_time, end_time, total_time, status, completed_pomodoros, tags 
+		SELECT ` + sessionColumns + `
 		FROM sessions 
-		WHERE tags LIKE ? OR tags LIKE ? OR tags LIKE ? OR tags = ?
+		WHERE ` + sessionHasTag + `
 		ORDER BY start_time DESC
 	`
-	// Four patterns to match: exact match, start of list, end of list, or middle of list
-	rows, err := db.Query(query, tag, tag+",%", "%,"+tag, "%,"+tag+",%")
+	rows, err := db.Query(query, tag)
 	if err != nil {
 		return nil, err
 	}
@@ -832,28 +1612,18 @@ func GetSessionsByTag(tag string) ([]Session, error) {
 
 	var sessions []Session
 	for rows.Next() {
-		var session Session
-		var tagsNullable sql.NullString // Use NullString to handle NULL values
-
-		err := rows.Scan(&session.ID, &session.StartTime, &session.EndTime, &session.TotalTime, &session.Status, &session.Completed, &tagsNullable)
+		session, err := scanSession(rows)
 		if err != nil {
 			return nil, err
 		}
-
-		// Set tags to empty string if NULL or the actual value if not NULL
-		if tagsNullable.Valid {
-			session.Tags = tagsNullable.String
-		} else {
-			session.Tags = ""
-		}
-
 		sessions = append(sessions, session)
 	}
 	return sessions, nil
 }
 
-// Get monthly stats for each tag
-func GetMonthlyTagStats(year int) (map[string]map[string]int, error) {
+// Get monthly stats for each tag of a user. With rollup, the minutes of a
+// tag are also added to every one of its ancestors.
+func GetMonthlyTagStats(year int, userID int, rollup bool) (map[string]map[string]int, error) {
 	// Final structure will be: { "month": { "tag1": minutes, "tag2": minutes } }
 	stats := make(map[string]map[string]int)
 
@@ -865,14 +1635,25 @@ func GetMonthlyTagStats(year int) (map[string]map[string]int, error) {
 
 	// Query sessions for the given year
 	query := `
-		SELECT start_time, total_time, tags 
+		SELECT start_time, total_time, COALESCE(` + sessionTagsColumn + `, '')
 		FROM sessions 
-		WHERE strftime('%Y', start_time) = ? 
+		WHERE user_id = ?
+		AND strftime('%Y', start_time) = ? 
 		AND status IN ('completed', 'stopped') 
 		AND total_time > 0
 		ORDER BY start_time
 	`
-	rows, err := db.Query(query, fmt.Sprintf("%d", year))
+	// Load the hierarchy up front when rolling up
+	ancestors := map[string][]string{}
+	if rollup {
+		var err error
+		ancestors, err = getTagAncestorsForUser(userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := db.Query(query, userID, fmt.Sprintf("%d", year))
 	if err != nil {
 		return nil, err
 	}
@@ -911,16 +1692,21 @@ func GetMonthlyTagStats(year int) (map[string]map[string]int, error) {
 			tagList := strings.Split(tags, ",")
 			minutesPerTag := minutes / len(tagList)
 
+			// A tag counts once per session, also when several of the
+			// session's tags roll up into it
+			counted := make(map[string]bool)
 			for _, tag := range tagList {
 				tag = strings.TrimSpace(tag)
 				if tag == "" {
 					continue
 				}
 
-				if _, ok := stats[monthName][tag]; !ok {
-					stats[monthName][tag] = 0
+				for _, name := range append([]string{tag}, ancestors[tag]...) {
+					if !counted[name] {
+						counted[name] = true
+						stats[monthName][name] += minutesPerTag
+					}
 				}
-				stats[monthName][tag] += minutesPerTag
 			}
 		}
 	}
@@ -928,6 +1714,39 @@ func GetMonthlyTagStats(year int) (map[string]map[string]int, error) {
 	return stats, nil
 }
 
+// Map every tag name visible to a user to the names of its ancestors, closest first
+func getTagAncestorsForUser(userID int) (map[string][]string, error) {
+	tags, err := GetTagsForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int]Tag)
+	byName := make(map[string]Tag)
+	for _, tag := range tags {
+		byID[tag.ID] = tag
+		// The user's own tag wins over a global tag of the same name
+		if existing, ok := byName[tag.Name]; !ok || existing.UserID == nil {
+			byName[tag.Name] = tag
+		}
+	}
+
+	ancestors := make(map[string][]string)
+	for name, tag := range byName {
+		seen := map[int]bool{tag.ID: true}
+		for tag.ParentID != nil && !seen[*tag.ParentID] {
+			parent, ok := byID[*tag.ParentID]
+			if !ok {
+				break
+			}
+			seen[parent.ID] = true
+			ancestors[name] = append(ancestors[name], parent.Name)
+			tag = parent
+		}
+	}
+	return ancestors, nil
+}
+
 func CreateUser(input UserInput) (int64, error) {
 	// Hash the password
 	passwordHash, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
@@ -1060,26 +1879,102 @@ func GenerateSecurePassword(length int) (string, error) {
 	return base64.URLEncoding.EncodeToString(bytes)[:length], nil
 }
 
-func CreateSessionWithUser(startTime string, tags string, userID int) (int64, error) {
-	statement, err := db.Prepare("INSERT INTO sessions(start_time, status, completed_pomodoros, tags, user_id) VALUES (?, ?, ?, ?, ?)")
+// Create a running session owned by the user from the start time, tags,
+// profile, mode and project of the given session
+func CreateSessionWithUser(session Session, userID int) (int64, error) {
+	if session.Mode == "" {
+		session.Mode = SessionModeClassic
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	result, err := tx.Exec("INSERT INTO sessions(start_time, status, completed_pomodoros, user_id, profile_id, mode, project_id) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		session.StartTime, "running", 0, userID, session.ProfileID, session.Mode, session.ProjectID)
+	if err != nil {
+		return 0, err
+	}
+
+	sessionID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if err = setSessionTags(tx, sessionID, session.Tags); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return sessionID, nil
+}
+
+// Create a finished session of a user together with its note in one
+// transaction, for imports. An empty note adds no note.
+func CreateCompletedSession(session Session, userID int, noteText string) (int64, error) {
+	if session.Mode == "" {
+		session.Mode = SessionModeClassic
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	result, err := tx.Exec("INSERT INTO sessions(start_time, end_time, total_time, status, completed_pomodoros, user_id, profile_id, mode, project_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		session.StartTime, session.EndTime, session.TotalTime, "completed", session.Completed, userID, session.ProfileID, session.Mode, session.ProjectID)
 	if err != nil {
 		return 0, err
 	}
-	result, err := statement.Exec(startTime, "running", 0, tags, userID)
+
+	sessionID, err := result.LastInsertId()
 	if err != nil {
 		return 0, err
 	}
 
-	// Update tag usage counts
-	if tags != "" {
-		updateTagCounts(tags)
+	if err = setSessionTags(tx, sessionID, session.Tags); err != nil {
+		return 0, err
 	}
 
-	return result.LastInsertId()
+	if noteText != "" {
+		result, err = tx.Exec("INSERT INTO notes(session_id, note) VALUES (?, ?)", sessionID, noteText)
+		if err != nil {
+			return 0, err
+		}
+		var noteID int64
+		if noteID, err = result.LastInsertId(); err != nil {
+			return 0, err
+		}
+		if err = recordNoteRevision(tx, noteID, NoteRevisionCreate); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return sessionID, nil
 }
 
 func GetSessionsForUser(userID int) ([]Session, error) {
-	rows, err := db.Query("SELECT id, start_time, end_time, total_time, status, completed_pomodoros, tags FROM sessions WHERE user_id = ? OR user_id IS NULL ORDER BY id DESC", userID)
+	rows, err := db.Query("SELECT "+sessionColumns+" FROM sessions WHERE user_id = ? ORDER BY id DESC", userID)
 	if err != nil {
 		return nil, err
 	}
@@ -1087,36 +1982,30 @@ func GetSessionsForUser(userID int) ([]Session, error) {
 
 	var sessions []Session
 	for rows.Next() {
-		var session Session
-		var tagsNullable sql.NullString // Use NullString to handle NULL values
-
-		// Scan using the nullable type for tags
-		err := rows.Scan(&session.ID, &session.StartTime, &session.EndTime, &session.TotalTime, &session.Status, &session.Completed, &tagsNullable)
+		session, err := scanSession(rows)
 		if err != nil {
 			return nil, err
 		}
-
-		// Set tags to empty string if NULL or the actual value if not NULL
-		if tagsNullable.Valid {
-			session.Tags = tagsNullable.String
-		} else {
-			session.Tags = ""
-		}
-
 		sessions = append(sessions, session)
 	}
 	return sessions, nil
 }
 
-func GetSessionsByDateRangeForUser(startDate string, endDate string, userID int) ([]Session, error) {
+// Condition keeping the sessions of one project, a nil project keeps all.
+// Takes the project ID twice.
+const sessionInProject = "(? IS NULL OR project_id = ?)"
+
+// Get a user's sessions in a date range, optionally of one project only
+func GetSessionsByDateRangeForUser(startDate string, endDate string, userID int, projectID *int) ([]Session, error) {
 	query := `
-		SELECT id, start_time, end_time, total_time, status, completed_pomodoros, tags 
+		SELECT ` + sessionColumns + `
 		FROM sessions 
-		WHERE (user_id = ? OR user_id IS NULL) 
+		WHERE user_id = ?
 		AND start_time >= ? AND start_time <= ? 
+		AND ` + sessionInProject + `
 		ORDER BY start_time
 	`
-	rows, err := db.Query(query, userID, startDate, endDate)
+	rows, err := db.Query(query, userID, startDate, endDate, projectID, projectID)
 	if err != nil {
 		return nil, err
 	}
@@ -1124,37 +2013,63 @@ func GetSessionsByDateRangeForUser(startDate string, endDate string, userID int)
 
 	var sessions []Session
 	for rows.Next() {
-		var session Session
-		var tagsNullable sql.NullString // Use NullString to handle NULL values
-
-		err := rows.Scan(&session.ID, &session.StartTime, &session.EndTime, &session.TotalTime, &session.Status, &session.Completed, &tagsNullable)
+		session, err := scanSession(rows)
 		if err != nil {
 			return nil, err
 		}
-
-		// Set tags to empty string if NULL or the actual value if not NULL
-		if tagsNullable.Valid {
-			session.Tags = tagsNullable.String
-		} else {
-			session.Tags = ""
-		}
-
 		sessions = append(sessions, session)
 	}
 	return sessions, nil
 }
 
-func GetSessionsByTagForUser(tag string, userID int) ([]Session, error) {
-	// Using LIKE with wildcards to match tag in the comma-separated list
+// Pomodoro and break time of a session
+type SessionTotals struct {
+	Pomodoros    int
+	PomodoroTime int // In seconds
+	BreakTime    int // In seconds
+}
+
+// Get the pomodoro and break totals of a user's sessions in a date range in
+// one query, keyed by session ID
+func GetSessionTotalsForUser(startDate string, endDate string, userID int) (map[int]SessionTotals, error) {
+	query := `
+		SELECT s.id,
+			(SELECT COUNT(*) FROM pomodoros p WHERE p.session_id = s.id),
+			(SELECT COALESCE(SUM(p.duration), 0) FROM pomodoros p WHERE p.session_id = s.id),
+			(SELECT COALESCE(SUM(b.duration), 0) FROM breaks b WHERE b.session_id = s.id)
+		FROM sessions s
+		WHERE s.user_id = ?
+		AND s.start_time >= ? AND s.start_time <= ?
+	`
+	rows, err := db.Query(query, userID, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make(map[int]SessionTotals)
+	for rows.Next() {
+		var id int
+		var total SessionTotals
+		if err := rows.Scan(&id, &total.Pomodoros, &total.PomodoroTime, &total.BreakTime); err != nil {
+			return nil, err
+		}
+		totals[id] = total
+	}
+	return totals, rows.Err()
+}
+
+// Get a user's sessions with a tag, optionally of one project only
+func GetSessionsByTagForUser(tag string, userID int, projectID *int) ([]Session, error) {
 	query := `
-		SELECT id, start_time, end_time, total_time, status, completed_pomodoros, tags 
+		SELECT ` + sessionColumns + `
 		FROM sessions 
-		WHERE (user_id = ? OR user_id IS NULL)
-		AND (tags LIKE ? OR tags LIKE ? OR tags LIKE ? OR tags = ?)
+		WHERE user_id = ?
+		AND ` + sessionHasTagTree + `
+		AND ` + sessionInProject + `
 		ORDER BY start_time DESC
 	`
-	// Four patterns to match: exact match, start of list, end of list, or middle of list
-	rows, err := db.Query(query, userID, tag, tag+",%", "%,"+tag, "%,"+tag+",%")
+	rows, err := db.Query(query, userID, tag, userID, userID, projectID, projectID)
 	if err != nil {
 		return nil, err
 	}
@@ -1162,52 +2077,75 @@ func GetSessionsByTagForUser(tag string, userID int) ([]Session, error) {
 
 	var sessions []Session
 	for rows.Next() {
-		var session Session
-		var tagsNullable sql.NullString // Use NullString to handle NULL values
-
-		err := rows.Scan(&session.ID, &session.StartTime, &session.EndTime, &session.TotalTime, &session.Status, &session.Completed, &tagsNullable)
+		session, err := scanSession(rows)
 		if err != nil {
 			return nil, err
 		}
-
-		// Set tags to empty string if NULL or the actual value if not NULL
-		if tagsNullable.Valid {
-			session.Tags = tagsNullable.String
-		} else {
-			session.Tags = ""
-		}
-
 		sessions = append(sessions, session)
 	}
 	return sessions, nil
 }
 
-func IsSessionOwner(sessionID int, userID int) (bool, error) {
-	var ownerID sql.NullInt64
+// Get a fingerprint of what a Markdown rendering of each of a user's sessions
+// shows besides the session row itself: the project name, the pomodoros and
+// the notes with their versions. Keyed by session ID.
+func GetSessionContentVersions(userID int) (map[int]string, error) {
+	rows, err := db.Query(`
+		SELECT s.id,
+			COALESCE((SELECT name FROM projects WHERE id = s.project_id), ''),
+			COALESCE((SELECT GROUP_CONCAT(id || ':' || number || ':' || COALESCE(duration, 0)) FROM pomodoros WHERE session_id = s.id), ''),
+			COALESCE((SELECT GROUP_CONCAT(id || ':' || COALESCE(version, 1) || ':' || COALESCE(pomodoro_id, 0)) FROM notes WHERE session_id = s.id), '')
+		FROM sessions s
+		WHERE s.user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var project, pomodoros, notes string
+		if err := rows.Scan(&id, &project, &pomodoros, &notes); err != nil {
+			return nil, err
+		}
+		versions[id] = project + "|" + pomodoros + "|" + notes
+	}
+	return versions, rows.Err()
+}
+
+// Get the user a session belongs to, nil for a session without an owner
+func GetSessionOwner(sessionID int) (*int, error) {
+	var ownerID *int
 	err := db.QueryRow("SELECT user_id FROM sessions WHERE id = ?", sessionID).Scan(&ownerID)
+	return ownerID, err
+}
+
+// Sessions without an owner belong to nobody, only admins may access them
+func IsSessionOwner(sessionID int, userID int) (bool, error) {
+	ownerID, err := GetSessionOwner(sessionID)
 	if err != nil {
 		return false, err
 	}
-
-	// Either the session has no owner (NULL user_id) or the user is the owner
-	return !ownerID.Valid || (ownerID.Valid && int(ownerID.Int64) == userID), nil
+	return ownerID != nil && *ownerID == userID, nil
 }
 
 // Add this to database.go
-func GetSessionsByDaysForUser(days int, userID int) ([]Session, error) {
+func GetSessionsByDaysForUser(days int, userID int, projectID *int) ([]Session, error) {
 	// Calculate start date (n days ago)
 	now := time.Now()
 	startDate := now.AddDate(0, 0, -days).Format("2006-01-02T15:04:05.999Z")
 
 	query := `
-		SELECT id, start_time, end_time, total_time, status, completed_pomodoros, tags 
+		SELECT ` + sessionColumns + `
 		FROM sessions 
-		WHERE (user_id = ? OR user_id IS NULL)
+		WHERE user_id = ?
 		AND start_time >= ? 
+		AND ` + sessionInProject + `
 		ORDER BY start_time DESC
 	`
 
-	rows, err := db.Query(query, userID, startDate)
+	rows, err := db.Query(query, userID, startDate, projectID, projectID)
 	if err != nil {
 		return nil, err
 	}
@@ -1215,20 +2153,10 @@ func GetSessionsByDaysForUser(days int, userID int) ([]Session, error) {
 
 	var sessions []Session
 	for rows.Next() {
-		var session Session
-		var tagsNullable sql.NullString
-
-		err := rows.Scan(&session.ID, &session.StartTime, &session.EndTime, &session.TotalTime, &session.Status, &session.Completed, &tagsNullable)
+		session, err := scanSession(rows)
 		if err != nil {
 			return nil, err
 		}
-
-		if tagsNullable.Valid {
-			session.Tags = tagsNullable.String
-		} else {
-			session.Tags = ""
-		}
-
 		sessions = append(sessions, session)
 	}
 	return sessions, nil
@@ -0,0 +1,240 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Longest notes preview of an orphaned session, in characters
+const orphanPreviewLength = 200
+
+// Session without an owner, left over from before sessions belonged to users
+// or from a deleted user
+type OrphanedSession struct {
+	Session
+	Pomodoros    int    `json:"pomodoros"`
+	NoteCount    int    `json:"note_count"`
+	NotesPreview string `json:"notes_preview"` // Start of the notes, oldest first
+}
+
+// Get all sessions without an owner, newest first
+func GetOrphanedSessions() ([]OrphanedSession, error) {
+	rows, err := db.Query("SELECT " + sessionColumns + `,
+			(SELECT COUNT(*) FROM pomodoros p WHERE p.session_id = sessions.id),
+			(SELECT COUNT(*) FROM notes n WHERE n.session_id = sessions.id),
+			COALESCE((SELECT GROUP_CONCAT(note, ' / ') FROM (
+				SELECT n.note FROM notes n WHERE n.session_id = sessions.id ORDER BY n.created_at, n.id)), '')
+		FROM sessions WHERE user_id IS NULL ORDER BY start_time DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []OrphanedSession{}
+	for rows.Next() {
+		var orphan OrphanedSession
+		orphan.Session, err = scanSession(orphanScanner{rows, []interface{}{&orphan.Pomodoros, &orphan.NoteCount, &orphan.NotesPreview}})
+		if err != nil {
+			return nil, err
+		}
+		if preview := []rune(orphan.NotesPreview); len(preview) > orphanPreviewLength {
+			orphan.NotesPreview = string(preview[:orphanPreviewLength]) + "…"
+		}
+		sessions = append(sessions, orphan)
+	}
+	return sessions, rows.Err()
+}
+
+// Scans a session followed by extra columns
+type orphanScanner struct {
+	row   rowScanner
+	extra []interface{}
+}
+
+func (s orphanScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
+
+// Helper function to build the placeholders and arguments of an IN clause
+func inClause(ids []int) (string, []interface{}) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", "), args
+}
+
+// Give orphaned sessions to a user. Sessions that have an owner are left
+// alone. Links to projects, profiles and tasks of other users are dropped,
+// tags of other users are swapped for the user's own tag of the same name.
+// Returns the number of sessions assigned.
+func AssignOrphanedSessions(ids []int, userID int) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	placeholders, idArgs := inClause(ids)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err = remapOrphanTags(tx, placeholders, idArgs, userID); err != nil {
+		return 0, fmt.Errorf("failed to remap tags: %w", err)
+	}
+
+	// Unlink pomodoros from tasks the new owner can't see
+	_, err = tx.Exec(`UPDATE pomodoros SET task_id = NULL
+		WHERE session_id IN (SELECT id FROM sessions WHERE user_id IS NULL AND id IN (`+placeholders+`))
+		AND task_id NOT IN (SELECT id FROM tasks WHERE user_id = ?)`, append(idArgs, userID)...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to unlink tasks: %w", err)
+	}
+
+	result, err := tx.Exec(`UPDATE sessions SET user_id = ?,
+			project_id = CASE WHEN project_id IN (SELECT id FROM projects WHERE user_id = ?) THEN project_id END,
+			profile_id = CASE WHEN profile_id IN (SELECT id FROM timer_profiles WHERE user_id = ?) THEN profile_id END,
+			version = COALESCE(version, 1) + 1
+		WHERE user_id IS NULL AND id IN (`+placeholders+`)`, append([]interface{}{userID, userID, userID}, idArgs...)...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to assign sessions: %w", err)
+	}
+	assigned, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return assigned, nil
+}
+
+// Point the tag links of the orphaned sessions that use another user's tag
+// at the new owner's tag of the same name, creating it if needed
+func remapOrphanTags(tx *sql.Tx, placeholders string, idArgs []interface{}, userID int) error {
+	rows, err := tx.Query(`SELECT st.id, st.session_id, t.name FROM session_tags st
+		JOIN tags t ON t.id = st.tag_id
+		JOIN sessions s ON s.id = st.session_id
+		WHERE s.user_id IS NULL AND s.id IN (`+placeholders+`) AND t.user_id IS NOT NULL AND t.user_id != ?
+		ORDER BY st.id`, append(idArgs, userID)...)
+	if err != nil {
+		return err
+	}
+
+	type tagLink struct {
+		id        int64
+		sessionID int64
+		name      string
+	}
+	var links []tagLink
+	for rows.Next() {
+		var link tagLink
+		if err := rows.Scan(&link.id, &link.sessionID, &link.name); err != nil {
+			rows.Close()
+			return err
+		}
+		links = append(links, link)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	owner := sql.NullInt64{Int64: int64(userID), Valid: true}
+	for _, link := range links {
+		tagID, err := ensureTag(tx, owner, link.name)
+		if err != nil {
+			return err
+		}
+
+		// The session may carry the tag already
+		_, err = tx.Exec(`UPDATE session_tags SET tag_id = ? WHERE id = ?
+			AND NOT EXISTS (SELECT 1 FROM session_tags WHERE session_id = ? AND tag_id = ?)`,
+			tagID, link.id, link.sessionID, tagID)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec("DELETE FROM session_tags WHERE id = ? AND tag_id != ?", link.id, tagID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete orphaned sessions with all their data. Sessions that have an owner
+// are left alone. Returns the number of sessions deleted.
+func DeleteOrphanedSessions(ids []int) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	placeholders, idArgs := inClause(ids)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.Query("SELECT id FROM sessions WHERE user_id IS NULL AND id IN ("+placeholders+")", idArgs...)
+	if err != nil {
+		return 0, err
+	}
+	var orphans []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		orphans = append(orphans, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range orphans {
+		if err = deleteSessionTx(tx, id); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return int64(len(orphans)), nil
+}
+
+// Get the IDs of all orphaned sessions
+func GetOrphanedSessionIDs() ([]int, error) {
+	rows, err := db.Query("SELECT id FROM sessions WHERE user_id IS NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,415 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"pom/internal/ical"
+)
+
+// Focus block scheduled ahead of time, optionally repeating
+type PlannedSession struct {
+	ID                int      `json:"id"`
+	UserID            int      `json:"user_id"`
+	Title             string   `json:"title"`
+	StartTime         string   `json:"start_time"` // First occurrence
+	Duration          int      `json:"duration"`   // In seconds
+	ExpectedPomodoros int      `json:"expected_pomodoros"`
+	Tags              string   `json:"tags"` // Comma-separated tag list, derived from planned_session_tags
+	ProjectID         *int     `json:"project_id"`
+	Recurrence        *string  `json:"recurrence"` // RRULE value, e.g. FREQ=WEEKLY;BYDAY=MO,WE
+	ExDates           []string `json:"exdates"`    // Starts of occurrences left out of the recurrence
+	Timezone          string   `json:"timezone"`   // Recurrences keep the wall clock time in this zone
+	ICalUID           *string  `json:"ical_uid"`   // UID of the event the plan was imported from
+	CreatedAt         string   `json:"created_at"`
+}
+
+// Reconciliation states of a planned occurrence
+const (
+	PlanStatusDone     = "done"     // All expected pomodoros completed
+	PlanStatusPartial  = "partial"  // Some focus time, but fewer pomodoros than planned
+	PlanStatusMissed   = "missed"   // Nothing happened
+	PlanStatusUpcoming = "upcoming" // Not over yet
+)
+
+// Sessions may start this long before a planned block and still count for it
+const planEarlyStart = 15 * time.Minute
+
+// One occurrence of a plan matched against the sessions that actually ran
+type PlanOccurrence struct {
+	PlanID             int    `json:"plan_id"`
+	Title              string `json:"title"`
+	StartTime          string `json:"start_time"`
+	EndTime            string `json:"end_time"`
+	ExpectedPomodoros  int    `json:"expected_pomodoros"`
+	CompletedPomodoros int    `json:"completed_pomodoros"`
+	FocusTime          int    `json:"focus_time"` // In seconds
+	SessionIDs         []int  `json:"session_ids"`
+	Status             string `json:"status"`
+}
+
+var plannedSessionColumns = "id, user_id, COALESCE(title, ''), start_time, duration, expected_pomodoros, COALESCE(" + tagLinksColumn("planned_session_tags", "plan_id", "planned_sessions.id") + ", ''), project_id, recurrence, COALESCE(exdates, ''), COALESCE(timezone, 'UTC'), ical_uid, created_at"
+
+func scanPlannedSession(row rowScanner) (PlannedSession, error) {
+	var plan PlannedSession
+	var exdates string
+	err := row.Scan(&plan.ID, &plan.UserID, &plan.Title, &plan.StartTime, &plan.Duration, &plan.ExpectedPomodoros,
+		&plan.Tags, &plan.ProjectID, &plan.Recurrence, &exdates, &plan.Timezone, &plan.ICalUID, &plan.CreatedAt)
+	plan.ExDates = []string{}
+	if exdates != "" {
+		plan.ExDates = strings.Split(exdates, ",")
+	}
+	return plan, err
+}
+
+// Helper function to store the excluded occurrences as a comma-separated list
+func joinExDates(exdates []string) *string {
+	if len(exdates) == 0 {
+		return nil
+	}
+	value := strings.Join(exdates, ",")
+	return &value
+}
+
+// Planned session CRUD functions
+
+func CreatePlannedSession(userID int, plan PlannedSession) (int64, error) {
+	if plan.Timezone == "" {
+		plan.Timezone = "UTC"
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	planID, err := insertPlannedSession(tx, userID, plan)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return planID, nil
+}
+
+// Helper function to insert a planned session with its tags within a transaction
+func insertPlannedSession(tx *sql.Tx, userID int, plan PlannedSession) (int64, error) {
+	result, err := tx.Exec(`INSERT INTO planned_sessions(user_id, title, start_time, duration, expected_pomodoros, project_id, recurrence, exdates, timezone, ical_uid)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, plan.Title, plan.StartTime, plan.Duration, plan.ExpectedPomodoros, plan.ProjectID, plan.Recurrence, joinExDates(plan.ExDates), plan.Timezone, plan.ICalUID)
+	if err != nil {
+		return 0, err
+	}
+	planID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := setTagLinks(tx, "planned_session_tags", "plan_id", planID, userID, plan.Tags); err != nil {
+		return 0, err
+	}
+	return planID, nil
+}
+
+func GetPlannedSession(id int) (PlannedSession, error) {
+	row := db.QueryRow("SELECT "+plannedSessionColumns+" FROM planned_sessions WHERE id = ?", id)
+	return scanPlannedSession(row)
+}
+
+func GetPlannedSessionsForUser(userID int) ([]PlannedSession, error) {
+	rows, err := db.Query("SELECT "+plannedSessionColumns+" FROM planned_sessions WHERE user_id = ? ORDER BY start_time", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plans := []PlannedSession{}
+	for rows.Next() {
+		plan, err := scanPlannedSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+	return plans, rows.Err()
+}
+
+func DeletePlannedSession(id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec("DELETE FROM planned_session_tags WHERE plan_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete plan tags: %w", err)
+	}
+	if _, err = tx.Exec("DELETE FROM planned_sessions WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete plan: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Add plans imported from a calendar. Plans whose calendar UID was imported
+// before are updated instead, so importing the same file twice is harmless.
+func ImportPlannedSessions(userID int, plans []PlannedSession) (int, int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	created, updated := 0, 0
+	for _, plan := range plans {
+		if plan.Timezone == "" {
+			plan.Timezone = "UTC"
+		}
+
+		var existingID int64
+		if plan.ICalUID != nil {
+			err = tx.QueryRow("SELECT id FROM planned_sessions WHERE user_id = ? AND ical_uid = ?", userID, *plan.ICalUID).Scan(&existingID)
+			if err != nil && err != sql.ErrNoRows {
+				return 0, 0, err
+			}
+		}
+
+		if existingID != 0 {
+			_, err = tx.Exec(`UPDATE planned_sessions SET title = ?, start_time = ?, duration = ?, expected_pomodoros = ?, recurrence = ?, exdates = ?, timezone = ?
+				WHERE id = ?`,
+				plan.Title, plan.StartTime, plan.Duration, plan.ExpectedPomodoros, plan.Recurrence, joinExDates(plan.ExDates), plan.Timezone, existingID)
+			if err == nil {
+				err = setTagLinks(tx, "planned_session_tags", "plan_id", existingID, userID, plan.Tags)
+			}
+			updated++
+		} else {
+			_, err = insertPlannedSession(tx, userID, plan)
+			created++
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to import plan %q: %w", plan.Title, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return created, updated, nil
+}
+
+// Starts of the occurrences of a plan within [from, to)
+func (p PlannedSession) Occurrences(from, to time.Time) ([]time.Time, error) {
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	start, err := time.Parse(time.RFC3339, p.StartTime)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start time of plan %d: %w", p.ID, err)
+	}
+	start = start.In(loc)
+
+	if p.Recurrence == nil || *p.Recurrence == "" {
+		if start.Before(from) || !start.Before(to) {
+			return nil, nil
+		}
+		return []time.Time{start}, nil
+	}
+
+	rule, err := ical.ParseRule(*p.Recurrence)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recurrence of plan %d: %w", p.ID, err)
+	}
+	for _, value := range p.ExDates {
+		exdate, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid excluded occurrence of plan %d: %w", p.ID, err)
+		}
+		rule.Exclude = append(rule.Exclude, exdate)
+	}
+	return rule.Between(start, from, to), nil
+}
+
+// Helper function to check whether a session counts towards a plan. A plan
+// with a project only takes sessions booked against it, a plan with tags
+// only takes sessions sharing at least one of them. Tags are compared by ID
+// so renames and merges carry over.
+func sessionFitsPlan(session Session, plan PlannedSession, sessionTags map[int]bool, planTags map[int]bool) bool {
+	if plan.ProjectID != nil && (session.ProjectID == nil || *session.ProjectID != *plan.ProjectID) {
+		return false
+	}
+	if len(planTags) == 0 {
+		return true
+	}
+	for tagID := range planTags {
+		if sessionTags[tagID] {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to collect the tag IDs per row of a query returning
+// (row ID, tag ID) pairs
+func queryTagIDs(query string, args ...interface{}) (map[int]map[int]bool, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tagIDs := make(map[int]map[int]bool)
+	for rows.Next() {
+		var id, tagID int
+		if err := rows.Scan(&id, &tagID); err != nil {
+			return nil, err
+		}
+		if tagIDs[id] == nil {
+			tagIDs[id] = make(map[int]bool)
+		}
+		tagIDs[id][tagID] = true
+	}
+	return tagIDs, rows.Err()
+}
+
+// Match the occurrences of a user's plans within [from, to) against the
+// sessions they ran. Every session counts for at most one occurrence, the
+// earliest one it fits.
+func ReconcilePlannedSessions(userID int, from, to time.Time) ([]PlanOccurrence, error) {
+	plans, err := GetPlannedSessionsForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	type occurrence struct {
+		plan  PlannedSession
+		start time.Time
+		end   time.Time
+	}
+	var occurrences []occurrence
+	for _, plan := range plans {
+		starts, err := plan.Occurrences(from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, start := range starts {
+			occurrences = append(occurrences, occurrence{plan, start, start.Add(time.Duration(plan.Duration) * time.Second)})
+		}
+	}
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		return occurrences[i].start.Before(occurrences[j].start)
+	})
+
+	results := []PlanOccurrence{}
+	if len(occurrences) == 0 {
+		return results, nil
+	}
+
+	// Sessions that could fall into any of the occurrences
+	windowStart := occurrences[0].start.Add(-planEarlyStart).UTC().Format("2006-01-02T15:04:05.000Z")
+	windowEnd := occurrences[0].end
+	for _, occ := range occurrences {
+		if occ.end.After(windowEnd) {
+			windowEnd = occ.end
+		}
+	}
+	windowEndStr := windowEnd.UTC().Format("2006-01-02T15:04:05.000Z")
+	rows, err := db.Query("SELECT "+sessionColumns+" FROM sessions WHERE user_id = ? AND start_time >= ? AND start_time < ? ORDER BY start_time",
+		userID, windowStart, windowEndStr)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sessionTags, err := queryTagIDs(`SELECT st.session_id, st.tag_id FROM session_tags st JOIN sessions s ON s.id = st.session_id
+		WHERE s.user_id = ? AND s.start_time >= ? AND s.start_time < ?`, userID, windowStart, windowEndStr)
+	if err != nil {
+		return nil, err
+	}
+	planTags, err := queryTagIDs(`SELECT pt.plan_id, pt.tag_id FROM planned_session_tags pt JOIN planned_sessions p ON p.id = pt.plan_id
+		WHERE p.user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[int]bool)
+	now := time.Now()
+	for _, occ := range occurrences {
+		result := PlanOccurrence{
+			PlanID:            occ.plan.ID,
+			Title:             occ.plan.Title,
+			StartTime:         occ.start.UTC().Format("2006-01-02T15:04:05.000Z"),
+			EndTime:           occ.end.UTC().Format("2006-01-02T15:04:05.000Z"),
+			ExpectedPomodoros: occ.plan.ExpectedPomodoros,
+			SessionIDs:        []int{},
+		}
+
+		for _, session := range sessions {
+			if used[session.ID] {
+				continue
+			}
+			start, err := time.Parse(time.RFC3339, session.StartTime)
+			if err != nil || start.Before(occ.start.Add(-planEarlyStart)) || !start.Before(occ.end) {
+				continue
+			}
+			if !sessionFitsPlan(session, occ.plan, sessionTags[session.ID], planTags[occ.plan.ID]) {
+				continue
+			}
+			used[session.ID] = true
+			result.SessionIDs = append(result.SessionIDs, session.ID)
+			result.CompletedPomodoros += session.Completed
+			result.FocusTime += session.TotalTime
+		}
+
+		switch {
+		case result.CompletedPomodoros >= result.ExpectedPomodoros:
+			result.Status = PlanStatusDone
+		case occ.end.After(now):
+			result.Status = PlanStatusUpcoming
+		case len(result.SessionIDs) > 0:
+			result.Status = PlanStatusPartial
+		default:
+			result.Status = PlanStatusMissed
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,156 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Timer profile describing the length of a pomodoro cycle
+type TimerProfile struct {
+	ID                 int     `json:"id"`
+	UserID             int     `json:"user_id"`
+	Name               string  `json:"name"`
+	WorkLength         int     `json:"work_length"`        // In seconds
+	ShortBreakLength   int     `json:"short_break_length"` // In seconds
+	LongBreakLength    int     `json:"long_break_length"`  // In seconds
+	LongBreakEvery     int     `json:"long_break_every"`   // Pomodoros before a long break
+	AutoStartBreaks    bool    `json:"auto_start_breaks"`
+	AutoStartPomodoros bool    `json:"auto_start_pomodoros"`
+	IsDefault          bool    `json:"is_default"`
+	FlowBreakRatio     float64 `json:"flow_break_ratio"` // Break length as a fraction of flowtime focus
+	CreatedAt          string  `json:"created_at"`
+}
+
+const timerProfileColumns = "id, user_id, name, work_length, short_break_length, long_break_length, long_break_every, auto_start_breaks, auto_start_pomodoros, is_default, flow_break_ratio, created_at"
+
+func scanTimerProfile(row rowScanner) (TimerProfile, error) {
+	var profile TimerProfile
+	err := row.Scan(&profile.ID, &profile.UserID, &profile.Name, &profile.WorkLength, &profile.ShortBreakLength, &profile.LongBreakLength,
+		&profile.LongBreakEvery, &profile.AutoStartBreaks, &profile.AutoStartPomodoros, &profile.IsDefault, &profile.FlowBreakRatio, &profile.CreatedAt)
+	return profile, err
+}
+
+// Timer profile CRUD functions
+
+// Create a profile of a user, it becomes the user's default profile in the
+// same transaction if IsDefault is set
+func CreateTimerProfile(userID int, profile TimerProfile) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	result, err := tx.Exec(`INSERT INTO timer_profiles(user_id, name, work_length, short_break_length, long_break_length,
+		long_break_every, auto_start_breaks, auto_start_pomodoros, flow_break_ratio) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, profile.Name, profile.WorkLength, profile.ShortBreakLength, profile.LongBreakLength,
+		profile.LongBreakEvery, profile.AutoStartBreaks, profile.AutoStartPomodoros, profile.FlowBreakRatio)
+	if err != nil {
+		return 0, err
+	}
+	profileID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if profile.IsDefault {
+		if err = setDefaultTimerProfile(tx, userID, int(profileID)); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return profileID, nil
+}
+
+func GetTimerProfile(id int) (TimerProfile, error) {
+	row := db.QueryRow("SELECT "+timerProfileColumns+" FROM timer_profiles WHERE id = ?", id)
+	return scanTimerProfile(row)
+}
+
+func GetTimerProfilesForUser(userID int) ([]TimerProfile, error) {
+	rows, err := db.Query("SELECT "+timerProfileColumns+" FROM timer_profiles WHERE user_id = ? ORDER BY name", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var profiles []TimerProfile
+	for rows.Next() {
+		profile, err := scanTimerProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+// Get the default profile of a user, returns sql.ErrNoRows if none is set
+func GetDefaultTimerProfile(userID int) (TimerProfile, error) {
+	row := db.QueryRow("SELECT "+timerProfileColumns+" FROM timer_profiles WHERE user_id = ? AND is_default = 1", userID)
+	return scanTimerProfile(row)
+}
+
+func UpdateTimerProfile(id int, profile TimerProfile) error {
+	statement, err := db.Prepare(`UPDATE timer_profiles SET name = ?, work_length = ?, short_break_length = ?, long_break_length = ?,
+		long_break_every = ?, auto_start_breaks = ?, auto_start_pomodoros = ?, flow_break_ratio = ? WHERE id = ?`)
+	if err != nil {
+		return err
+	}
+	_, err = statement.Exec(profile.Name, profile.WorkLength, profile.ShortBreakLength, profile.LongBreakLength,
+		profile.LongBreakEvery, profile.AutoStartBreaks, profile.AutoStartPomodoros, profile.FlowBreakRatio, id)
+	return err
+}
+
+func DeleteTimerProfile(id int) error {
+	_, err := db.Exec("DELETE FROM timer_profiles WHERE id = ?", id)
+	return err
+}
+
+// Make a profile the default of its owner, clearing any previous default
+func SetDefaultTimerProfile(userID int, id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err = setDefaultTimerProfile(tx, userID, id); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Helper function to make a profile the only default profile of its user
+func setDefaultTimerProfile(tx *sql.Tx, userID int, id int) error {
+	_, err := tx.Exec("UPDATE timer_profiles SET is_default = 0 WHERE user_id = ?", userID)
+	if err != nil {
+		return fmt.Errorf("failed to clear default profile: %w", err)
+	}
+
+	_, err = tx.Exec("UPDATE timer_profiles SET is_default = 1 WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to set default profile: %w", err)
+	}
+
+	return nil
+}
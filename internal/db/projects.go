@@ -0,0 +1,161 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Project a session can be booked against
+type Project struct {
+	ID          int     `json:"id"`
+	UserID      int     `json:"user_id"`
+	Name        string  `json:"name"`
+	Color       string  `json:"color"`
+	Archived    bool    `json:"archived"`
+	Client      *string `json:"client"`
+	Description *string `json:"description"`
+	CreatedAt   string  `json:"created_at"`
+}
+
+// Time booked against a project
+type ProjectStats struct {
+	ProjectID *int   `json:"project_id"` // nil for sessions without a project
+	Name      string `json:"name"`
+	Color     string `json:"color"`
+	Sessions  int    `json:"sessions"`
+	Pomodoros int    `json:"pomodoros"`
+	TotalTime int    `json:"total_time"` // In seconds
+}
+
+const projectColumns = "id, user_id, name, color, archived, client, description, created_at"
+
+func scanProject(row rowScanner) (Project, error) {
+	var project Project
+	var color sql.NullString
+	err := row.Scan(&project.ID, &project.UserID, &project.Name, &color, &project.Archived, &project.Client, &project.Description, &project.CreatedAt)
+	project.Color = color.String
+	return project, err
+}
+
+// Project CRUD functions
+
+func CreateProject(userID int, project Project) (int64, error) {
+	if project.Color == "" {
+		project.Color = getRandomColor()
+	}
+
+	statement, err := db.Prepare("INSERT INTO projects(user_id, name, color, archived, client, description) VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return 0, err
+	}
+	result, err := statement.Exec(userID, project.Name, project.Color, project.Archived, project.Client, project.Description)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func GetProject(id int) (Project, error) {
+	row := db.QueryRow("SELECT "+projectColumns+" FROM projects WHERE id = ?", id)
+	return scanProject(row)
+}
+
+// Get the projects of a user, archived ones only if requested
+func GetProjectsForUser(userID int, includeArchived bool) ([]Project, error) {
+	query := "SELECT " + projectColumns + " FROM projects WHERE user_id = ?"
+	if !includeArchived {
+		query += " AND archived = 0"
+	}
+	query += " ORDER BY name"
+
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var projects []Project
+	for rows.Next() {
+		project, err := scanProject(rows)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, project)
+	}
+	return projects, nil
+}
+
+func UpdateProject(id int, project Project) error {
+	statement, err := db.Prepare("UPDATE projects SET name = ?, color = ?, archived = ?, client = ?, description = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	_, err = statement.Exec(project.Name, project.Color, project.Archived, project.Client, project.Description, id)
+	return err
+}
+
+// Delete a project, its sessions are kept without a project
+func DeleteProject(id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	_, err = tx.Exec("UPDATE sessions SET project_id = NULL WHERE project_id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to detach sessions: %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM projects WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete project: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Get the time a user booked per project between two dates
+func GetProjectStatsForUser(userID int, startDate string, endDate string) ([]ProjectStats, error) {
+	query := `
+		SELECT s.project_id, COALESCE(p.name, 'No project'), COALESCE(p.color, ''),
+			COUNT(*), COALESCE(SUM(s.completed_pomodoros), 0), COALESCE(SUM(s.total_time), 0)
+		FROM sessions s
+		LEFT JOIN projects p ON p.id = s.project_id
+		WHERE s.user_id = ?
+		AND s.start_time >= ? AND s.start_time <= ?
+		AND s.status IN ('completed', 'stopped')
+		GROUP BY s.project_id
+		ORDER BY SUM(s.total_time) DESC
+	`
+	rows, err := db.Query(query, userID, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []ProjectStats
+	for rows.Next() {
+		var stat ProjectStats
+		var projectID sql.NullInt64
+		err := rows.Scan(&projectID, &stat.Name, &stat.Color, &stat.Sessions, &stat.Pomodoros, &stat.TotalTime)
+		if err != nil {
+			return nil, err
+		}
+		if projectID.Valid {
+			id := int(projectID.Int64)
+			stat.ProjectID = &id
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// A note can't be restored once its session has been deleted
+var ErrNoteSessionDeleted = errors.New("the session of the note has been deleted")
+
+// Actions recorded in note_revisions
+const (
+	NoteRevisionCreate  = "create"
+	NoteRevisionUpdate  = "update"
+	NoteRevisionDelete  = "delete"
+	NoteRevisionRestore = "restore"
+)
+
+// Snapshot of a note taken every time it changes. Revisions record the owner
+// of the note's session and are kept when the session is deleted.
+type NoteRevision struct {
+	ID         int    `json:"id"`
+	NoteID     int    `json:"note_id"`
+	SessionID  int    `json:"session_id"`
+	PomodoroID int    `json:"pomodoro_id"`
+	NoteText   string `json:"note"`
+	Action     string `json:"action"` // "create", "update", "delete" or "restore"
+	CreatedAt  string `json:"created_at"`
+}
+
+const noteRevisionColumns = "id, note_id, COALESCE(session_id, 0), COALESCE(pomodoro_id, 0), COALESCE(note, ''), action, created_at"
+
+func scanNoteRevision(row rowScanner) (NoteRevision, error) {
+	var revision NoteRevision
+	err := row.Scan(&revision.ID, &revision.NoteID, &revision.SessionID, &revision.PomodoroID, &revision.NoteText,
+		&revision.Action, &revision.CreatedAt)
+	return revision, err
+}
+
+// Helper function to snapshot the current state of a note
+func recordNoteRevision(tx *sql.Tx, noteID int64, action string) error {
+	result, err := tx.Exec(`INSERT INTO note_revisions(note_id, session_id, pomodoro_id, note, action, user_id)
+		SELECT n.id, n.session_id, n.pomodoro_id, n.note, ?, s.user_id
+		FROM notes n LEFT JOIN sessions s ON s.id = n.session_id
+		WHERE n.id = ?`, action, noteID)
+	if err != nil {
+		return fmt.Errorf("failed to record note revision: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Give notes written before revisions existed their first revision, and
+// revisions recorded before owners were their owner
+func migrateNoteRevisions() {
+	_, err := db.Exec(`UPDATE note_revisions SET user_id = (SELECT user_id FROM sessions WHERE id = note_revisions.session_id)
+		WHERE user_id IS NULL AND session_id IN (SELECT id FROM sessions WHERE user_id IS NOT NULL)`)
+	if err != nil {
+		log.Printf("Error setting note revision owners: %v\n", err)
+	}
+
+	result, err := db.Exec(`INSERT INTO note_revisions(note_id, session_id, pomodoro_id, note, action, created_at, user_id)
+		SELECT n.id, n.session_id, n.pomodoro_id, n.note, ?, n.created_at, s.user_id
+		FROM notes n LEFT JOIN sessions s ON s.id = n.session_id
+		WHERE n.id NOT IN (SELECT note_id FROM note_revisions)`, NoteRevisionCreate)
+	if err != nil {
+		log.Printf("Error creating initial note revisions: %v\n", err)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		log.Printf("Created initial revisions for %d notes\n", affected)
+	}
+}
+
+// Get the revisions of a note, oldest first. Works for deleted notes too.
+func GetNoteRevisions(noteID int) ([]NoteRevision, error) {
+	rows, err := db.Query("SELECT "+noteRevisionColumns+" FROM note_revisions WHERE note_id = ? ORDER BY id", noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []NoteRevision{}
+	for rows.Next() {
+		revision, err := scanNoteRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+// Get the session of a note and its owner from the latest revision, so
+// deleted notes and notes of deleted sessions resolve too. The owner is nil
+// for notes of sessions without one.
+func GetNoteRevisionOwner(noteID int) (int, *int, error) {
+	var sessionID int
+	var ownerID *int
+	err := db.QueryRow("SELECT COALESCE(session_id, 0), user_id FROM note_revisions WHERE note_id = ? ORDER BY id DESC LIMIT 1", noteID).Scan(&sessionID, &ownerID)
+	return sessionID, ownerID, err
+}
+
+// Get a single revision of a note
+func GetNoteRevision(noteID int, revisionID int) (NoteRevision, error) {
+	row := db.QueryRow("SELECT "+noteRevisionColumns+" FROM note_revisions WHERE id = ? AND note_id = ?", revisionID, noteID)
+	return scanNoteRevision(row)
+}
+
+// Bring a note back to the text of one of its revisions. A deleted note is
+// recreated under its old ID.
+func RestoreNoteRevision(noteID int, revisionID int) error {
+	revision, err := GetNoteRevision(noteID, revisionID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	result, err := tx.Exec("UPDATE notes SET note = ?, version = COALESCE(version, 1) + 1 WHERE id = ?", revision.NoteText, noteID)
+	if err != nil {
+		return fmt.Errorf("failed to restore note: %w", err)
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		// The note was deleted, recreate it with its original creation time
+		// unless its session is gone too
+		var sessions int
+		if err = tx.QueryRow("SELECT COUNT(*) FROM sessions WHERE id = ?", revision.SessionID).Scan(&sessions); err != nil {
+			return err
+		}
+		if sessions == 0 {
+			err = ErrNoteSessionDeleted
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO notes(id, session_id, pomodoro_id, note, created_at)
+			SELECT note_id, session_id, pomodoro_id, ?, created_at FROM note_revisions
+			WHERE note_id = ? ORDER BY id LIMIT 1`, revision.NoteText, noteID)
+		if err != nil {
+			return fmt.Errorf("failed to undelete note: %w", err)
+		}
+	}
+
+	if err = recordNoteRevision(tx, int64(noteID), NoteRevisionRestore); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,287 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+)
+
+// Whether the notes_fts index could be set up. SQLite only ships FTS5 when
+// the binary is built with the sqlite_fts5 tag, without it search falls back
+// to plain substring matching.
+var notesSearchAvailable bool
+
+// Triggers keeping notes_fts in sync with the notes table
+var notesSearchTriggers = map[string]string{
+	"notes_fts_ai": `
+        CREATE TRIGGER IF NOT EXISTS notes_fts_ai AFTER INSERT ON notes BEGIN
+            INSERT INTO notes_fts(rowid, note) VALUES (new.id, new.note);
+        END`,
+	"notes_fts_ad": `
+        CREATE TRIGGER IF NOT EXISTS notes_fts_ad AFTER DELETE ON notes BEGIN
+            INSERT INTO notes_fts(notes_fts, rowid, note) VALUES ('delete', old.id, old.note);
+        END`,
+	"notes_fts_au": `
+        CREATE TRIGGER IF NOT EXISTS notes_fts_au AFTER UPDATE OF note ON notes BEGIN
+            INSERT INTO notes_fts(notes_fts, rowid, note) VALUES ('delete', old.id, old.note);
+            INSERT INTO notes_fts(rowid, note) VALUES (new.id, new.note);
+        END`,
+}
+
+// Create the full-text index over notes and its triggers
+func setupNotesSearch() {
+	// The index is rebuilt whenever the triggers were missing, either on the
+	// first run or after running a build without FTS5
+	var triggers int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'notes_fts_%'").Scan(&triggers)
+	if err != nil {
+		log.Printf("Error checking notes search triggers: %v\n", err)
+		return
+	}
+
+	// An existing notes_fts table doesn't tell whether this binary has FTS5
+	var enabled bool
+	err = db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled)
+	if err == nil && !enabled {
+		err = errors.New("FTS5 is not compiled in")
+	}
+	if err == nil {
+		_, err = db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS notes_fts USING fts5(note, content='notes', content_rowid='id')")
+	}
+	if err != nil {
+		log.Printf("Full-text search disabled, build with -tags sqlite_fts5 to enable it: %v\n", err)
+
+		// Triggers left behind by an FTS5 build would make every note write fail
+		for name := range notesSearchTriggers {
+			if _, err := db.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+				log.Printf("Error dropping %s trigger: %v\n", name, err)
+			}
+		}
+		return
+	}
+
+	for name, query := range notesSearchTriggers {
+		if _, err := db.Exec(query); err != nil {
+			log.Printf("Error creating %s trigger: %v\n", name, err)
+			return
+		}
+	}
+
+	if triggers < len(notesSearchTriggers) {
+		log.Println("Rebuilding notes search index...")
+		if _, err := db.Exec("INSERT INTO notes_fts(notes_fts) VALUES ('rebuild')"); err != nil {
+			log.Printf("Error rebuilding notes search index: %v\n", err)
+			return
+		}
+	}
+
+	notesSearchAvailable = true
+}
+
+// Search options for notes, dates are inclusive YYYY-MM-DD bounds
+type NoteSearchOptions struct {
+	Query  string
+	From   string
+	To     string
+	Tags   []string // Sessions must carry every tag, or one of its descendants
+	Limit  int
+	Offset int
+}
+
+// Control characters marking matches in snippets until the text around them
+// has been HTML escaped
+const (
+	snippetMatchStart = "\x02"
+	snippetMatchEnd   = "\x03"
+)
+
+// Words of context around the first match in fallback snippets
+const snippetWords = 16
+
+// Note matching a search, with the matched terms highlighted in the snippet.
+// The snippet is HTML, the note text is escaped.
+type NoteSearchResult struct {
+	Note
+	Snippet          string  `json:"snippet"`
+	Rank             float64 `json:"rank"` // Lower is a better match
+	SessionStartTime string  `json:"session_start_time"`
+	SessionTags      string  `json:"session_tags"`
+}
+
+// Turn free text into an FTS5 query, every word has to appear in the note.
+// Words are quoted so that punctuation can't break the query syntax, a
+// trailing * keeps working as a prefix search.
+func buildNotesMatchQuery(query string) string {
+	var terms []string
+	for _, word := range strings.Fields(query) {
+		prefix := strings.HasSuffix(word, "*")
+		word = strings.TrimRight(word, "*")
+		if word == "" {
+			continue
+		}
+		term := `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+		if prefix {
+			term += "*"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " ")
+}
+
+// Search the notes of a user's sessions. Returns one page of results and the
+// total number of matches.
+func SearchNotes(userID int, opts NoteSearchOptions) ([]NoteSearchResult, int, error) {
+	conditions := []string{"sessions.user_id = ?"}
+	args := []interface{}{userID}
+
+	if opts.From != "" {
+		conditions = append(conditions, "date(notes.created_at) >= ?")
+		args = append(args, opts.From)
+	}
+	if opts.To != "" {
+		conditions = append(conditions, "date(notes.created_at) <= ?")
+		args = append(args, opts.To)
+	}
+	for _, tag := range opts.Tags {
+		conditions = append(conditions, sessionHasTagTree)
+		args = append(args, tag, userID, userID)
+	}
+
+	var from, snippet, rank, order string
+	var likeWords []string
+	if notesSearchAvailable {
+		match := buildNotesMatchQuery(opts.Query)
+		if match == "" {
+			return []NoteSearchResult{}, 0, nil
+		}
+		from = "notes_fts JOIN notes ON notes.id = notes_fts.rowid"
+		conditions = append(conditions, "notes_fts MATCH ?")
+		args = append(args, match)
+		snippet = fmt.Sprintf("snippet(notes_fts, 0, char(2), char(3), '…', %d)", snippetWords)
+		rank = "bm25(notes_fts)"
+		order = "rank, notes.created_at DESC"
+	} else {
+		for _, word := range strings.Fields(opts.Query) {
+			if word = strings.Trim(word, "*"); word != "" {
+				likeWords = append(likeWords, word)
+			}
+		}
+		if len(likeWords) == 0 {
+			return []NoteSearchResult{}, 0, nil
+		}
+		from = "notes"
+		for _, word := range likeWords {
+			conditions = append(conditions, `notes.note LIKE ? ESCAPE '\'`)
+			args = append(args, "%"+likeEscaper.Replace(word)+"%")
+		}
+		// Built from the note below
+		snippet = "''"
+		rank = "0"
+		order = "notes.created_at DESC"
+	}
+
+	where := strings.Join(conditions, " AND ")
+
+	var total int
+	countQuery := "SELECT COUNT(*) FROM " + from + " JOIN sessions ON sessions.id = notes.session_id WHERE " + where
+	if err := db.QueryRow(countQuery, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count notes: %w", err)
+	}
+
+	query := `
+		SELECT notes.id, notes.session_id, COALESCE(notes.pomodoro_id, 0), COALESCE(notes.note, ''), notes.created_at, COALESCE(notes.version, 1),
+			` + snippet + `, ` + rank + ` AS rank, sessions.start_time, ` + sessionTagsColumn + `
+		FROM ` + from + `
+		JOIN sessions ON sessions.id = notes.session_id
+		WHERE ` + where + `
+		ORDER BY ` + order + `
+		LIMIT ? OFFSET ?
+	`
+	rows, err := db.Query(query, append(args, opts.Limit, opts.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search notes: %w", err)
+	}
+	defer rows.Close()
+
+	results := []NoteSearchResult{}
+	for rows.Next() {
+		var result NoteSearchResult
+		var tags *string
+		err := rows.Scan(&result.ID, &result.SessionID, &result.PomodoroID, &result.NoteText, &result.CreatedAt, &result.Version,
+			&result.Snippet, &result.Rank, &result.SessionStartTime, &tags)
+		if err != nil {
+			return nil, 0, err
+		}
+		if tags != nil {
+			result.SessionTags = *tags
+		}
+		if notesSearchAvailable {
+			result.Snippet = markSnippet(result.Snippet)
+		} else {
+			result.Snippet = likeSnippet(result.NoteText, likeWords)
+		}
+		results = append(results, result)
+	}
+	return results, total, rows.Err()
+}
+
+// Escapes the LIKE wildcards in a search word, for use with ESCAPE '\'
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Helper function to HTML escape a snippet from notes_fts and turn its match
+// markers into <mark> elements
+func markSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, snippetMatchStart, "<mark>")
+	return strings.ReplaceAll(snippet, snippetMatchEnd, "</mark>")
+}
+
+// Helper function to build a snippet without FTS5: the words around the
+// first match, with the words containing a search word marked
+func likeSnippet(text string, words []string) string {
+	lowerWords := make([]string, len(words))
+	for i, word := range words {
+		lowerWords[i] = strings.ToLower(word)
+	}
+	matches := func(field string) bool {
+		field = strings.ToLower(field)
+		for _, word := range lowerWords {
+			if strings.Contains(field, word) {
+				return true
+			}
+		}
+		return false
+	}
+
+	fields := strings.Fields(text)
+	first := 0
+	for i, field := range fields {
+		if matches(field) {
+			first = i
+			break
+		}
+	}
+	start := max(first-snippetWords/4, 0)
+	end := min(start+snippetWords, len(fields))
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i, field := range fields[start:end] {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		if matches(field) {
+			b.WriteString("<mark>" + html.EscapeString(field) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(field))
+		}
+	}
+	if end < len(fields) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
@@ -0,0 +1,290 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Piece of work estimated in pomodoros
+type Task struct {
+	ID          int     `json:"id"`
+	UserID      int     `json:"user_id"`
+	Title       string  `json:"title"`
+	Estimate    int     `json:"estimate"` // In pomodoros
+	Status      string  `json:"status"`
+	DueDate     *string `json:"due_date"` // YYYY-MM-DD
+	Tags        string  `json:"tags"`     // Comma-separated tag list, derived from task_tags
+	ProjectID   *int    `json:"project_id"`
+	CreatedAt   string  `json:"created_at"`
+	CompletedAt *string `json:"completed_at"`
+
+	// Completed pomodoros spent on the task and their total length
+	ActualPomodoros int `json:"actual_pomodoros"`
+	TimeSpent       int `json:"time_spent"` // In seconds
+}
+
+// Task statuses
+const (
+	TaskStatusTodo       = "todo"
+	TaskStatusInProgress = "in_progress"
+	TaskStatusDone       = "done"
+	TaskStatusCancelled  = "cancelled"
+)
+
+// Estimates against actuals of the tasks finished in one period
+type TaskEstimatePeriod struct {
+	Period          string  `json:"period"` // e.g. 2025-W10 or 2025-03
+	Tasks           int     `json:"tasks"`
+	Estimated       int     `json:"estimated"` // In pomodoros
+	Actual          int     `json:"actual"`    // In pomodoros
+	Ratio           float64 `json:"ratio"`     // Actual over estimated, above 1 means underestimated
+	Underestimated  int     `json:"underestimated"`
+	Overestimated   int     `json:"overestimated"`
+	OnTarget        int     `json:"on_target"`
+	AverageAccuracy float64 `json:"average_accuracy"` // Mean of min(estimate, actual) / max(estimate, actual)
+}
+
+var taskColumns = `t.id, t.user_id, t.title, t.estimate, COALESCE(t.status, 'todo'), t.due_date, COALESCE(` + tagLinksColumn("task_tags", "task_id", "t.id") + `, ''), t.project_id, t.created_at, t.completed_at,
+	(SELECT COUNT(*) FROM pomodoros p WHERE p.task_id = t.id AND p.status = 'completed'),
+	(SELECT COALESCE(SUM(p.duration), 0) FROM pomodoros p WHERE p.task_id = t.id AND p.status = 'completed')`
+
+func scanTask(row rowScanner) (Task, error) {
+	var task Task
+	err := row.Scan(&task.ID, &task.UserID, &task.Title, &task.Estimate, &task.Status, &task.DueDate, &task.Tags, &task.ProjectID,
+		&task.CreatedAt, &task.CompletedAt, &task.ActualPomodoros, &task.TimeSpent)
+	return task, err
+}
+
+// Task CRUD functions
+
+func CreateTask(userID int, task Task) (int64, error) {
+	if task.Status == "" {
+		task.Status = TaskStatusTodo
+	}
+	var completedAt interface{} = nil
+	if task.Status == TaskStatusDone {
+		completedAt = time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	result, err := tx.Exec("INSERT INTO tasks(user_id, title, estimate, status, due_date, project_id, completed_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		userID, task.Title, task.Estimate, task.Status, task.DueDate, task.ProjectID, completedAt)
+	if err != nil {
+		return 0, err
+	}
+	taskID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if err = setTagLinks(tx, "task_tags", "task_id", taskID, userID, task.Tags); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return taskID, nil
+}
+
+func GetTask(id int) (Task, error) {
+	row := db.QueryRow("SELECT "+taskColumns+" FROM tasks t WHERE t.id = ?", id)
+	return scanTask(row)
+}
+
+// Get the tasks of a user, only those with the given status if it isn't empty
+func GetTasksForUser(userID int, status string) ([]Task, error) {
+	query := "SELECT " + taskColumns + " FROM tasks t WHERE t.user_id = ?"
+	args := []interface{}{userID}
+	if status != "" {
+		query += " AND COALESCE(t.status, 'todo') = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY t.due_date IS NULL, t.due_date, t.id"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := []Task{}
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+// Update a task. Finishing it records when, reopening it clears that again.
+func UpdateTask(id int, task Task) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	_, err = tx.Exec(`UPDATE tasks SET title = ?, estimate = ?, status = ?, due_date = ?, project_id = ?,
+			completed_at = CASE
+				WHEN ? != 'done' THEN NULL
+				WHEN COALESCE(status, 'todo') = 'done' THEN completed_at
+				ELSE ?
+			END
+		WHERE id = ?`,
+		task.Title, task.Estimate, task.Status, task.DueDate, task.ProjectID,
+		task.Status, time.Now().UTC().Format("2006-01-02T15:04:05.000Z"), id)
+	if err != nil {
+		return err
+	}
+
+	var ownerID int
+	if err = tx.QueryRow("SELECT user_id FROM tasks WHERE id = ?", id).Scan(&ownerID); err != nil {
+		return err
+	}
+	if err = setTagLinks(tx, "task_tags", "task_id", int64(id), ownerID, task.Tags); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Delete a task, its pomodoros are kept without a task
+func DeleteTask(id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	_, err = tx.Exec("UPDATE pomodoros SET task_id = NULL WHERE task_id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to detach pomodoros: %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM task_tags WHERE task_id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete task tags: %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM tasks WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete task: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Get the tasks a user finished between two dates, oldest first
+func GetCompletedTasksForUser(userID int, startDate string, endDate string) ([]Task, error) {
+	rows, err := db.Query("SELECT "+taskColumns+` FROM tasks t
+		WHERE t.user_id = ? AND t.status = 'done' AND t.completed_at >= ? AND t.completed_at <= ?
+		ORDER BY t.completed_at`, userID, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := []Task{}
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+// Compare estimates with actual pomodoros for finished tasks, grouped by the
+// week or month they were finished in
+func SummarizeTaskEstimates(tasks []Task, period string) []TaskEstimatePeriod {
+	periods := []TaskEstimatePeriod{}
+	index := make(map[string]int)
+	accuracy := make(map[string]float64)
+
+	for _, task := range tasks {
+		if task.CompletedAt == nil {
+			continue
+		}
+		completed, err := time.Parse(time.RFC3339, *task.CompletedAt)
+		if err != nil {
+			continue
+		}
+
+		key := completed.Format("2006-01")
+		if period == "week" {
+			year, week := completed.ISOWeek()
+			key = fmt.Sprintf("%04d-W%02d", year, week)
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(periods)
+			index[key] = i
+			periods = append(periods, TaskEstimatePeriod{Period: key})
+		}
+
+		p := &periods[i]
+		p.Tasks++
+		p.Estimated += task.Estimate
+		p.Actual += task.ActualPomodoros
+		switch {
+		case task.ActualPomodoros > task.Estimate:
+			p.Underestimated++
+		case task.ActualPomodoros < task.Estimate:
+			p.Overestimated++
+		default:
+			p.OnTarget++
+		}
+
+		low, high := task.Estimate, task.ActualPomodoros
+		if low > high {
+			low, high = high, low
+		}
+		if high > 0 {
+			accuracy[key] += float64(low) / float64(high)
+		} else {
+			accuracy[key]++
+		}
+	}
+
+	for i := range periods {
+		p := &periods[i]
+		if p.Estimated > 0 {
+			p.Ratio = float64(p.Actual) / float64(p.Estimated)
+		}
+		p.AverageAccuracy = accuracy[p.Period] / float64(p.Tasks)
+	}
+	return periods
+}
@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// Saved state of a user's server-side timer, so that a running timer
+// survives a restart. The state itself is encoded by the timer package.
+type TimerState struct {
+	UserID    int
+	SessionID int
+	State     string
+}
+
+// Save the timer of a user, replacing the previous state
+func SaveTimerState(userID int, sessionID int, state string) error {
+	_, err := db.Exec("INSERT OR REPLACE INTO timer_states(user_id, session_id, state, updated_at) VALUES (?, ?, ?, ?)",
+		userID, sessionID, state, time.Now().UTC().Format("2006-01-02T15:04:05.000Z"))
+	return err
+}
+
+func GetTimerStates() ([]TimerState, error) {
+	rows, err := db.Query("SELECT user_id, session_id, state FROM timer_states")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := []TimerState{}
+	for rows.Next() {
+		var state TimerState
+		if err := rows.Scan(&state.UserID, &state.SessionID, &state.State); err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, rows.Err()
+}
+
+func DeleteTimerState(userID int) error {
+	_, err := db.Exec("DELETE FROM timer_states WHERE user_id = ?", userID)
+	return err
+}
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Marks personal access tokens, telling them apart from JWTs
+const AccessTokenPrefix = "pom_"
+
+// Returned for access tokens that expired or whose user is no longer active
+var ErrAccessTokenInvalid = errors.New("the access token has expired or its account is inactive")
+
+// Named token a user's scripts and editor plugins authenticate with. Only a
+// hash of the token is stored, the token itself is shown once on creation.
+type AccessToken struct {
+	ID         int      `json:"id"`
+	UserID     int      `json:"user_id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"` // Start of the token, to recognise it by
+	Scopes     []string `json:"scopes"`
+	ExpiresAt  *string  `json:"expires_at"`
+	LastUsedAt *string  `json:"last_used_at"`
+	CreatedAt  string   `json:"created_at"`
+}
+
+// Access token scopes
+const (
+	ScopeFull  = "full"  // Everything the user can do
+	ScopeRead  = "read"  // Only reading requests
+	ScopeTimer = "timer" // Only the timer endpoints
+)
+
+// Helper function to hash an access token for storage and lookup
+func hashAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+const accessTokenColumns = "id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at"
+
+func scanAccessToken(row rowScanner) (AccessToken, error) {
+	var token AccessToken
+	var scopes string
+	err := row.Scan(&token.ID, &token.UserID, &token.Name, &token.Prefix, &scopes, &token.ExpiresAt, &token.LastUsedAt, &token.CreatedAt)
+	token.Scopes = strings.Split(scopes, ",")
+	return token, err
+}
+
+// Check whether a token has passed its expiry date
+func (t AccessToken) Expired(now time.Time) bool {
+	if t.ExpiresAt == nil {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, *t.ExpiresAt)
+	return err != nil || !now.Before(expiresAt)
+}
+
+// Access token CRUD functions
+
+// Create an access token, returning it along with the token itself
+func CreateAccessToken(userID int, name string, scopes []string, expiresAt *string) (AccessToken, string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return AccessToken{}, "", err
+	}
+	secret := AccessTokenPrefix + hex.EncodeToString(bytes)
+
+	result, err := db.Exec("INSERT INTO access_tokens(user_id, name, token_hash, prefix, scopes, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		userID, name, hashAccessToken(secret), secret[:len(AccessTokenPrefix)+8], strings.Join(scopes, ","), expiresAt)
+	if err != nil {
+		return AccessToken{}, "", err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return AccessToken{}, "", err
+	}
+
+	token, err := GetAccessToken(int(id))
+	return token, secret, err
+}
+
+func GetAccessToken(id int) (AccessToken, error) {
+	row := db.QueryRow("SELECT "+accessTokenColumns+" FROM access_tokens WHERE id = ?", id)
+	return scanAccessToken(row)
+}
+
+func GetAccessTokensForUser(userID int) ([]AccessToken, error) {
+	rows, err := db.Query("SELECT "+accessTokenColumns+" FROM access_tokens WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []AccessToken{}
+	for rows.Next() {
+		token, err := scanAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func DeleteAccessToken(id int) error {
+	_, err := db.Exec("DELETE FROM access_tokens WHERE id = ?", id)
+	return err
+}
+
+// Revoke every access token of a user
+func DeleteAccessTokensForUser(userID int) error {
+	_, err := db.Exec("DELETE FROM access_tokens WHERE user_id = ?", userID)
+	return err
+}
+
+// Get the active user an unexpired access token belongs to, recording that
+// the token was used
+func GetUserByAccessToken(secret string) (User, AccessToken, error) {
+	token, err := scanAccessToken(db.QueryRow("SELECT "+accessTokenColumns+" FROM access_tokens WHERE token_hash = ?", hashAccessToken(secret)))
+	if err != nil {
+		return User{}, AccessToken{}, err
+	}
+	if token.Expired(time.Now()) {
+		return User{}, AccessToken{}, ErrAccessTokenInvalid
+	}
+
+	user, err := GetUserByID(token.UserID)
+	if err != nil {
+		return User{}, AccessToken{}, err
+	}
+	if user.AccountStatus != "active" {
+		return User{}, AccessToken{}, ErrAccessTokenInvalid
+	}
+
+	now := time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+	if _, err := db.Exec("UPDATE access_tokens SET last_used_at = ? WHERE id = ?", now, token.ID); err == nil {
+		token.LastUsedAt = &now
+	}
+	return user, token, nil
+}
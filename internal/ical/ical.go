@@ -0,0 +1,114 @@
+package ical
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Layout of UTC date-times in iCalendar (RFC 5545 section 3.3.5)
+const dateTimeFormat = "20060102T150405Z"
+
+// Lines longer than this many octets are folded (RFC 5545 section 3.1)
+const maxLineLength = 75
+
+// Event of a calendar
+type Event struct {
+	UID         string
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Description string
+	Categories  []string
+	Modified    time.Time // Optional
+	RRule       string    // Optional recurrence rule, without the "RRULE:" prefix
+	AllDay      bool      // Set when the start was a DATE rather than a DATE-TIME
+
+	// Only read by Parse
+	ExDates      []time.Time // Occurrences left out of the recurrence
+	RecurrenceID time.Time   // Set when the event overrides one occurrence of the event with the same UID
+	Cancelled    bool
+}
+
+// Calendar with its events, rendered as a VCALENDAR
+type Calendar struct {
+	ProdID string
+	Name   string // Shown by clients as the calendar's name
+	Events []Event
+}
+
+// Escape a TEXT value (RFC 5545 section 3.3.11)
+func escapeText(value string) string {
+	value = strings.ReplaceAll(value, "\r\n", "\n")
+	replacer := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`, "\r", `\n`)
+	return replacer.Replace(value)
+}
+
+// Helper function to write a content line, folded at 75 octets without
+// splitting UTF-8 sequences
+func writeLine(b *strings.Builder, line string) {
+	limit := maxLineLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines start with a space, which counts towards the limit
+		limit = maxLineLength - 1
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+// Render the calendar. The stamp is used as DTSTAMP of every event, so the
+// output is the same for the same data.
+func (c Calendar) Render(stamp time.Time) string {
+	var b strings.Builder
+	writeLine(&b, "BEGIN:VCALENDAR")
+	writeLine(&b, "VERSION:2.0")
+	writeLine(&b, "PRODID:"+c.ProdID)
+	writeLine(&b, "CALSCALE:GREGORIAN")
+	writeLine(&b, "METHOD:PUBLISH")
+	if c.Name != "" {
+		writeLine(&b, "X-WR-CALNAME:"+escapeText(c.Name))
+	}
+
+	for _, event := range c.Events {
+		writeLine(&b, "BEGIN:VEVENT")
+		writeLine(&b, "UID:"+event.UID)
+		writeLine(&b, "DTSTAMP:"+stamp.UTC().Format(dateTimeFormat))
+		writeLine(&b, "DTSTART:"+event.Start.UTC().Format(dateTimeFormat))
+		writeLine(&b, "DTEND:"+event.End.UTC().Format(dateTimeFormat))
+		if event.RRule != "" {
+			writeLine(&b, "RRULE:"+event.RRule)
+		}
+		if !event.Modified.IsZero() {
+			writeLine(&b, "LAST-MODIFIED:"+event.Modified.UTC().Format(dateTimeFormat))
+		}
+		writeLine(&b, "SUMMARY:"+escapeText(event.Summary))
+		if event.Description != "" {
+			writeLine(&b, "DESCRIPTION:"+escapeText(event.Description))
+		}
+		if len(event.Categories) > 0 {
+			categories := make([]string, len(event.Categories))
+			for i, category := range event.Categories {
+				categories[i] = escapeText(category)
+			}
+			writeLine(&b, "CATEGORIES:"+strings.Join(categories, ","))
+		}
+		writeLine(&b, "TRANSP:OPAQUE")
+		writeLine(&b, "END:VEVENT")
+	}
+
+	writeLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+// Build a UID that stays the same for the same record
+func UID(kind string, id int, domain string) string {
+	return fmt.Sprintf("%s-%d@%s", kind, id, domain)
+}
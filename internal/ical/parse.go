@@ -0,0 +1,253 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Content line split into its name, parameters and value
+type contentLine struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+var durationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// Read the events of an iCalendar file. Times without a time zone are taken
+// to be in loc. Components other than VEVENT are ignored.
+func Parse(r io.Reader, loc *time.Location) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var event *Event
+	var duration time.Duration
+	hasDuration := false
+	depth := 0 // Nesting inside the current VEVENT, e.g. a VALARM
+
+	for number, raw := range lines {
+		line, err := parseContentLine(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number+1, err)
+		}
+
+		switch {
+		case line.name == "BEGIN" && strings.EqualFold(line.value, "VEVENT") && event == nil:
+			event = &Event{}
+			duration, hasDuration = 0, false
+			continue
+		case line.name == "BEGIN" && event != nil:
+			depth++
+			continue
+		case line.name == "END" && event != nil && depth > 0:
+			depth--
+			continue
+		case line.name == "END" && strings.EqualFold(line.value, "VEVENT") && event != nil:
+			if event.Start.IsZero() {
+				return nil, fmt.Errorf("line %d: event without DTSTART", number+1)
+			}
+			if event.End.IsZero() {
+				switch {
+				case hasDuration:
+					event.End = event.Start.Add(duration)
+				case event.AllDay:
+					event.End = event.Start.AddDate(0, 0, 1)
+				default:
+					event.End = event.Start
+				}
+			}
+			events = append(events, *event)
+			event = nil
+			continue
+		}
+		if event == nil || depth > 0 {
+			continue
+		}
+
+		switch line.name {
+		case "UID":
+			event.UID = line.value
+		case "SUMMARY":
+			event.Summary = unescapeText(line.value)
+		case "DESCRIPTION":
+			event.Description = unescapeText(line.value)
+		case "CATEGORIES":
+			for _, category := range splitText(line.value) {
+				if category = strings.TrimSpace(category); category != "" {
+					event.Categories = append(event.Categories, category)
+				}
+			}
+		case "RRULE":
+			event.RRule = line.value
+		case "EXDATE":
+			for _, value := range strings.Split(line.value, ",") {
+				var exdate time.Time
+				if exdate, err = parseDateTime(value, line.params, loc); err != nil {
+					break
+				}
+				event.ExDates = append(event.ExDates, exdate)
+			}
+		case "RECURRENCE-ID":
+			event.RecurrenceID, err = parseDateTime(line.value, line.params, loc)
+		case "STATUS":
+			event.Cancelled = strings.EqualFold(line.value, "CANCELLED")
+		case "DTSTART":
+			event.Start, err = parseDateTime(line.value, line.params, loc)
+			event.AllDay = line.params["VALUE"] == "DATE" || len(line.value) == 8
+		case "DTEND":
+			event.End, err = parseDateTime(line.value, line.params, loc)
+		case "DURATION":
+			duration, err = parseDuration(line.value)
+			hasDuration = true
+		case "LAST-MODIFIED":
+			event.Modified, err = parseDateTime(line.value, line.params, loc)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid %s: %w", number+1, line.name, err)
+		}
+	}
+
+	if event != nil {
+		return nil, errors.New("unterminated VEVENT")
+	}
+	return events, nil
+}
+
+// Helper function to read the content lines, joining folded lines
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) == 0 {
+			line = strings.TrimPrefix(line, "\uFEFF")
+		}
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// Helper function to split "NAME;PARAM=value:VALUE", parameter values may be
+// quoted and contain colons
+func parseContentLine(line string) (contentLine, error) {
+	result := contentLine{params: map[string]string{}}
+
+	inQuotes := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			inQuotes = !inQuotes
+		} else if r == ':' && !inQuotes {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return result, fmt.Errorf("invalid content line %q", line)
+	}
+	result.value = line[colon+1:]
+
+	parts := strings.Split(line[:colon], ";")
+	result.name = strings.ToUpper(parts[0])
+	for _, param := range parts[1:] {
+		name, value, _ := strings.Cut(param, "=")
+		result.params[strings.ToUpper(name)] = strings.Trim(value, `"`)
+	}
+	return result, nil
+}
+
+// Helper function to parse a DATE or DATE-TIME value
+func parseDateTime(value string, params map[string]string, loc *time.Location) (time.Time, error) {
+	if tzid := params["TZID"]; tzid != "" {
+		if tz, err := time.LoadLocation(tzid); err == nil {
+			loc = tz
+		}
+	}
+
+	switch {
+	case strings.HasSuffix(value, "Z"):
+		return time.Parse(dateTimeFormat, value)
+	case len(value) == 8:
+		return time.ParseInLocation("20060102", value, loc)
+	default:
+		return time.ParseInLocation("20060102T150405", value, loc)
+	}
+}
+
+// Helper function to parse a DURATION value such as PT1H30M
+func parseDuration(value string) (time.Duration, error) {
+	match := durationPattern.FindStringSubmatch(value)
+	if match == nil || value == "P" || strings.HasSuffix(value, "T") {
+		return 0, fmt.Errorf("unrecognised duration %q", value)
+	}
+
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var duration time.Duration
+	for i, unit := range units {
+		if match[i+2] != "" {
+			n, _ := strconv.Atoi(match[i+2])
+			duration += time.Duration(n) * unit
+		}
+	}
+	if match[1] == "-" {
+		duration = -duration
+	}
+	return duration, nil
+}
+
+// Undo escapeText
+func unescapeText(value string) string {
+	var b strings.Builder
+	escaped := false
+	for _, r := range value {
+		if escaped {
+			if r == 'n' || r == 'N' {
+				b.WriteRune('\n')
+			} else {
+				b.WriteRune(r)
+			}
+			escaped = false
+			continue
+		}
+		if r == '\\' {
+			escaped = true
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Helper function to split a list of TEXT values on unescaped commas
+func splitText(value string) []string {
+	var values []string
+	start := 0
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' {
+			i++
+			continue
+		}
+		if value[i] == ',' {
+			values = append(values, unescapeText(value[start:i]))
+			start = i + 1
+		}
+	}
+	return append(values, unescapeText(value[start:]))
+}
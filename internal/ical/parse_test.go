@@ -0,0 +1,164 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCalendar = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:standup@example.com\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20260105T090000\r\n" +
+	"DTEND;TZID=Europe/Berlin:20260105T093000\r\n" +
+	"RRULE:FREQ=WEEKLY;BYDAY=MO,WE\r\n" +
+	"EXDATE;TZID=Europe/Berlin:20260107T090000,20260112T090000\r\n" +
+	"SUMMARY:Deep work\\, morning\r\n" +
+	"DESCRIPTION:A long description that is folded onto the next line so th\r\n" +
+	" at it stays under 75 octets\r\n" +
+	"CATEGORIES:writing,research\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"TRIGGER:-PT10M\r\n" +
+	"DESCRIPTION:Reminder\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:standup@example.com\r\n" +
+	"RECURRENCE-ID;TZID=Europe/Berlin:20260114T090000\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20260114T140000\r\n" +
+	"DURATION:PT1H\r\n" +
+	"SUMMARY:Deep work\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:holiday@example.com\r\n" +
+	"DTSTART;VALUE=DATE:20260101\r\n" +
+	"STATUS:CANCELLED\r\n" +
+	"SUMMARY:Holiday\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParse(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("time zone data not available")
+	}
+
+	events, err := Parse(strings.NewReader(testCalendar), time.UTC)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+
+	series := events[0]
+	if series.Summary != "Deep work, morning" {
+		t.Errorf("Summary = %q", series.Summary)
+	}
+	if !strings.HasSuffix(series.Description, "so that it stays under 75 octets") {
+		t.Errorf("Description not unfolded: %q", series.Description)
+	}
+	if got := strings.Join(series.Categories, "|"); got != "writing|research" {
+		t.Errorf("Categories = %q", got)
+	}
+	if !series.Start.Equal(time.Date(2026, 1, 5, 9, 0, 0, 0, berlin)) {
+		t.Errorf("Start = %v", series.Start)
+	}
+	if series.End.Sub(series.Start) != 30*time.Minute {
+		t.Errorf("End = %v", series.End)
+	}
+	if series.RRule != "FREQ=WEEKLY;BYDAY=MO,WE" {
+		t.Errorf("RRule = %q", series.RRule)
+	}
+	if len(series.ExDates) != 2 || !series.ExDates[1].Equal(time.Date(2026, 1, 12, 9, 0, 0, 0, berlin)) {
+		t.Errorf("ExDates = %v", series.ExDates)
+	}
+	if !series.RecurrenceID.IsZero() {
+		t.Errorf("RecurrenceID set on the series: %v", series.RecurrenceID)
+	}
+
+	override := events[1]
+	if override.UID != series.UID {
+		t.Errorf("override UID = %q", override.UID)
+	}
+	if !override.RecurrenceID.Equal(time.Date(2026, 1, 14, 9, 0, 0, 0, berlin)) {
+		t.Errorf("RecurrenceID = %v", override.RecurrenceID)
+	}
+	if override.End.Sub(override.Start) != time.Hour {
+		t.Errorf("DURATION not applied: %v to %v", override.Start, override.End)
+	}
+
+	holiday := events[2]
+	if !holiday.AllDay || !holiday.Cancelled {
+		t.Errorf("AllDay = %v, Cancelled = %v", holiday.AllDay, holiday.Cancelled)
+	}
+	if holiday.End.Sub(holiday.Start) != 24*time.Hour {
+		t.Errorf("all-day event should last a day, got %v", holiday.End.Sub(holiday.Start))
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"missing DTSTART": "BEGIN:VEVENT\r\nUID:x\r\nEND:VEVENT\r\n",
+		"unterminated":    "BEGIN:VEVENT\r\nDTSTART:20260101T090000Z\r\n",
+		"bad line":        "BEGIN:VEVENT\r\nnot a content line\r\nEND:VEVENT\r\n",
+		"bad duration":    "BEGIN:VEVENT\r\nDTSTART:20260101T090000Z\r\nDURATION:PT\r\nEND:VEVENT\r\n",
+	}
+	for name, input := range tests {
+		if _, err := Parse(strings.NewReader(input), time.UTC); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"PT1H30M":  90 * time.Minute,
+		"P1D":      24 * time.Hour,
+		"P1W":      7 * 24 * time.Hour,
+		"-PT15M":   -15 * time.Minute,
+		"P1DT2H3S": 26*time.Hour + 3*time.Second,
+	}
+	for value, want := range tests {
+		got, err := parseDuration(value)
+		if err != nil || got != want {
+			t.Errorf("parseDuration(%q) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+}
+
+func TestRenderRoundTrip(t *testing.T) {
+	start := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	calendar := Calendar{ProdID: "-//test//EN", Events: []Event{{
+		UID:        "session-1@example.com",
+		Start:      start,
+		End:        start.Add(25 * time.Minute),
+		Summary:    "Notes; with, special\ncharacters and a summary long enough to need folding",
+		Categories: []string{"a,b", "c"},
+	}}}
+
+	rendered := calendar.Render(start)
+	for _, line := range strings.Split(rendered, "\r\n") {
+		if len(line) > maxLineLength {
+			t.Errorf("line longer than %d octets: %q", maxLineLength, line)
+		}
+	}
+
+	events, err := Parse(strings.NewReader(rendered), time.UTC)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	if events[0].Summary != calendar.Events[0].Summary {
+		t.Errorf("Summary = %q", events[0].Summary)
+	}
+	if got := strings.Join(events[0].Categories, "|"); got != "a,b|c" {
+		t.Errorf("Categories = %q", got)
+	}
+	if !events[0].End.Equal(calendar.Events[0].End) {
+		t.Errorf("End = %v", events[0].End)
+	}
+}
@@ -0,0 +1,221 @@
+package ical
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurrence rule (RFC 5545 section 3.3.10). Only the common subset is
+// supported: DAILY, WEEKLY and MONTHLY with INTERVAL, COUNT, UNTIL and BYDAY
+// for daily and weekly rules.
+type Rule struct {
+	Freq     string
+	Interval int
+	Count    int       // 0 for no limit
+	Until    time.Time // Zero for no limit
+	ByDay    []time.Weekday
+
+	// Occurrences left out of the series (EXDATE). They still count towards
+	// Count, and aren't part of String.
+	Exclude []time.Time
+}
+
+// Upper bound on the occurrences looked at, so a bad rule can't spin forever
+const maxRuleIterations = 100000
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// Parse an RRULE value, with or without the "RRULE:" prefix
+func ParseRule(value string) (Rule, error) {
+	rule := Rule{Interval: 1}
+	value = strings.TrimPrefix(strings.TrimSpace(value), "RRULE:")
+	if value == "" {
+		return rule, errors.New("empty recurrence rule")
+	}
+
+	for _, part := range strings.Split(value, ";") {
+		name, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return rule, fmt.Errorf("invalid recurrence rule part %q", part)
+		}
+		switch strings.ToUpper(name) {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(val)
+			if rule.Freq != "DAILY" && rule.Freq != "WEEKLY" && rule.Freq != "MONTHLY" {
+				return rule, fmt.Errorf("unsupported recurrence frequency %q", val)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return rule, fmt.Errorf("invalid recurrence interval %q", val)
+			}
+			rule.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return rule, fmt.Errorf("invalid recurrence count %q", val)
+			}
+			rule.Count = n
+		case "UNTIL":
+			until, err := parseDateTime(val, nil, time.UTC)
+			if err != nil {
+				return rule, fmt.Errorf("invalid recurrence end %q", val)
+			}
+			rule.Until = until
+		case "BYDAY":
+			for _, day := range strings.Split(strings.ToUpper(val), ",") {
+				weekday, ok := weekdays[day]
+				if !ok {
+					return rule, fmt.Errorf("unsupported recurrence day %q", day)
+				}
+				rule.ByDay = append(rule.ByDay, weekday)
+			}
+		case "WKST":
+			// Weeks always start on Monday, the default
+		default:
+			return rule, fmt.Errorf("unsupported recurrence rule part %q", name)
+		}
+	}
+
+	if rule.Freq == "" {
+		return rule, errors.New("recurrence rule needs a FREQ")
+	}
+	if rule.Freq == "MONTHLY" && len(rule.ByDay) > 0 {
+		return rule, errors.New("BYDAY is only supported for daily and weekly rules")
+	}
+	return rule, nil
+}
+
+// Format the rule as an RRULE value, without the prefix
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(dateTimeFormat))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, weekday := range r.ByDay {
+			for name, day := range weekdays {
+				if day == weekday {
+					days[i] = name
+				}
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Helper function to check whether an occurrence was excluded
+func (r Rule) excluded(t time.Time) bool {
+	for _, exdate := range r.Exclude {
+		if t.Equal(exdate) {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to check a day against BYDAY
+func (r Rule) matchesDay(t time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, weekday := range r.ByDay {
+		if t.Weekday() == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// Occurrences of a series starting at start that begin within [from, to).
+// Times keep the wall clock time of start in its location, so a 9:00 plan
+// stays at 9:00 across daylight saving changes.
+func (r Rule) Between(start, from, to time.Time) []time.Time {
+	var occurrences []time.Time
+	count := 0
+
+	// Returns false once the series is over
+	visit := func(t time.Time) bool {
+		if t.Before(start) {
+			return true
+		}
+		if !r.Until.IsZero() && t.After(r.Until) {
+			return false
+		}
+		if r.Count > 0 && count >= r.Count {
+			return false
+		}
+		count++
+		if !t.Before(to) {
+			return false
+		}
+		if !t.Before(from) && !r.excluded(t) {
+			occurrences = append(occurrences, t)
+		}
+		return true
+	}
+
+	clock := func(day time.Time) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+	}
+
+	switch r.Freq {
+	case "DAILY":
+		for i := 0; i < maxRuleIterations; i++ {
+			t := clock(start.AddDate(0, 0, i*r.Interval))
+			if r.matchesDay(t) && !visit(t) {
+				break
+			}
+		}
+	case "WEEKLY":
+		days := r.ByDay
+		if len(days) == 0 {
+			days = []time.Weekday{start.Weekday()}
+		}
+		// Days in week order starting from Monday
+		offsets := make([]int, len(days))
+		for i, day := range days {
+			offsets[i] = (int(day) + 6) % 7
+		}
+		sort.Ints(offsets)
+		monday := start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+	weeks:
+		for i := 0; i < maxRuleIterations; i++ {
+			week := monday.AddDate(0, 0, 7*i*r.Interval)
+			for _, offset := range offsets {
+				if !visit(clock(week.AddDate(0, 0, offset))) {
+					break weeks
+				}
+			}
+		}
+	case "MONTHLY":
+		for i := 0; i < maxRuleIterations; i++ {
+			month := time.Date(start.Year(), start.Month()+time.Month(i*r.Interval), 1, 0, 0, 0, 0, start.Location())
+			t := clock(month.AddDate(0, 0, start.Day()-1))
+			// Months without the day, like the 31st, are skipped
+			if t.Month() != month.Month() {
+				continue
+			}
+			if !visit(t) {
+				break
+			}
+		}
+	}
+
+	return occurrences
+}
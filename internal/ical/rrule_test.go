@@ -0,0 +1,122 @@
+package ical
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := map[string]string{
+		"FREQ=DAILY":                            "FREQ=DAILY",
+		"RRULE:FREQ=WEEKLY;BYDAY=MO,WE;WKST=MO": "FREQ=WEEKLY;BYDAY=MO,WE",
+		"freq=monthly;interval=2;count=3":       "FREQ=MONTHLY;INTERVAL=2;COUNT=3",
+		"FREQ=DAILY;UNTIL=20260110T000000Z":     "FREQ=DAILY;UNTIL=20260110T000000Z",
+	}
+	for value, want := range tests {
+		rule, err := ParseRule(value)
+		if err != nil {
+			t.Errorf("ParseRule(%q): %v", value, err)
+			continue
+		}
+		if got := rule.String(); got != want {
+			t.Errorf("ParseRule(%q).String() = %q, want %q", value, got, want)
+		}
+	}
+
+	for _, value := range []string{"", "INTERVAL=2", "FREQ=YEARLY", "FREQ=DAILY;COUNT=0", "FREQ=WEEKLY;BYDAY=XX", "FREQ=MONTHLY;BYDAY=MO", "FREQ=DAILY;BYHOUR=9"} {
+		if _, err := ParseRule(value); err == nil {
+			t.Errorf("ParseRule(%q): expected an error", value)
+		}
+	}
+}
+
+func mustRule(t *testing.T, value string) Rule {
+	t.Helper()
+	rule, err := ParseRule(value)
+	if err != nil {
+		t.Fatalf("ParseRule(%q): %v", value, err)
+	}
+	return rule
+}
+
+func dates(times []time.Time) []string {
+	result := make([]string, len(times))
+	for i, t := range times {
+		result[i] = t.Format("2006-01-02 15:04")
+	}
+	return result
+}
+
+func assertDates(t *testing.T, got []time.Time, want ...string) {
+	t.Helper()
+	gotDates := dates(got)
+	if len(gotDates) != len(want) {
+		t.Fatalf("got %v, want %v", gotDates, want)
+	}
+	for i := range want {
+		if gotDates[i] != want[i] {
+			t.Fatalf("got %v, want %v", gotDates, want)
+		}
+	}
+}
+
+func TestBetween(t *testing.T) {
+	// Monday 5 January 2026
+	start := time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC)
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("daily count", func(t *testing.T) {
+		assertDates(t, mustRule(t, "FREQ=DAILY;COUNT=3").Between(start, from, to),
+			"2026-01-05 09:00", "2026-01-06 09:00", "2026-01-07 09:00")
+	})
+
+	t.Run("daily weekdays", func(t *testing.T) {
+		rule := mustRule(t, "FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR")
+		assertDates(t, rule.Between(start, start.AddDate(0, 0, 4), start.AddDate(0, 0, 8)),
+			"2026-01-09 09:00", "2026-01-12 09:00")
+	})
+
+	t.Run("weekly interval", func(t *testing.T) {
+		assertDates(t, mustRule(t, "FREQ=WEEKLY;INTERVAL=2;BYDAY=WE,MO").Between(start, from, to),
+			"2026-01-05 09:00", "2026-01-07 09:00", "2026-01-19 09:00", "2026-01-21 09:00")
+	})
+
+	t.Run("until", func(t *testing.T) {
+		assertDates(t, mustRule(t, "FREQ=WEEKLY;UNTIL=20260112T090000Z").Between(start, from, to),
+			"2026-01-05 09:00", "2026-01-12 09:00")
+	})
+
+	t.Run("count spans the window", func(t *testing.T) {
+		// Occurrences before the window still use up the count
+		rule := mustRule(t, "FREQ=DAILY;COUNT=4")
+		assertDates(t, rule.Between(start, start.AddDate(0, 0, 2), to), "2026-01-07 09:00", "2026-01-08 09:00")
+	})
+
+	t.Run("monthly skips short months", func(t *testing.T) {
+		jan31 := time.Date(2026, 1, 31, 9, 0, 0, 0, time.UTC)
+		assertDates(t, mustRule(t, "FREQ=MONTHLY;COUNT=3").Between(jan31, from, from.AddDate(1, 0, 0)),
+			"2026-01-31 09:00", "2026-03-31 09:00", "2026-05-31 09:00")
+	})
+
+	t.Run("excluded dates", func(t *testing.T) {
+		rule := mustRule(t, "FREQ=DAILY;COUNT=4")
+		rule.Exclude = []time.Time{start.AddDate(0, 0, 1), start.AddDate(0, 0, 3).In(time.FixedZone("UTC+2", 2*60*60))}
+		// Excluded occurrences still count towards COUNT
+		assertDates(t, rule.Between(start, from, to), "2026-01-05 09:00", "2026-01-07 09:00")
+	})
+
+	t.Run("keeps the wall clock time", func(t *testing.T) {
+		berlin, err := time.LoadLocation("Europe/Berlin")
+		if err != nil {
+			t.Skip("time zone data not available")
+		}
+		// Daylight saving time starts on 29 March 2026
+		local := time.Date(2026, 3, 27, 9, 0, 0, 0, berlin)
+		got := mustRule(t, "FREQ=DAILY;COUNT=4").Between(local, from, to.AddDate(0, 3, 0))
+		assertDates(t, got, "2026-03-27 09:00", "2026-03-28 09:00", "2026-03-29 09:00", "2026-03-30 09:00")
+		if got[3].Sub(got[0]) != 3*24*time.Hour-time.Hour {
+			t.Errorf("expected the clock change to be absorbed, got %v", got[3].Sub(got[0]))
+		}
+	})
+}
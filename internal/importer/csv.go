@@ -0,0 +1,297 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Where to find the fields of an entry in a CSV file, by column header
+type Mapping struct {
+	Start          string `json:"start"`           // Start date and time, or only the time if Date is set
+	Date           string `json:"date"`            // Optional start date
+	End            string `json:"end"`             // End date and time, or only the time if EndDate or Date is set
+	EndDate        string `json:"end_date"`        // Optional end date, defaults to the start date
+	Duration       string `json:"duration"`        // Used when there is no end column
+	Description    string `json:"description"`     // Optional
+	Tags           string `json:"tags"`            // Optional
+	Project        string `json:"project"`         // Optional
+	DateFormat     string `json:"date_format"`     // Go layout, e.g. 2006-01-02
+	TimeFormat     string `json:"time_format"`     // Go layout of times or date times, common layouts are tried otherwise
+	DurationFormat string `json:"duration_format"` // "hh:mm:ss" (default), "seconds", "minutes" or "hours"
+	TagSeparator   string `json:"tag_separator"`   // Defaults to a comma
+	Delimiter      string `json:"delimiter"`       // Defaults to a comma
+}
+
+// Toggl Track "Detailed report" CSV export
+var togglMapping = Mapping{
+	Start:       "Start time",
+	Date:        "Start date",
+	End:         "End time",
+	EndDate:     "End date",
+	Duration:    "Duration",
+	Description: "Description",
+	Tags:        "Tags",
+	Project:     "Project",
+	DateFormat:  "2006-01-02",
+	TimeFormat:  "15:04:05",
+}
+
+// Clockify "Detailed report" CSV export
+var clockifyMapping = Mapping{
+	Start:       "Start Time",
+	Date:        "Start Date",
+	End:         "End Time",
+	EndDate:     "End Date",
+	Duration:    "Duration (h)",
+	Description: "Description",
+	Tags:        "Tags",
+	Project:     "Project",
+	DateFormat:  "01/02/2006",
+}
+
+// Layouts tried when a time doesn't match the configured format
+var fallbackTimeFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"15:04:05",
+	"15:04",
+	"03:04:05 PM",
+	"3:04:05 PM",
+	"03:04 PM",
+	"3:04 PM",
+}
+
+// Reads CSV files through a column mapping, optionally on top of a preset
+type csvParser struct {
+	preset Mapping
+}
+
+// Helper function to lay the non-empty fields of the user's mapping over a preset
+func mergeMapping(preset, override Mapping) Mapping {
+	merged := preset
+	fields := []struct {
+		dst *string
+		src string
+	}{
+		{&merged.Start, override.Start}, {&merged.Date, override.Date}, {&merged.End, override.End},
+		{&merged.EndDate, override.EndDate}, {&merged.Duration, override.Duration},
+		{&merged.Description, override.Description}, {&merged.Tags, override.Tags},
+		{&merged.Project, override.Project}, {&merged.DateFormat, override.DateFormat},
+		{&merged.TimeFormat, override.TimeFormat}, {&merged.DurationFormat, override.DurationFormat},
+		{&merged.TagSeparator, override.TagSeparator}, {&merged.Delimiter, override.Delimiter},
+	}
+	for _, field := range fields {
+		if field.src != "" {
+			*field.dst = field.src
+		}
+	}
+	return merged
+}
+
+func (p csvParser) Parse(r io.Reader, opts Options) ([]Entry, []RowError, error) {
+	mapping := mergeMapping(p.preset, opts.Mapping)
+	if mapping.Start == "" {
+		return nil, nil, errors.New("the mapping needs a start column")
+	}
+	if mapping.End == "" && mapping.Duration == "" {
+		return nil, nil, errors.New("the mapping needs an end or a duration column")
+	}
+
+	// Spreadsheet tools like to start files with a byte order mark, which
+	// encoding/csv would take as part of the first field
+	buffered := bufio.NewReader(r)
+	if bom, err := buffered.Peek(3); err == nil && string(bom) == "\uFEFF" {
+		buffered.Discard(3)
+	}
+
+	reader := csv.NewReader(buffered)
+	reader.FieldsPerRecord = -1
+	if mapping.Delimiter != "" {
+		reader.Comma = []rune(mapping.Delimiter)[0]
+	}
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the header: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{mapping.Start, mapping.Date, mapping.End, mapping.EndDate, mapping.Duration,
+		mapping.Description, mapping.Tags, mapping.Project} {
+		if _, ok := columns[name]; name != "" && !ok {
+			return nil, nil, fmt.Errorf("column %q not found in the file", name)
+		}
+	}
+
+	var entries []Entry
+	var rowErrors []RowError
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read row %d: %w", row, err)
+		}
+
+		field := func(name string) string {
+			index, ok := columns[name]
+			if name == "" || !ok || index >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[index])
+		}
+
+		// Skip blank lines and summary rows
+		if field(mapping.Start) == "" {
+			continue
+		}
+
+		entry, err := readEntry(field, mapping, opts.Location)
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Row: row, Error: err.Error()})
+			continue
+		}
+		entry.Row = row
+		entries = append(entries, entry)
+	}
+
+	return entries, rowErrors, nil
+}
+
+// Helper function to build an entry from the fields of one row
+func readEntry(field func(string) string, mapping Mapping, loc *time.Location) (Entry, error) {
+	var entry Entry
+
+	start, err := parseDateTime(field(mapping.Date), field(mapping.Start), mapping, loc)
+	if err != nil {
+		return entry, fmt.Errorf("invalid start: %w", err)
+	}
+	entry.Start = start
+
+	if mapping.End != "" && field(mapping.End) != "" {
+		endDate := field(mapping.EndDate)
+		if endDate == "" {
+			endDate = field(mapping.Date)
+		}
+		end, err := parseDateTime(endDate, field(mapping.End), mapping, loc)
+		if err != nil {
+			return entry, fmt.Errorf("invalid end: %w", err)
+		}
+		// An end time without its own date before the start ran past midnight
+		if end.Before(start) && mapping.EndDate == "" && mapping.Date != "" {
+			end = end.AddDate(0, 0, 1)
+		}
+		if end.Before(start) {
+			return entry, errors.New("end is before start")
+		}
+		entry.End = end
+		entry.Duration = int(end.Sub(start).Seconds())
+	} else {
+		duration, err := parseDuration(field(mapping.Duration), mapping.DurationFormat)
+		if err != nil {
+			return entry, fmt.Errorf("invalid duration: %w", err)
+		}
+		entry.Duration = duration
+		entry.End = start.Add(time.Duration(duration) * time.Second)
+	}
+
+	entry.Description = field(mapping.Description)
+	entry.Project = field(mapping.Project)
+
+	separator := mapping.TagSeparator
+	if separator == "" {
+		separator = ","
+	}
+	entry.Tags = []string{}
+	for _, tag := range strings.Split(field(mapping.Tags), separator) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			entry.Tags = append(entry.Tags, tag)
+		}
+	}
+
+	return entry, nil
+}
+
+// Helper function to parse a time, with the date in a separate column if given
+func parseDateTime(date string, value string, mapping Mapping, loc *time.Location) (time.Time, error) {
+	if date != "" {
+		dateFormat := mapping.DateFormat
+		if dateFormat == "" {
+			dateFormat = "2006-01-02"
+		}
+		day, err := time.ParseInLocation(dateFormat, date, loc)
+		if err != nil {
+			return time.Time{}, err
+		}
+		clock, err := parseTime(value, mapping.TimeFormat, loc)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, loc), nil
+	}
+	return parseTime(value, mapping.TimeFormat, loc)
+}
+
+// Helper function to parse a time with the configured layout or a common one
+func parseTime(value string, layout string, loc *time.Location) (time.Time, error) {
+	if layout != "" {
+		if parsed, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return parsed, nil
+		}
+	}
+	for _, fallback := range fallbackTimeFormats {
+		if parsed, err := time.ParseInLocation(fallback, value, loc); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised time %q", value)
+}
+
+// Helper function to parse a duration into seconds
+func parseDuration(value string, format string) (int, error) {
+	if value == "" {
+		return 0, errors.New("missing duration")
+	}
+
+	switch format {
+	case "seconds", "minutes", "hours":
+		amount, err := strconv.ParseFloat(strings.ReplaceAll(value, ",", "."), 64)
+		if err != nil || amount < 0 {
+			return 0, fmt.Errorf("unrecognised duration %q", value)
+		}
+		scale := map[string]float64{"seconds": 1, "minutes": 60, "hours": 3600}[format]
+		return int(math.Round(amount * scale)), nil
+	case "", "hh:mm:ss":
+		seconds := 0
+		parts := strings.Split(value, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return 0, fmt.Errorf("unrecognised duration %q", value)
+		}
+		for _, part := range parts {
+			n, err := strconv.Atoi(part)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("unrecognised duration %q", value)
+			}
+			seconds = seconds*60 + n
+		}
+		if len(parts) == 2 {
+			seconds *= 60 // hh:mm
+		}
+		return seconds, nil
+	default:
+		return 0, fmt.Errorf("unknown duration format %q", format)
+	}
+}
@@ -0,0 +1,158 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFormats(t *testing.T) {
+	tests := map[string]struct {
+		format string
+		input  string
+		opts   Options
+		want   []Entry
+	}{
+		"toggl": {
+			format: "toggl",
+			input: "\uFEFFUser,Project,Description,Tags,Start date,Start time,End date,End time,Duration\n" +
+				"Ann,Thesis,Chapter 2,\"writing, research\",2026-03-02,09:00:00,2026-03-02,10:30:00,01:30:00\n" +
+				"Ann,,Late night,,2026-03-02,23:30:00,2026-03-03,00:15:00,00:45:00\n",
+			want: []Entry{
+				{Row: 2, Start: time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC), End: time.Date(2026, 3, 2, 10, 30, 0, 0, time.UTC),
+					Duration: 5400, Description: "Chapter 2", Tags: []string{"writing", "research"}, Project: "Thesis"},
+				{Row: 3, Start: time.Date(2026, 3, 2, 23, 30, 0, 0, time.UTC), End: time.Date(2026, 3, 3, 0, 15, 0, 0, time.UTC),
+					Duration: 2700, Description: "Late night", Tags: []string{}},
+			},
+		},
+		"clockify": {
+			format: "clockify",
+			input: "Project,Description,Tags,Start Date,Start Time,End Date,End Time,Duration (h)\n" +
+				"Site,Review,\"ops, web\",03/02/2026,01:15:00 PM,03/02/2026,02:00:00 PM,00:45:00\n",
+			want: []Entry{
+				{Row: 2, Start: time.Date(2026, 3, 2, 13, 15, 0, 0, time.UTC), End: time.Date(2026, 3, 2, 14, 0, 0, 0, time.UTC),
+					Duration: 2700, Description: "Review", Tags: []string{"ops", "web"}, Project: "Site"},
+			},
+		},
+		"csv with duration": {
+			format: "csv",
+			input: "when;minutes;what;labels\n" +
+				"2026-03-02 08:00;25;Email;a|b\n" +
+				";;Total;\n",
+			opts: Options{Mapping: Mapping{Start: "when", Duration: "minutes", DurationFormat: "minutes",
+				Description: "what", Tags: "labels", TagSeparator: "|", Delimiter: ";"}},
+			want: []Entry{
+				{Row: 2, Start: time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC), End: time.Date(2026, 3, 2, 8, 25, 0, 0, time.UTC),
+					Duration: 1500, Description: "Email", Tags: []string{"a", "b"}},
+			},
+		},
+		"csv with end": {
+			format: "csv",
+			input: "start,end\n" +
+				"2026-03-02T08:00:00+01:00,2026-03-02T09:00:00+01:00\n",
+			opts: Options{Mapping: Mapping{Start: "start", End: "end"}},
+			want: []Entry{
+				{Row: 2, Start: time.Date(2026, 3, 2, 7, 0, 0, 0, time.UTC), End: time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC),
+					Duration: 3600, Tags: []string{}},
+			},
+		},
+	}
+	for name, test := range tests {
+		entries, rowErrors, err := Parse(test.format, strings.NewReader(test.input), test.opts)
+		if err != nil {
+			t.Errorf("%s: Parse: %v", name, err)
+			continue
+		}
+		if len(rowErrors) != 0 {
+			t.Errorf("%s: unexpected row errors %v", name, rowErrors)
+		}
+		if len(entries) != len(test.want) {
+			t.Errorf("%s: got %d entries, want %d", name, len(entries), len(test.want))
+			continue
+		}
+		for i, want := range test.want {
+			got := entries[i]
+			if got.Row != want.Row || !got.Start.Equal(want.Start) || !got.End.Equal(want.End) ||
+				got.Duration != want.Duration || got.Description != want.Description || got.Project != want.Project ||
+				strings.Join(got.Tags, "|") != strings.Join(want.Tags, "|") {
+				t.Errorf("%s: entry %d = %+v, want %+v", name, i, got, want)
+			}
+		}
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("time zone data not available")
+	}
+
+	input := "Project,Description,Tags,Start date,Start time,End date,End time,Duration\n" +
+		",Planning,,2026-03-02,09:00:00,2026-03-02,09:25:00,00:25:00\n"
+	entries, _, err := Parse("toggl", strings.NewReader(input), Options{Location: berlin})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(entries) != 1 || !entries[0].Start.Equal(time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("entries = %+v, want one starting at 08:00 UTC", entries)
+	}
+}
+
+func TestParseRowErrors(t *testing.T) {
+	tests := map[string]string{
+		"bad start":        "start,end\nyesterday,2026-03-02T09:00:00Z\n",
+		"end before start": "start,end\n2026-03-02T09:00:00Z,2026-03-02T08:00:00Z\n",
+	}
+	opts := Options{Mapping: Mapping{Start: "start", End: "end"}}
+	for name, input := range tests {
+		entries, rowErrors, err := Parse("csv", strings.NewReader(input), opts)
+		if err != nil {
+			t.Errorf("%s: Parse: %v", name, err)
+			continue
+		}
+		if len(entries) != 0 || len(rowErrors) != 1 || rowErrors[0].Row != 2 {
+			t.Errorf("%s: got %d entries and row errors %v, want one error on row 2", name, len(entries), rowErrors)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]struct {
+		format string
+		input  string
+		opts   Options
+	}{
+		"unknown format":   {format: "harvest", input: "a\n"},
+		"no mapping":       {format: "csv", input: "start\n"},
+		"missing column":   {format: "toggl", input: "Start date,Start time\n"},
+		"empty file":       {format: "csv", input: "", opts: Options{Mapping: Mapping{Start: "a", End: "b"}}},
+		"no end or length": {format: "csv", input: "start\n", opts: Options{Mapping: Mapping{Start: "start"}}},
+	}
+	for name, test := range tests {
+		if _, _, err := Parse(test.format, strings.NewReader(test.input), test.opts); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := map[[2]string]int{
+		{"1:30:00", ""}:       5400,
+		{"00:45", "hh:mm:ss"}: 2700,
+		{"90", "seconds"}:     90,
+		{"1,5", "minutes"}:    90,
+		{"0.25", "hours"}:     900,
+	}
+	for input, want := range tests {
+		got, err := parseDuration(input[0], input[1])
+		if err != nil || got != want {
+			t.Errorf("parseDuration(%q, %q) = %d, %v, want %d", input[0], input[1], got, err, want)
+		}
+	}
+
+	for _, input := range [][2]string{{"", ""}, {"90", ""}, {"1:x", ""}, {"-1", "minutes"}, {"1", "days"}} {
+		if _, err := parseDuration(input[0], input[1]); err == nil {
+			t.Errorf("parseDuration(%q, %q): expected an error", input[0], input[1])
+		}
+	}
+}
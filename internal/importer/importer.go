@@ -0,0 +1,77 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Time entry read from another tracker
+type Entry struct {
+	Row         int       `json:"row"` // Line in the source file, counting the header as 1
+	Start       time.Time `json:"start_time"`
+	End         time.Time `json:"end_time"`
+	Duration    int       `json:"duration"` // In seconds
+	Description string    `json:"description"`
+	Tags        []string  `json:"tags"`
+	Project     string    `json:"project"`
+}
+
+// Row that couldn't be read
+type RowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// Reads the export of a time tracker. Parsers get the user's options, which
+// may override their defaults.
+type Parser interface {
+	Parse(r io.Reader, opts Options) ([]Entry, []RowError, error)
+}
+
+// Options of an import. The column mapping is required for generic CSV
+// files, for the presets it only overrides what is set.
+type Options struct {
+	Location *time.Location `json:"-"` // Time zone of times without one, UTC if nil
+	Mapping
+}
+
+var ErrUnknownFormat = errors.New("unknown import format")
+
+var parsers = map[string]Parser{}
+
+// Make a parser available under a format name
+func Register(format string, parser Parser) {
+	parsers[format] = parser
+}
+
+// Names of the registered formats
+func Formats() []string {
+	formats := make([]string, 0, len(parsers))
+	for format := range parsers {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// Read entries in the given format
+func Parse(format string, r io.Reader, opts Options) ([]Entry, []RowError, error) {
+	parser, ok := parsers[format]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w %q, expected one of %s", ErrUnknownFormat, format, strings.Join(Formats(), ", "))
+	}
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+	return parser.Parse(r, opts)
+}
+
+func init() {
+	Register("csv", csvParser{})
+	Register("toggl", csvParser{preset: togglMapping})
+	Register("clockify", csvParser{preset: clockifyMapping})
+}
@@ -0,0 +1,213 @@
+package importer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	models "pom/internal/db"
+)
+
+// Ways an imported entry can clash with a session the user already has
+const (
+	ConflictDuplicate = "duplicate" // Starts at the same time as an existing session
+	ConflictOverlap   = "overlap"   // Overlaps an existing session
+)
+
+// Entry as it would be imported
+type PlannedSession struct {
+	Entry
+	Conflict          string `json:"conflict,omitempty"`
+	ConflictSessionID int    `json:"conflict_session_id,omitempty"` // 0 for duplicates within the file
+}
+
+// What an import would do, returned by the preview and replayed on commit
+type Plan struct {
+	Sessions    []PlannedSession `json:"sessions"`
+	NewTags     []string         `json:"new_tags"`
+	NewProjects []string         `json:"new_projects"`
+	Errors      []RowError       `json:"errors"`
+	Conflicts   int              `json:"conflicts"`
+}
+
+// Outcome of a committed import
+type Result struct {
+	SessionsCreated int        `json:"sessions_created"`
+	NotesCreated    int        `json:"notes_created"`
+	ProjectsCreated int        `json:"projects_created"`
+	Skipped         int        `json:"skipped"` // Conflicting entries left out
+	Errors          []RowError `json:"errors"`
+}
+
+// Helper function to get the interval of an existing session
+func sessionInterval(session models.Session) (time.Time, time.Time, bool) {
+	start, err := time.Parse(time.RFC3339, session.StartTime)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	end := start.Add(time.Duration(session.TotalTime) * time.Second)
+	if session.EndTime != nil {
+		if parsed, err := time.Parse(time.RFC3339, *session.EndTime); err == nil {
+			end = parsed
+		}
+	}
+	return start, end, true
+}
+
+// Work out which sessions, tags and projects importing the entries would
+// create for the user, and which entries conflict with existing sessions
+func BuildPlan(userID int, entries []Entry, rowErrors []RowError) (Plan, error) {
+	plan := Plan{
+		Sessions:    []PlannedSession{},
+		NewTags:     []string{},
+		NewProjects: []string{},
+		Errors:      rowErrors,
+	}
+	if plan.Errors == nil {
+		plan.Errors = []RowError{}
+	}
+	if len(entries) == 0 {
+		return plan, nil
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Start.Before(entries[j].Start)
+	})
+
+	// Sessions starting a day before the first entry may still overlap it
+	from := entries[0].Start.UTC().AddDate(0, 0, -1)
+	to := entries[0].End.UTC()
+	for _, entry := range entries {
+		if entry.End.After(to) {
+			to = entry.End.UTC()
+		}
+	}
+	existing, err := models.GetSessionsByDateRangeForUser(from.Format("2006-01-02T15:04:05.000Z"), to.Format("2006-01-02T15:04:05.000Z"), userID, nil)
+	if err != nil {
+		return plan, fmt.Errorf("failed to get existing sessions: %w", err)
+	}
+
+	tags, err := models.GetTagsForUser(userID)
+	if err != nil {
+		return plan, fmt.Errorf("failed to get tags: %w", err)
+	}
+	knownTags := make(map[string]bool)
+	for _, tag := range tags {
+		knownTags[tag.Name] = true
+	}
+
+	projects, err := models.GetProjectsForUser(userID, true)
+	if err != nil {
+		return plan, fmt.Errorf("failed to get projects: %w", err)
+	}
+	knownProjects := make(map[string]bool)
+	for _, project := range projects {
+		knownProjects[project.Name] = true
+	}
+
+	seenStarts := make(map[int64]bool)
+	for _, entry := range entries {
+		planned := PlannedSession{Entry: entry}
+
+		for _, session := range existing {
+			start, end, ok := sessionInterval(session)
+			if !ok {
+				continue
+			}
+			if start.Equal(entry.Start) {
+				planned.Conflict, planned.ConflictSessionID = ConflictDuplicate, session.ID
+				break
+			}
+			if start.Before(entry.End) && entry.Start.Before(end) && planned.Conflict == "" {
+				planned.Conflict, planned.ConflictSessionID = ConflictOverlap, session.ID
+			}
+		}
+		if planned.Conflict == "" && seenStarts[entry.Start.Unix()] {
+			planned.Conflict = ConflictDuplicate
+		}
+		seenStarts[entry.Start.Unix()] = true
+
+		if planned.Conflict != "" {
+			plan.Conflicts++
+		} else {
+			// Conflicting entries are skipped by default, so they don't
+			// count towards the tags and projects that would be created
+			for _, tag := range entry.Tags {
+				if !knownTags[tag] {
+					knownTags[tag] = true
+					plan.NewTags = append(plan.NewTags, tag)
+				}
+			}
+			if entry.Project != "" && !knownProjects[entry.Project] {
+				knownProjects[entry.Project] = true
+				plan.NewProjects = append(plan.NewProjects, entry.Project)
+			}
+		}
+
+		plan.Sessions = append(plan.Sessions, planned)
+	}
+
+	return plan, nil
+}
+
+// Write the sessions of a plan for the user, each together with its note
+// through CreateCompletedSession. Conflicting entries are skipped unless
+// includeConflicts is set. Entries that fail are reported and don't stop the
+// rest of the import.
+func Commit(userID int, plan Plan, includeConflicts bool) (Result, error) {
+	result := Result{Errors: []RowError{}}
+
+	projects, err := models.GetProjectsForUser(userID, true)
+	if err != nil {
+		return result, fmt.Errorf("failed to get projects: %w", err)
+	}
+	projectIDs := make(map[string]int)
+	for _, project := range projects {
+		projectIDs[project.Name] = project.ID
+	}
+
+	for _, planned := range plan.Sessions {
+		if planned.Conflict != "" && !includeConflicts {
+			result.Skipped++
+			continue
+		}
+
+		var projectID *int
+		if planned.Project != "" {
+			id, ok := projectIDs[planned.Project]
+			if !ok {
+				newID, err := models.CreateProject(userID, models.Project{Name: planned.Project})
+				if err != nil {
+					result.Errors = append(result.Errors, RowError{Row: planned.Row, Error: fmt.Sprintf("failed to create project: %v", err)})
+					continue
+				}
+				id = int(newID)
+				projectIDs[planned.Project] = id
+				result.ProjectsCreated++
+			}
+			projectID = &id
+		}
+
+		// Each row is created whole or not at all
+		endTime := planned.End.UTC().Format("2006-01-02T15:04:05.000Z")
+		_, err := models.CreateCompletedSession(models.Session{
+			StartTime: planned.Start.UTC().Format("2006-01-02T15:04:05.000Z"),
+			EndTime:   &endTime,
+			TotalTime: planned.Duration,
+			Tags:      strings.Join(planned.Tags, ","),
+			ProjectID: projectID,
+			Mode:      models.SessionModeClassic,
+		}, userID, planned.Description)
+		if err != nil {
+			result.Errors = append(result.Errors, RowError{Row: planned.Row, Error: fmt.Sprintf("failed to create session: %v", err)})
+			continue
+		}
+		result.SessionsCreated++
+		if planned.Description != "" {
+			result.NotesCreated++
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,144 @@
+package markdown
+
+import (
+	"fmt"
+	models "pom/internal/db"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Name a session's file after its start time, e.g. 2025-03-01-1000-session-12.md
+func SessionFilename(session models.Session) string {
+	prefix := "undated"
+	if start, err := time.Parse(time.RFC3339, session.StartTime); err == nil {
+		prefix = start.Format("2006-01-02-1504")
+	}
+	return fmt.Sprintf("%s-session-%d.md", prefix, session.ID)
+}
+
+// Render a session as Markdown with YAML front matter, followed by its notes
+// in pomodoro order. With note IDs every note is wrapped in HTML comments so
+// that edits to the file can be matched back to the note.
+func Session(session models.Session, withNoteIDs bool) (string, error) {
+	pomodoros, err := models.GetPomodoros(session.ID)
+	if err != nil {
+		return "", err
+	}
+	notes, err := models.GetNotes(session.ID)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "session_id: %d\n", session.ID)
+	fmt.Fprintf(&b, "start: %s\n", yamlString(session.StartTime))
+	if session.EndTime != nil {
+		fmt.Fprintf(&b, "end: %s\n", yamlString(*session.EndTime))
+	} else {
+		b.WriteString("end: null\n")
+	}
+	fmt.Fprintf(&b, "status: %s\n", yamlString(session.Status))
+	fmt.Fprintf(&b, "mode: %s\n", yamlString(session.Mode))
+
+	tags := []string{}
+	for _, tag := range strings.Split(session.Tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, yamlString(tag))
+		}
+	}
+	fmt.Fprintf(&b, "tags: [%s]\n", strings.Join(tags, ", "))
+
+	if session.ProjectID != nil {
+		if project, err := models.GetProject(*session.ProjectID); err == nil {
+			fmt.Fprintf(&b, "project: %s\n", yamlString(project.Name))
+		}
+	}
+
+	durations := []string{}
+	for _, pomodoro := range pomodoros {
+		durations = append(durations, strconv.Itoa(pomodoro.Duration))
+	}
+	fmt.Fprintf(&b, "pomodoros: %d\n", len(pomodoros))
+	fmt.Fprintf(&b, "completed_pomodoros: %d\n", session.Completed)
+	fmt.Fprintf(&b, "pomodoro_durations: [%s]\n", strings.Join(durations, ", ")) // In seconds
+	fmt.Fprintf(&b, "total_time: %d\n", session.TotalTime)
+	b.WriteString("---\n")
+
+	fmt.Fprintf(&b, "\n# Session %s\n", session.StartTime)
+
+	// Order the notes by pomodoro, then by when they were written. Notes that
+	// don't belong to a pomodoro of this session go last.
+	numbers := make(map[int]int)
+	for _, pomodoro := range pomodoros {
+		numbers[pomodoro.ID] = pomodoro.Number
+	}
+	position := func(note models.Note) int {
+		if number, ok := numbers[note.PomodoroID]; ok {
+			return number
+		}
+		return len(pomodoros) + 1
+	}
+	sort.SliceStable(notes, func(i, j int) bool {
+		if position(notes[i]) != position(notes[j]) {
+			return position(notes[i]) < position(notes[j])
+		}
+		return notes[i].CreatedAt < notes[j].CreatedAt
+	})
+
+	heading := ""
+	for _, note := range notes {
+		next := "Other notes"
+		if number, ok := numbers[note.PomodoroID]; ok {
+			next = fmt.Sprintf("Pomodoro %d", number)
+		}
+		if next != heading {
+			heading = next
+			fmt.Fprintf(&b, "\n## %s\n", heading)
+		}
+
+		text := NormalizeNote(note.NoteText)
+		if withNoteIDs {
+			fmt.Fprintf(&b, "\n<!-- note:%d -->\n%s\n<!-- /note:%d -->\n", note.ID, text, note.ID)
+		} else {
+			fmt.Fprintf(&b, "\n%s\n", text)
+		}
+	}
+
+	return b.String(), nil
+}
+
+var noteBlock = regexp.MustCompile(`(?s)<!-- note:(\d+) -->\n(.*?)\n?<!-- /note:(\d+) -->`)
+
+// Extract the notes from a file rendered with note IDs, keyed by note ID
+func ParseNotes(content string) map[int]string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+
+	notes := make(map[int]string)
+	for _, match := range noteBlock.FindAllStringSubmatch(content, -1) {
+		// Skip blocks whose markers were mangled while editing
+		if match[1] != match[3] {
+			continue
+		}
+		id, err := strconv.Atoi(match[1])
+		if err != nil {
+			continue
+		}
+		notes[id] = NormalizeNote(match[2])
+	}
+	return notes
+}
+
+// Note text the way it is written to a file, so that a round trip through
+// an editor doesn't count as a change
+func NormalizeNote(text string) string {
+	return strings.TrimRight(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+}
+
+// Quote a YAML scalar, double quoted Go strings are valid YAML
+func yamlString(value string) string {
+	return strconv.Quote(value)
+}
@@ -0,0 +1,334 @@
+package notesync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	models "pom/internal/db"
+	"pom/internal/markdown"
+)
+
+// Keeps the notes in sync with a folder of Markdown files, such as an
+// Obsidian vault. Every session gets a file under <dir>/<username>/ and edits
+// made to the notes in those files are written back through UpdateNote.
+//
+// The folder is polled rather than watched through filesystem events, which
+// also works on network shares and Docker volumes. A pass only reads files
+// whose size or modification time changed and only re-renders sessions whose
+// data changed, the edits themselves are detected by content hash. Conflicts
+// between a vault edit and a Pomonotes edit made since the last sync are
+// settled by modification time. The losing text is
+// never thrown away: an older vault edit is kept as a conflict copy next to
+// the session's file and an older database edit stays in the note revisions.
+
+// Name of the file the sync state is kept in, inside the synced folder
+const stateFile = ".pomonotes-sync.json"
+
+const defaultInterval = 30 * time.Second
+
+// What a session's file looked like after the last sync
+type fileState struct {
+	Hash    string         `json:"hash"`     // Hash of the whole file
+	Notes   map[int]string `json:"notes"`    // Hash of each note's text
+	ModTime int64          `json:"mod_time"` // Of the file, in nanoseconds
+	Size    int64          `json:"size"`
+	Version string         `json:"version"` // Hash of the session data the file was rendered from
+}
+
+type Worker struct {
+	dir      string
+	interval time.Duration
+	state    map[string]*fileState // Keyed by path relative to dir
+}
+
+func New(dir string, interval time.Duration) *Worker {
+	return &Worker{dir: dir, interval: interval, state: make(map[string]*fileState)}
+}
+
+// Start the worker if NOTES_SYNC_DIR is set, polling every
+// NOTES_SYNC_INTERVAL seconds
+func StartFromEnv() {
+	dir := os.Getenv("NOTES_SYNC_DIR")
+	if dir == "" {
+		return
+	}
+
+	interval := defaultInterval
+	if value := os.Getenv("NOTES_SYNC_INTERVAL"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 1 {
+			log.Printf("Invalid NOTES_SYNC_INTERVAL %q, using %s\n", value, defaultInterval)
+		} else {
+			interval = time.Duration(seconds) * time.Second
+		}
+	}
+
+	if err := New(dir, interval).Start(); err != nil {
+		log.Printf("Notes sync disabled: %v\n", err)
+	}
+}
+
+// Load the previous state and sync in the background until the process exits
+func (w *Worker) Start() error {
+	if err := os.MkdirAll(w.dir, 0o755); err != nil {
+		return err
+	}
+	if err := w.loadState(); err != nil {
+		return err
+	}
+
+	log.Printf("Syncing notes with %s every %s\n", w.dir, w.interval)
+	go func() {
+		for {
+			if err := w.Sync(); err != nil {
+				log.Printf("Error syncing notes: %v\n", err)
+			}
+			time.Sleep(w.interval)
+		}
+	}()
+	return nil
+}
+
+// Run a single sync pass over the sessions of every active user
+func (w *Worker) Sync() error {
+	users, err := models.GetAllUsers()
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, user := range users {
+		if user.AccountStatus == "deleted" {
+			continue
+		}
+
+		sessions, err := models.GetSessionsForUser(user.ID)
+		if err != nil {
+			return err
+		}
+		versions, err := models.GetSessionContentVersions(user.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, session := range sessions {
+			rel := filepath.Join(safeName(user.Username), markdown.SessionFilename(session))
+			seen[rel] = true
+			version, err := json.Marshal(session)
+			if err != nil {
+				return err
+			}
+			if err := w.syncSession(rel, session, hash(string(version)+"|"+versions[session.ID])); err != nil {
+				log.Printf("Error syncing session %d: %v\n", session.ID, err)
+			}
+		}
+	}
+
+	// Remove the files of deleted sessions, unless they were edited since
+	for rel, state := range w.state {
+		if seen[rel] {
+			continue
+		}
+		path := filepath.Join(w.dir, rel)
+		content, err := os.ReadFile(path)
+		if err == nil && hash(string(content)) == state.Hash {
+			if err := os.Remove(path); err != nil {
+				log.Printf("Error removing %s: %v\n", path, err)
+				continue
+			}
+		}
+		delete(w.state, rel)
+	}
+
+	return w.saveState()
+}
+
+// Bring one session's file and notes up to date with each other. version
+// identifies the session data, unchanged data and an untouched file need no
+// work.
+func (w *Worker) syncSession(rel string, session models.Session, version string) error {
+	path := filepath.Join(w.dir, rel)
+	state := w.state[rel]
+
+	info, err := os.Stat(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if state != nil && info != nil && state.Version == version &&
+		info.ModTime().UnixNano() == state.ModTime && info.Size() == state.Size {
+		return nil
+	}
+
+	var current string
+	if info != nil {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		current = string(content)
+
+		// Pick up the edits made to the file since we last wrote it, they
+		// change the data the version was taken from
+		if state == nil || hash(current) != state.Hash {
+			if w.applyEdits(path, info.ModTime(), current, state, session.ID) {
+				version = ""
+			}
+		}
+	}
+
+	rendered, err := markdown.Session(session, true)
+	if err != nil {
+		return err
+	}
+
+	if info == nil || current != rendered {
+		if err := writeFile(path, rendered); err != nil {
+			return err
+		}
+		if info, err = os.Stat(path); err != nil {
+			return err
+		}
+	}
+
+	notes := make(map[int]string)
+	for id, text := range markdown.ParseNotes(rendered) {
+		notes[id] = hash(text)
+	}
+	w.state[rel] = &fileState{
+		Hash:    hash(rendered),
+		Notes:   notes,
+		ModTime: info.ModTime().UnixNano(),
+		Size:    info.Size(),
+		Version: version,
+	}
+	return nil
+}
+
+// Write the notes changed in a file back to the database, reports whether
+// any note was updated
+func (w *Worker) applyEdits(path string, modTime time.Time, content string, state *fileState, sessionID int) bool {
+	updated := false
+	for id, text := range markdown.ParseNotes(content) {
+		note, err := models.GetNote(id)
+		if err != nil || note.SessionID != sessionID {
+			// The note was deleted, or the block was copied from another session
+			continue
+		}
+
+		fileHash := hash(text)
+		dbHash := hash(markdown.NormalizeNote(note.NoteText))
+		if fileHash == dbHash {
+			continue
+		}
+
+		var base string
+		if state != nil {
+			base = state.Notes[id]
+		}
+		if base != "" && fileHash == base {
+			// Only Pomonotes changed the note, the file gets rewritten
+			continue
+		}
+
+		// Both sides changed since the last sync, the newer edit wins
+		if base == "" || dbHash != base {
+			if !modTime.After(noteModified(note)) {
+				if err := writeConflictCopy(path, id, text); err != nil {
+					log.Printf("Error saving conflicting edit of note %d: %v\n", id, err)
+				}
+				continue
+			}
+		}
+
+		// The file gets rewritten from the database, so keep an edit that
+		// couldn't be written back, e.g. because the note was edited
+		// through the API meanwhile
+		if err := models.UpdateNote(id, text, note.Version); err != nil {
+			log.Printf("Error updating note %d from %s: %v\n", id, path, err)
+			if err := writeConflictCopy(path, id, text); err != nil {
+				log.Printf("Error saving conflicting edit of note %d: %v\n", id, err)
+			}
+			continue
+		}
+		log.Printf("Updated note %d from %s\n", id, path)
+		updated = true
+	}
+	return updated
+}
+
+// Helper function to get when a note last changed in the database, a note
+// that was never edited changed when it was created
+func noteModified(note models.Note) time.Time {
+	changedAt := note.CreatedAt
+	if revisions, err := models.GetNoteRevisions(note.ID); err == nil && len(revisions) > 0 {
+		changedAt = revisions[len(revisions)-1].CreatedAt
+	}
+	modified, err := time.Parse("2006-01-02 15:04:05", changedAt)
+	if err != nil {
+		return time.Time{}
+	}
+	return modified
+}
+
+// Helper function to keep a losing vault edit next to the session's file
+func writeConflictCopy(path string, noteID int, text string) error {
+	name := fmt.Sprintf("%s.conflict-note-%d-%s.md", path[:len(path)-len(filepath.Ext(path))], noteID, time.Now().Format("20060102-150405"))
+	log.Printf("Note %d changed in Pomonotes and in %s, keeping the file's version in %s\n", noteID, path, name)
+	return writeFile(name, text+"\n")
+}
+
+func (w *Worker) loadState() error {
+	content, err := os.ReadFile(filepath.Join(w.dir, stateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, &w.state)
+}
+
+func (w *Worker) saveState() error {
+	content, err := json.MarshalIndent(w.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(w.dir, stateFile), string(content))
+}
+
+// Helper function to replace a file in one step, so editors never see half of it
+func writeFile(path string, content string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func hash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// Helper function to turn a username into a folder name
+func safeName(name string) string {
+	name = unsafeChars.ReplaceAllString(name, "_")
+	if name == "" || name == "." || name == ".." {
+		name = "_"
+	}
+	return name
+}
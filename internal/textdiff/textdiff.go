@@ -0,0 +1,165 @@
+package textdiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lines of unchanged context shown around each change
+const DefaultContext = 3
+
+type op struct {
+	kind byte // ' ' unchanged, '-' removed, '+' added
+	text string
+}
+
+// Split text into lines, an empty text has no lines
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Largest LCS table diffLines builds, in cells. Changed regions above it are
+// shown as removed and re-added as a whole.
+const maxTableCells = 1 << 22
+
+// Line by line edit script from a to b based on the longest common subsequence
+func diffLines(a, b []string) []op {
+	// Unchanged lines at the start and end need no table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []op
+	for _, line := range a[:prefix] {
+		ops = append(ops, op{' ', line})
+	}
+	ops = append(ops, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, op{' ', line})
+	}
+	return ops
+}
+
+// Edit script for the changed region between the common prefix and suffix
+func diffMiddle(a, b []string) []op {
+	if (len(a)+1)*(len(b)+1) > maxTableCells {
+		ops := make([]op, 0, len(a)+len(b))
+		for _, line := range a {
+			ops = append(ops, op{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, op{'+', line})
+		}
+		return ops
+	}
+
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []op
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, op{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, op{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, op{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, op{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, op{'+', b[j]})
+	}
+	return ops
+}
+
+// Unified diff between two texts, empty if they are the same
+func Unified(a, b, fromName, toName string, context int) string {
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	// Line positions in a and b before each op
+	aPos := make([]int, len(ops)+1)
+	bPos := make([]int, len(ops)+1)
+	var changes []int
+	for k, o := range ops {
+		aPos[k+1], bPos[k+1] = aPos[k], bPos[k]
+		if o.kind != '+' {
+			aPos[k+1]++
+		}
+		if o.kind != '-' {
+			bPos[k+1]++
+		}
+		if o.kind != ' ' {
+			changes = append(changes, k)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	for c := 0; c < len(changes); {
+		// Grow the hunk while the next change is close enough to share context
+		last := c
+		for last+1 < len(changes) && changes[last+1]-changes[last] <= 2*context+1 {
+			last++
+		}
+		start := max(changes[c]-context, 0)
+		end := min(changes[last]+context+1, len(ops))
+
+		aCount := aPos[end] - aPos[start]
+		bCount := bPos[end] - bPos[start]
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aPos[start], aCount), hunkRange(bPos[start], bCount))
+		for _, o := range ops[start:end] {
+			out.WriteByte(o.kind)
+			out.WriteString(o.text)
+			out.WriteByte('\n')
+		}
+
+		c = last + 1
+	}
+
+	return out.String()
+}
+
+// Format the line range of a hunk the way diff -u does
+func hunkRange(before, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", before)
+	case 1:
+		return fmt.Sprintf("%d", before+1)
+	default:
+		return fmt.Sprintf("%d,%d", before+1, count)
+	}
+}
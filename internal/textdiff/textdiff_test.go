@@ -0,0 +1,100 @@
+package textdiff
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := map[string]struct {
+		a, b string
+		want string
+	}{
+		"same": {
+			a:    "one\ntwo\n",
+			b:    "one\ntwo\n",
+			want: "",
+		},
+		"both empty": {
+			a:    "",
+			b:    "",
+			want: "",
+		},
+		"from empty": {
+			a:    "",
+			b:    "one\ntwo\n",
+			want: "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+one\n+two\n",
+		},
+		"to empty": {
+			a:    "one\n",
+			b:    "",
+			want: "--- a\n+++ b\n@@ -1 +0,0 @@\n-one\n",
+		},
+		"insert only": {
+			a:    "1\n2\n3\n",
+			b:    "1\n2\nnew\n3\n",
+			want: "--- a\n+++ b\n@@ -1,3 +1,4 @@\n 1\n 2\n+new\n 3\n",
+		},
+		"delete only": {
+			a:    "1\n2\n3\n4\n",
+			b:    "1\n4\n",
+			want: "--- a\n+++ b\n@@ -1,4 +1,2 @@\n 1\n-2\n-3\n 4\n",
+		},
+		"no trailing newline": {
+			a:    "one\ntwo",
+			b:    "one\ntwo\n",
+			want: "",
+		},
+		"change without trailing newline": {
+			a:    "one\ntwo",
+			b:    "one\nthree",
+			want: "--- a\n+++ b\n@@ -1,2 +1,2 @@\n one\n-two\n+three\n",
+		},
+		"separate hunks": {
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			b:    "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			want: "--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n",
+		},
+	}
+	for name, test := range tests {
+		if got := Unified(test.a, test.b, "a", "b", DefaultContext); got != test.want {
+			t.Errorf("%s: Unified = %q, want %q", name, got, test.want)
+		}
+	}
+}
+
+func TestDiffLinesTableBound(t *testing.T) {
+	// Just too many lines for the LCS table, without a common prefix or suffix
+	n := 2048
+	a := make([]string, n)
+	b := make([]string, n)
+	for i := range a {
+		a[i] = fmt.Sprintf("line %d", i)
+		b[i] = fmt.Sprintf("line %d", n-1-i)
+	}
+	if (n+1)*(n+1) <= maxTableCells {
+		t.Fatalf("%d lines fit the %d cell table", n, maxTableCells)
+	}
+
+	ops := diffLines(a, b)
+	if len(ops) != 2*n {
+		t.Fatalf("got %d ops, want %d", len(ops), 2*n)
+	}
+	for k, o := range ops {
+		want := byte('-')
+		if k >= n {
+			want = '+'
+		}
+		if o.kind != want {
+			t.Fatalf("op %d = %q, want %q", k, o.kind, want)
+		}
+	}
+
+	// A common prefix and suffix stay unchanged however large the texts
+	a = append([]string{"head"}, append(a, "tail")...)
+	b = append([]string{"head"}, append(b, "tail")...)
+	ops = diffLines(a, b)
+	if ops[0] != (op{' ', "head"}) || ops[len(ops)-1] != (op{' ', "tail"}) {
+		t.Errorf("prefix and suffix not kept: %v ... %v", ops[0], ops[len(ops)-1])
+	}
+}
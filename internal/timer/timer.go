@@ -0,0 +1,680 @@
+package timer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	models "pom/internal/db"
+)
+
+// Phase describes what a user's timer is currently doing
+type Phase string
+
+const (
+	PhaseIdle       Phase = "idle"
+	PhaseWork       Phase = "work"
+	PhaseShortBreak Phase = "short_break"
+	PhaseLongBreak  Phase = "long_break"
+	PhasePaused     Phase = "paused"
+	PhaseOvertime   Phase = "overtime"
+)
+
+// Timestamp layout used by the frontend (Date.toISOString)
+const timeLayout = "2006-01-02T15:04:05.000Z"
+
+var (
+	ErrNoTimer        = errors.New("no timer is running")
+	ErrAlreadyRunning = errors.New("a timer is already running")
+	ErrPaused         = errors.New("timer is paused")
+	ErrNotPaused      = errors.New("timer is not paused")
+)
+
+// Settings controls the length of each interval, all lengths are in seconds
+type Settings struct {
+	WorkLength       int
+	ShortBreakLength int
+	LongBreakLength  int
+	LongBreakEvery   int // Number of pomodoros before a long break
+
+	// Move on automatically once an interval runs out instead of going into overtime
+	AutoStartBreaks    bool
+	AutoStartPomodoros bool
+
+	// Break length as a fraction of the focus time of a flowtime pomodoro
+	FlowBreakRatio float64
+}
+
+// Classic 25/5/15 pomodoro cycle with a long break every 4 pomodoros
+var DefaultSettings = Settings{
+	WorkLength:       25 * 60,
+	ShortBreakLength: 5 * 60,
+	LongBreakLength:  15 * 60,
+	LongBreakEvery:   4,
+	FlowBreakRatio:   0.2,
+}
+
+// Build timer settings from a stored profile
+func SettingsFromProfile(profile models.TimerProfile) Settings {
+	return Settings{
+		WorkLength:         profile.WorkLength,
+		ShortBreakLength:   profile.ShortBreakLength,
+		LongBreakLength:    profile.LongBreakLength,
+		LongBreakEvery:     profile.LongBreakEvery,
+		AutoStartBreaks:    profile.AutoStartBreaks,
+		AutoStartPomodoros: profile.AutoStartPomodoros,
+		FlowBreakRatio:     profile.FlowBreakRatio,
+	}
+}
+
+// Shortest break ever proposed after a flowtime pomodoro, in seconds
+const minFlowBreak = 60
+
+// ProposeBreak returns the break length in seconds that should follow a
+// flowtime pomodoro of the given focus time
+func ProposeBreak(focusSeconds int, ratio float64) int {
+	if ratio <= 0 {
+		ratio = DefaultSettings.FlowBreakRatio
+	}
+
+	breakLength := int(math.Round(float64(focusSeconds) * ratio))
+	if breakLength < minFlowBreak {
+		return minFlowBreak
+	}
+	return breakLength
+}
+
+// Options for starting a new session
+type StartOptions struct {
+	Tags      string
+	ProfileID *int   // Recorded on the session, nil for the built-in settings
+	Mode      string // models.SessionModeClassic or models.SessionModeFlowtime
+	ProjectID *int
+	TaskID    *int // Task the pomodoros are spent on
+	Settings  Settings
+}
+
+// Snapshot is the client facing view of a user's timer
+type Snapshot struct {
+	Active     bool   `json:"active"`
+	SessionID  int    `json:"session_id,omitempty"`
+	Mode       string `json:"mode,omitempty"`
+	Phase      Phase  `json:"phase"`              // Effective phase, including paused and overtime
+	Interval   Phase  `json:"interval,omitempty"` // Underlying work/short_break/long_break interval
+	Number     int    `json:"number"`             // Pomodoro number within the session
+	Completed  int    `json:"completed_pomodoros"`
+	PomodoroID int    `json:"pomodoro_id,omitempty"`
+	BreakID    int    `json:"break_id,omitempty"`
+	Length     int    `json:"length"`    // In seconds
+	Elapsed    int    `json:"elapsed"`   // In seconds
+	Remaining  int    `json:"remaining"` // In seconds, zero once in overtime
+	Overtime   int    `json:"overtime"`  // In seconds past the interval length
+	Tags       string `json:"tags"`
+	ProfileID  *int   `json:"profile_id,omitempty"`
+	TaskID     *int   `json:"task_id,omitempty"`
+}
+
+// Per-user timer state
+type state struct {
+	sessionID  int
+	profileID  *int
+	taskID     *int
+	mode       string
+	settings   Settings
+	breakLen   int   // Length of the current flowtime break
+	interval   Phase // PhaseWork, PhaseShortBreak or PhaseLongBreak
+	number     int
+	completed  int
+	pomodoroID int
+	breakID    int
+	started    time.Time // When the interval was started or last resumed
+	banked     int       // Seconds elapsed before the last pause
+	paused     bool
+	workTime   int // Seconds worked in finished pomodoros
+	lastFocus  int // Seconds worked in the last finished pomodoro
+
+	// Transitions only change the state, nothing is written. Used to show
+	// where a timer is without recording its auto-start transitions.
+	dryRun bool
+}
+
+// Form a timer state is saved in
+type savedState struct {
+	SessionID  int       `json:"session_id"`
+	ProfileID  *int      `json:"profile_id"`
+	TaskID     *int      `json:"task_id"`
+	Mode       string    `json:"mode"`
+	Settings   Settings  `json:"settings"`
+	BreakLen   int       `json:"break_length"`
+	Interval   Phase     `json:"interval"`
+	Number     int       `json:"number"`
+	Completed  int       `json:"completed"`
+	PomodoroID int       `json:"pomodoro_id"`
+	BreakID    int       `json:"break_id"`
+	Started    time.Time `json:"started"`
+	Banked     int       `json:"banked"`
+	Paused     bool      `json:"paused"`
+	WorkTime   int       `json:"work_time"`
+	LastFocus  int       `json:"last_focus"`
+}
+
+func (s *state) marshal() (string, error) {
+	data, err := json.Marshal(savedState{
+		SessionID: s.sessionID, ProfileID: s.profileID, TaskID: s.taskID, Mode: s.mode, Settings: s.settings,
+		BreakLen: s.breakLen, Interval: s.interval, Number: s.number, Completed: s.completed,
+		PomodoroID: s.pomodoroID, BreakID: s.breakID, Started: s.started, Banked: s.banked, Paused: s.paused,
+		WorkTime: s.workTime, LastFocus: s.lastFocus,
+	})
+	return string(data), err
+}
+
+func unmarshalState(data string) (*state, error) {
+	var saved savedState
+	if err := json.Unmarshal([]byte(data), &saved); err != nil {
+		return nil, err
+	}
+	return &state{
+		sessionID: saved.SessionID, profileID: saved.ProfileID, taskID: saved.TaskID, mode: saved.Mode, settings: saved.Settings,
+		breakLen: saved.BreakLen, interval: saved.Interval, number: saved.Number, completed: saved.Completed,
+		pomodoroID: saved.PomodoroID, breakID: saved.BreakID, started: saved.Started, banked: saved.Banked, paused: saved.Paused,
+		workTime: saved.WorkTime, lastFocus: saved.LastFocus,
+	}, nil
+}
+
+// Engine owns the pomodoro cycle of every user and writes each transition
+// to the sessions, pomodoros and breaks tables
+type Engine struct {
+	mu     sync.Mutex
+	states map[int]*state
+	now    func() time.Time
+}
+
+func NewEngine() *Engine {
+	return &Engine{
+		states: make(map[int]*state),
+		now:    time.Now,
+	}
+}
+
+// Restore picks up the timers that were running when the server stopped,
+// their intervals kept running meanwhile. Timers whose session was deleted
+// or closed since are dropped, a session whose timer can't be read is closed.
+func (e *Engine) Restore() error {
+	saved, err := models.GetTimerStates()
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, t := range saved {
+		session, err := models.GetSession(t.SessionID)
+		if err != nil || session.Status != "running" {
+			models.DeleteTimerState(t.UserID)
+			continue
+		}
+
+		s, err := unmarshalState(t.State)
+		if err != nil {
+			log.Printf("Error restoring timer for user %d, closing session %d: %v", t.UserID, t.SessionID, err)
+			endTime := e.now().UTC().Format(timeLayout)
+			models.UpdateSessionProgress(t.SessionID, &endTime, session.TotalTime, "stopped", session.Completed)
+			models.DeleteTimerState(t.UserID)
+			continue
+		}
+		e.states[t.UserID] = s
+	}
+	return nil
+}
+
+// Save the timer of a user so that it survives a restart
+func (e *Engine) save(userID int, s *state) {
+	data, err := s.marshal()
+	if err == nil {
+		err = models.SaveTimerState(userID, s.sessionID, data)
+	}
+	if err != nil {
+		log.Printf("Error saving timer for user %d: %v", userID, err)
+	}
+}
+
+// Helper function to add the session's tags to a snapshot. Tags can be
+// edited on the session while the timer runs, so they are read outside the
+// engine's lock.
+func withTags(snap Snapshot, err error) (Snapshot, error) {
+	if err != nil || !snap.Active {
+		return snap, err
+	}
+	if session, err := models.GetSession(snap.SessionID); err == nil {
+		snap.Tags = session.Tags
+	}
+	return snap, nil
+}
+
+// Drop the timer running a session that no longer exists, so its user can
+// start a new one
+func (e *Engine) drop(userID int) {
+	delete(e.states, userID)
+	if err := models.DeleteTimerState(userID); err != nil {
+		log.Printf("Error removing saved timer for user %d: %v", userID, err)
+	}
+}
+
+// DropSession forgets the timer of a session being deleted
+func (e *Engine) DropSession(sessionID int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for userID, s := range e.states {
+		if s.sessionID == sessionID {
+			e.drop(userID)
+		}
+	}
+}
+
+// Status returns the current timer of the user. Auto-start transitions that
+// became due are shown but only recorded by the next change to the timer.
+func (e *Engine) Status(userID int) Snapshot {
+	snap, _ := withTags(e.status(userID), nil)
+	return snap
+}
+
+func (e *Engine) status(userID int) Snapshot {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s, ok := e.states[userID]
+	if !ok {
+		return Snapshot{Phase: PhaseIdle}
+	}
+
+	now := e.now()
+	projected := *s
+	projected.dryRun = true
+	projected.advance(now)
+	return projected.snapshot(now)
+}
+
+// Start opens a new session and its first pomodoro
+func (e *Engine) Start(userID int, opts StartOptions) (Snapshot, error) {
+	return withTags(e.start(userID, opts))
+}
+
+func (e *Engine) start(userID int, opts StartOptions) (Snapshot, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.states[userID]; ok {
+		return Snapshot{}, ErrAlreadyRunning
+	}
+
+	now := e.now()
+	sessionID, err := models.CreateSessionWithUser(models.Session{
+		StartTime: now.UTC().Format(timeLayout),
+		Tags:      opts.Tags,
+		ProfileID: opts.ProfileID,
+		Mode:      opts.Mode,
+		ProjectID: opts.ProjectID,
+	}, userID)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	s := &state{
+		sessionID: int(sessionID),
+		profileID: opts.ProfileID,
+		taskID:    opts.TaskID,
+		mode:      opts.Mode,
+		settings:  opts.Settings,
+	}
+	if err := s.beginWork(now); err != nil {
+		return Snapshot{}, err
+	}
+
+	e.states[userID] = s
+	e.save(userID, s)
+	return s.snapshot(now), nil
+}
+
+// SetTask switches the task the pomodoros are spent on, including the
+// running pomodoro. Nil stops booking them against a task.
+func (e *Engine) SetTask(userID int, taskID *int) (Snapshot, error) {
+	return withTags(e.setTask(userID, taskID))
+}
+
+func (e *Engine) setTask(userID int, taskID *int) (Snapshot, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s, now, err := e.current(userID)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	if s.interval == PhaseWork && s.pomodoroID != 0 {
+		if err := models.SetPomodoroTask(s.pomodoroID, taskID); err != nil {
+			return Snapshot{}, fmt.Errorf("failed to update pomodoro: %w", err)
+		}
+	}
+	s.taskID = taskID
+	e.save(userID, s)
+	return s.snapshot(now), nil
+}
+
+// Pause freezes the running interval
+func (e *Engine) Pause(userID int) (Snapshot, error) {
+	return withTags(e.pause(userID))
+}
+
+func (e *Engine) pause(userID int) (Snapshot, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s, now, err := e.current(userID)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if s.paused {
+		return Snapshot{}, ErrPaused
+	}
+
+	s.banked = s.elapsed(now)
+	s.paused = true
+	e.save(userID, s)
+	return s.snapshot(now), nil
+}
+
+// Resume continues a paused interval
+func (e *Engine) Resume(userID int) (Snapshot, error) {
+	return withTags(e.resume(userID))
+}
+
+func (e *Engine) resume(userID int) (Snapshot, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s, now, err := e.current(userID)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if !s.paused {
+		return Snapshot{}, ErrNotPaused
+	}
+
+	s.started = now
+	s.paused = false
+	e.save(userID, s)
+	return s.snapshot(now), nil
+}
+
+// Skip ends the current interval and moves on to the next one
+func (e *Engine) Skip(userID int) (Snapshot, error) {
+	return withTags(e.skip(userID))
+}
+
+func (e *Engine) skip(userID int) (Snapshot, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s, now, err := e.current(userID)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	if err := e.check(userID, s.finishInterval(now)); err != nil {
+		return Snapshot{}, err
+	}
+
+	if s.interval == PhaseWork {
+		err = s.beginBreak(now)
+	} else {
+		err = s.beginWork(now)
+	}
+	if err := e.check(userID, err); err != nil {
+		return Snapshot{}, err
+	}
+
+	e.save(userID, s)
+	return s.snapshot(now), nil
+}
+
+// Stop ends the current interval and closes the session
+func (e *Engine) Stop(userID int) (Snapshot, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s, now, err := e.current(userID)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	if err := e.check(userID, s.finishInterval(now)); err != nil {
+		return Snapshot{}, err
+	}
+
+	endTime := now.UTC().Format(timeLayout)
+	err = models.UpdateSessionProgress(s.sessionID, &endTime, s.workTime, "stopped", s.completed)
+	if err := e.check(userID, err); err != nil {
+		return Snapshot{}, fmt.Errorf("failed to close session: %w", err)
+	}
+
+	e.drop(userID)
+	return Snapshot{SessionID: s.sessionID, Phase: PhaseIdle, Completed: s.completed}, nil
+}
+
+// Helper function to get the timer of a user with its due transitions applied
+func (e *Engine) current(userID int) (*state, time.Time, error) {
+	s, ok := e.states[userID]
+	if !ok {
+		return nil, time.Time{}, ErrNoTimer
+	}
+	now := e.now()
+	if err := e.check(userID, s.advance(now)); err != nil {
+		return nil, now, err
+	}
+	return s, now, nil
+}
+
+// Helper function to drop a timer whose session, pomodoro or break was
+// deleted underneath it. The user then has no timer anymore.
+func (e *Engine) check(userID int, err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Session of the timer of user %d is gone, dropping the timer", userID)
+		e.drop(userID)
+		return ErrNoTimer
+	}
+	return err
+}
+
+// Seconds elapsed in the current interval
+func (s *state) elapsed(now time.Time) int {
+	if s.paused {
+		return s.banked
+	}
+	return s.banked + int(now.Sub(s.started).Seconds())
+}
+
+// Length of the current interval in seconds, zero for a count-up pomodoro
+func (s *state) length() int {
+	if s.mode == models.SessionModeFlowtime {
+		if s.interval == PhaseWork {
+			return 0
+		}
+		return s.breakLen
+	}
+
+	switch s.interval {
+	case PhaseShortBreak:
+		return s.settings.ShortBreakLength
+	case PhaseLongBreak:
+		return s.settings.LongBreakLength
+	default:
+		return s.settings.WorkLength
+	}
+}
+
+func (s *state) snapshot(now time.Time) Snapshot {
+	elapsed := s.elapsed(now)
+	length := s.length()
+
+	snap := Snapshot{
+		Active:     true,
+		SessionID:  s.sessionID,
+		Mode:       s.mode,
+		Phase:      s.interval,
+		Interval:   s.interval,
+		Number:     s.number,
+		Completed:  s.completed,
+		PomodoroID: s.pomodoroID,
+		BreakID:    s.breakID,
+		Length:     length,
+		Elapsed:    elapsed,
+		ProfileID:  s.profileID,
+		TaskID:     s.taskID,
+	}
+
+	// A count-up pomodoro has no length to run over
+	if length > 0 && elapsed >= length {
+		snap.Overtime = elapsed - length
+		snap.Phase = PhaseOvertime
+	} else if length > 0 {
+		snap.Remaining = length - elapsed
+	}
+
+	// Paused takes precedence over overtime
+	if s.paused {
+		snap.Phase = PhasePaused
+	}
+
+	return snap
+}
+
+// Start the next pomodoro in the session
+func (s *state) beginWork(now time.Time) error {
+	var pomodoroID int64
+	if !s.dryRun {
+		var err error
+		pomodoroID, err = models.CreatePomodoro(s.sessionID, s.number+1, now.UTC().Format(timeLayout), "running", s.taskID)
+		if err != nil {
+			return fmt.Errorf("failed to create pomodoro: %w", err)
+		}
+	}
+
+	s.number++
+	s.pomodoroID = int(pomodoroID)
+	s.breakID = 0
+	s.interval = PhaseWork
+	s.resetClock(now)
+	return nil
+}
+
+// Start the break following the current pomodoro
+func (s *state) beginBreak(now time.Time) error {
+	interval, breakType := PhaseShortBreak, "short"
+	if s.settings.LongBreakEvery > 0 && s.number%s.settings.LongBreakEvery == 0 {
+		interval, breakType = PhaseLongBreak, "long"
+	}
+	if s.mode == models.SessionModeFlowtime {
+		s.breakLen = ProposeBreak(s.lastFocus, s.settings.FlowBreakRatio)
+	}
+
+	var breakID int64
+	if !s.dryRun {
+		var err error
+		breakID, err = models.CreateBreak(s.sessionID, s.pomodoroID, breakType, now.UTC().Format(timeLayout), "running")
+		if err != nil {
+			return fmt.Errorf("failed to create break: %w", err)
+		}
+	}
+
+	s.breakID = int(breakID)
+	s.interval = interval
+	s.resetClock(now)
+	return nil
+}
+
+// Close the running pomodoro or break row
+func (s *state) finishInterval(now time.Time) error {
+	elapsed := s.elapsed(now)
+	endTime := now.UTC().Format(timeLayout)
+
+	status := "stopped"
+	if elapsed >= s.length() {
+		status = "completed"
+	}
+
+	if s.interval != PhaseWork {
+		if s.dryRun {
+			return nil
+		}
+		if err := models.UpdateBreak(s.breakID, endTime, elapsed, status, 0); err != nil {
+			return fmt.Errorf("failed to update break: %w", err)
+		}
+		return nil
+	}
+
+	if !s.dryRun {
+		if err := models.UpdatePomodoro(s.pomodoroID, endTime, elapsed, status, 0); err != nil {
+			return fmt.Errorf("failed to update pomodoro: %w", err)
+		}
+	}
+
+	s.workTime += elapsed
+	s.lastFocus = elapsed
+	if status == "completed" {
+		s.completed++
+	}
+
+	// Keep the session row in step with the pomodoros
+	if s.dryRun {
+		return nil
+	}
+	if err := models.UpdateSessionProgress(s.sessionID, nil, s.workTime, "running", s.completed); err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+	return nil
+}
+
+// Apply the auto-start transitions that became due since the last call
+func (s *state) advance(now time.Time) error {
+	for !s.paused {
+		autoStart := s.settings.AutoStartPomodoros
+		if s.interval == PhaseWork {
+			autoStart = s.settings.AutoStartBreaks
+		}
+
+		remaining := s.length() - s.elapsed(now)
+		if !autoStart || remaining > 0 || s.length() == 0 {
+			return nil
+		}
+
+		// Transition at the moment the interval ran out, not at now
+		at := now.Add(time.Duration(remaining) * time.Second)
+		if err := s.finishInterval(at); err != nil {
+			return err
+		}
+
+		var err error
+		if s.interval == PhaseWork {
+			err = s.beginBreak(at)
+		} else {
+			err = s.beginWork(at)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *state) resetClock(now time.Time) {
+	s.started = now
+	s.banked = 0
+	s.paused = false
+}
@@ -0,0 +1,243 @@
+package timer
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	models "pom/internal/db"
+)
+
+func TestMain(m *testing.M) {
+	// InitDB opens pomonotes.db in the working directory
+	dir, err := os.MkdirTemp("", "timer")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	models.InitDB()
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// Helper function to get an engine running on a clock the test moves
+func newTestEngine(t *testing.T) (*Engine, func(d time.Duration)) {
+	t.Helper()
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	e := NewEngine()
+	e.now = func() time.Time { return now }
+	return e, func(d time.Duration) { now = now.Add(d) }
+}
+
+// Helper function to create a user to run timers for
+func newTestUser(t *testing.T) int {
+	t.Helper()
+	id, err := models.CreateUser(models.UserInput{Username: t.Name(), Password: "password1"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return int(id)
+}
+
+func testOptions(settings Settings) StartOptions {
+	return StartOptions{Mode: models.SessionModeClassic, Settings: settings}
+}
+
+func TestStartPauseResume(t *testing.T) {
+	e, advance := newTestEngine(t)
+	userID := newTestUser(t)
+
+	snap, err := e.Start(userID, testOptions(DefaultSettings))
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if snap.Phase != PhaseWork || snap.Number != 1 || snap.PomodoroID == 0 {
+		t.Errorf("Start = %+v, want first work interval", snap)
+	}
+	if _, err := e.Start(userID, testOptions(DefaultSettings)); !errors.Is(err, ErrAlreadyRunning) {
+		t.Errorf("second Start err = %v, want %v", err, ErrAlreadyRunning)
+	}
+
+	advance(10 * time.Minute)
+	snap, err = e.Pause(userID)
+	if err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	if snap.Phase != PhasePaused || snap.Elapsed != 600 {
+		t.Errorf("Pause = %s at %ds, want %s at 600s", snap.Phase, snap.Elapsed, PhasePaused)
+	}
+	if _, err := e.Pause(userID); !errors.Is(err, ErrPaused) {
+		t.Errorf("second Pause err = %v, want %v", err, ErrPaused)
+	}
+
+	// Paused time doesn't count
+	advance(time.Hour)
+	snap, err = e.Resume(userID)
+	if err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if snap.Phase != PhaseWork || snap.Elapsed != 600 || snap.Remaining != 900 {
+		t.Errorf("Resume = %s at %ds with %ds left, want %s at 600s with 900s left",
+			snap.Phase, snap.Elapsed, snap.Remaining, PhaseWork)
+	}
+	if _, err := e.Resume(userID); !errors.Is(err, ErrNotPaused) {
+		t.Errorf("second Resume err = %v, want %v", err, ErrNotPaused)
+	}
+
+	advance(20 * time.Minute)
+	if snap := e.Status(userID); snap.Phase != PhaseOvertime || snap.Overtime != 300 {
+		t.Errorf("Status = %s with %ds overtime, want %s with 300s", snap.Phase, snap.Overtime, PhaseOvertime)
+	}
+}
+
+func TestSkipCycle(t *testing.T) {
+	e, advance := newTestEngine(t)
+	userID := newTestUser(t)
+
+	settings := DefaultSettings
+	settings.LongBreakEvery = 2
+	if _, err := e.Start(userID, testOptions(settings)); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	want := []struct {
+		phase     Phase
+		number    int
+		completed int
+	}{
+		{PhaseShortBreak, 1, 1},
+		{PhaseWork, 2, 1},
+		{PhaseLongBreak, 2, 2},
+		{PhaseWork, 3, 2},
+	}
+	for i, w := range want {
+		advance(30 * time.Minute)
+		snap, err := e.Skip(userID)
+		if err != nil {
+			t.Fatalf("Skip %d: %v", i, err)
+		}
+		if snap.Interval != w.phase || snap.Number != w.number || snap.Completed != w.completed {
+			t.Errorf("Skip %d = %s #%d with %d completed, want %s #%d with %d completed",
+				i, snap.Interval, snap.Number, snap.Completed, w.phase, w.number, w.completed)
+		}
+	}
+}
+
+func TestStatusDoesNotWrite(t *testing.T) {
+	e, advance := newTestEngine(t)
+	userID := newTestUser(t)
+
+	settings := DefaultSettings
+	settings.AutoStartBreaks = true
+	snap, err := e.Start(userID, testOptions(settings))
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	sessionID := snap.SessionID
+
+	advance(27 * time.Minute)
+	snap = e.Status(userID)
+	if snap.Phase != PhaseShortBreak || snap.Elapsed != 120 || snap.Completed != 1 {
+		t.Errorf("Status = %s at %ds with %d completed, want %s at 120s with 1 completed",
+			snap.Phase, snap.Elapsed, snap.Completed, PhaseShortBreak)
+	}
+	breaks, err := models.GetBreaks(sessionID)
+	if err != nil {
+		t.Fatalf("GetBreaks: %v", err)
+	}
+	if len(breaks) != 0 {
+		t.Errorf("Status recorded %d breaks, want none", len(breaks))
+	}
+
+	// The next change records the break from when the pomodoro ran out
+	if _, err := e.Pause(userID); err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	breaks, err = models.GetBreaks(sessionID)
+	if err != nil {
+		t.Fatalf("GetBreaks: %v", err)
+	}
+	if len(breaks) != 1 || breaks[0].StartTime != "2026-03-02T09:25:00.000Z" {
+		t.Errorf("breaks = %+v, want one starting at 09:25", breaks)
+	}
+}
+
+func TestStop(t *testing.T) {
+	e, advance := newTestEngine(t)
+	userID := newTestUser(t)
+
+	snap, err := e.Start(userID, testOptions(DefaultSettings))
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	sessionID := snap.SessionID
+
+	advance(10 * time.Minute)
+	snap, err = e.Stop(userID)
+	if err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if snap.Phase != PhaseIdle || snap.SessionID != sessionID {
+		t.Errorf("Stop = %+v, want idle for session %d", snap, sessionID)
+	}
+
+	session, err := models.GetSession(sessionID)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if session.Status != "stopped" || session.TotalTime != 600 || session.Completed != 0 {
+		t.Errorf("session = %s with %ds and %d completed, want stopped with 600s and 0 completed",
+			session.Status, session.TotalTime, session.Completed)
+	}
+
+	if snap := e.Status(userID); snap.Active {
+		t.Errorf("Status after Stop = %+v, want idle", snap)
+	}
+	if _, err := e.Stop(userID); !errors.Is(err, ErrNoTimer) {
+		t.Errorf("second Stop err = %v, want %v", err, ErrNoTimer)
+	}
+}
+
+func TestStopDeletedSession(t *testing.T) {
+	e, advance := newTestEngine(t)
+	userID := newTestUser(t)
+
+	snap, err := e.Start(userID, testOptions(DefaultSettings))
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := models.DeleteSession(snap.SessionID); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+
+	advance(time.Minute)
+	if _, err := e.Stop(userID); !errors.Is(err, ErrNoTimer) {
+		t.Errorf("Stop err = %v, want %v", err, ErrNoTimer)
+	}
+	if _, err := e.Start(userID, testOptions(DefaultSettings)); err != nil {
+		t.Errorf("Start after the session was deleted: %v", err)
+	}
+}
+
+func TestDropSession(t *testing.T) {
+	e, _ := newTestEngine(t)
+	userID := newTestUser(t)
+
+	snap, err := e.Start(userID, testOptions(DefaultSettings))
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	e.DropSession(snap.SessionID)
+
+	if snap := e.Status(userID); snap.Active {
+		t.Errorf("Status after DropSession = %+v, want idle", snap)
+	}
+	if _, err := e.Start(userID, testOptions(DefaultSettings)); err != nil {
+		t.Errorf("Start after DropSession: %v", err)
+	}
+}